@@ -1,8 +1,11 @@
 import { Module } from '@nestjs/common';
+import { JwtModule } from '@nestjs/jwt';
 import { WingsService } from './wings.service';
 import { WingsController } from './wings.controller';
 
 @Module({
+  // Tokens are signed per node with its daemon token, so no module-wide secret
+  imports: [JwtModule.register({})],
   providers: [WingsService],
   controllers: [WingsController],
   exports: [WingsService],
@@ -1,15 +1,22 @@
 import { Injectable, Inject, NotFoundException } from '@nestjs/common';
+import { ConfigService } from '@nestjs/config';
+import { JwtService } from '@nestjs/jwt';
 import { DATABASE_CONNECTION } from '../common/database/database.module';
 import { wingsNodes } from '@mambaPanel/db';
 import { eq } from '@mambaPanel/db';
 import type { Database } from '@mambaPanel/db';
 import type { CreateWingsNodeInput, UpdateWingsNodeInput } from '@mambaPanel/types';
 
+// Wings rejects tokens without an expiry, so each request gets a fresh one
+const WINGS_TOKEN_LIFETIME = '60s';
+
 @Injectable()
 export class WingsService {
   constructor(
     @Inject(DATABASE_CONNECTION)
-    private dbConnection: { db: Database }
+    private dbConnection: { db: Database },
+    private jwtService: JwtService,
+    private configService: ConfigService
   ) {}
 
   async create(data: CreateWingsNodeInput & { daemonToken: string }) {
@@ -66,11 +73,12 @@ export class WingsService {
   async sendRequest(nodeId: string, path: string, method = 'GET', body?: any) {
     const node = await this.findById(nodeId);
     const url = `${node.scheme}://${node.fqdn}:${node.port}${path}`;
+    const token = await this.signNodeToken(node.daemonToken);
 
     const response = await fetch(url, {
       method,
       headers: {
-        Authorization: `Bearer ${node.daemonToken}`,
+        Authorization: `Bearer ${token}`,
         'Content-Type': 'application/json',
       },
       body: body ? JSON.stringify(body) : undefined,
@@ -82,4 +90,20 @@ export class WingsService {
 
     return response.json();
   }
+
+  /**
+   * Sign a short-lived token for the panel's own calls to a node. It holds
+   * every Wings permission and no server_id, so it reaches every server.
+   */
+  private signNodeToken(daemonToken: string) {
+    return this.jwtService.signAsync(
+      { permissions: ['*'] },
+      {
+        secret: daemonToken,
+        algorithm: 'HS256',
+        audience: this.configService.get<string>('WINGS_TOKEN_AUDIENCE') || 'wings',
+        expiresIn: WINGS_TOKEN_LIFETIME,
+      }
+    );
+  }
 }
@@ -10,10 +10,15 @@ import (
 
 	"github.com/mambapanel/wings/internal/api"
 	"github.com/mambapanel/wings/internal/config"
+	"github.com/mambapanel/wings/internal/console"
 	"github.com/mambapanel/wings/internal/crashguard"
 	"github.com/mambapanel/wings/internal/docker"
+	"github.com/mambapanel/wings/internal/files"
+	"github.com/mambapanel/wings/internal/installer"
 	"github.com/mambapanel/wings/internal/metrics"
 	"github.com/mambapanel/wings/internal/mtls"
+	"github.com/mambapanel/wings/internal/rcon"
+	"github.com/mambapanel/wings/internal/stats"
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/zap"
 )
@@ -45,6 +50,14 @@ func main() {
 
 	logger.Info("Docker client initialized successfully")
 
+	// Initialize server subsystems
+	consoleManager := console.NewManager(dockerClient.GetClient(), logger)
+	installManager := installer.NewManager(dockerClient, consoleManager, cfg.DataDir, cfg.StateDir, logger)
+	rconPool := rcon.NewPool(logger)
+	rconCredentials := rcon.NewCredentials(cfg.StateDir)
+	go rconPool.Start()
+	statsStreams := stats.NewManager(dockerClient.GetClient(), logger)
+
 	// Load mTLS configuration
 	mtlsConfig, err := mtls.LoadClientConfig()
 	if err != nil {
@@ -67,18 +80,52 @@ func main() {
 		}
 	}
 
+	var nodeID string
+	if mtlsConfig != nil {
+		nodeID = mtlsConfig.NodeID
+	}
+
+	// Disk quotas use the limit recorded on each server's container
+	diskQuota := files.NewDiskQuota(cfg.DataDir, func(serverID string) (int64, error) {
+		limitMB, err := dockerClient.ServerDiskLimit(serverID)
+		return limitMB * 1024 * 1024, err
+	}, apiClient, nodeID, logger)
+	go diskQuota.Start()
+
+	hostFiles := files.NewHostManager(cfg.DataDir, diskQuota, dockerClient.ServerUser, logger)
+	uploadManager := files.NewUploadManager(hostFiles, logger)
+	go uploadManager.Start()
+
 	// Initialize Phase 5 services
 	var metricsEmitter *metrics.Emitter
 	var crashGuard *crashguard.Guard
 
 	if apiClient != nil && mtlsConfig != nil {
 		// Start metrics emitter
-		metricsEmitter = metrics.NewEmitter(dockerClient.GetClient(), apiClient, mtlsConfig.NodeID, logger)
+		metricsEmitter = metrics.NewEmitter(dockerClient.GetClient(), apiClient, mtlsConfig.NodeID, diskQuota, logger)
 		go metricsEmitter.Start()
 		logger.Info("Metrics emitter started")
 
 		// Start crash guard
-		crashGuard = crashguard.NewGuard(dockerClient.GetClient(), apiClient, mtlsConfig.NodeID, logger)
+		crashGuard = crashguard.NewGuard(dockerClient.GetClient(), apiClient, mtlsConfig.NodeID, cfg.StateDir, logger)
+		// Connections to a stopped server are dead, there is no point health checking them
+		crashGuard.OnContainerDie(rconPool.RemoveClient)
+		// Crash reports include the server's resource usage from its last metrics sample,
+		// unless that is too old to say anything about the crash
+		crashGuard.OnResourceSample(func(serverID string) (crashguard.ResourceSample, bool) {
+			sample, exists := metricsEmitter.LastSample(serverID)
+			collectedAt, err := time.Parse(time.RFC3339, sample.Timestamp)
+			if !exists || err != nil || time.Since(collectedAt) > 2*metrics.Interval {
+				return crashguard.ResourceSample{}, false
+			}
+			return crashguard.ResourceSample{
+				Timestamp:       sample.Timestamp,
+				CPUUsagePercent: sample.CPUUsagePercent,
+				MemUsageMB:      sample.MemUsageMB,
+				DiskUsageMB:     sample.DiskUsageMB,
+				Uptime:          sample.Uptime,
+			}, exists
+		})
 		crashGuard.Start()
 		logger.Info("Crash guard started")
 
@@ -103,10 +150,25 @@ func main() {
 	app := fiber.New(fiber.Config{
 		DisableStartupMessage: true,
 		AppName:               fmt.Sprintf("Wings v%s", Version),
+		// Bodies over the default limit are streamed to handlers rather than
+		// rejected, so file uploads never sit in memory whole
+		StreamRequestBody: true,
 	})
 
 	// Setup API routes
-	api.SetupRoutes(app, logger, dockerClient, cfg)
+	api.SetupRoutes(app, logger, dockerClient, cfg, &api.Services{
+		Console:         consoleManager,
+		Files:           hostFiles,
+		Uploads:         uploadManager,
+		DiskQuota:       diskQuota,
+		Installer:       installManager,
+		RCON:            rconPool,
+		RCONCredentials: rconCredentials,
+		Stats:           stats.NewCollector(dockerClient.GetClient()),
+		StatsStreams:    statsStreams,
+		CrashGuard:      crashGuard,
+		Metrics:         metricsEmitter,
+	})
 
 	// Start server in goroutine
 	go func() {
@@ -135,6 +197,12 @@ func main() {
 		logger.Info("Crash guard stopped")
 	}
 
+	installManager.Stop()
+	uploadManager.Stop()
+	diskQuota.Stop()
+	rconPool.Stop()
+	statsStreams.StopAll()
+
 	// Shutdown HTTP server
 	if err := app.Shutdown(); err != nil {
 		logger.Error("Server forced to shutdown", zap.Error(err))
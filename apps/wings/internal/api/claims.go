@@ -0,0 +1,163 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mambapanel/wings/internal/config"
+)
+
+// Permissions carried in the token's "permissions" claim. They are finer
+// than the panel's RBAC permissions in packages/authz, one per daemon
+// action, so the panel grants the set an authz permission covers; see
+// "Wings permissions" in the README for the mapping.
+const (
+	PermissionSystemRead = "system.read"
+
+	PermissionServerCreate  = "server.create"
+	PermissionServerUpdate  = "server.update"
+	PermissionServerDelete  = "server.delete"
+	PermissionServerInstall = "server.install"
+
+	PermissionControlStart   = "control.start"
+	PermissionControlStop    = "control.stop"
+	PermissionControlRestart = "control.restart"
+	PermissionControlKill    = "control.kill"
+
+	PermissionConsoleRead  = "console.read"
+	PermissionConsoleWrite = "console.write"
+	PermissionConsoleExec  = "console.exec"
+
+	PermissionRCONExecute = "rcon.execute"
+
+	PermissionStatsRead = "stats.read"
+
+	PermissionFilesRead   = "files.read"
+	PermissionFilesWrite  = "files.write"
+	PermissionFilesDelete = "files.delete"
+
+	// PermissionAll grants every permission on every server; only the
+	// panel's own node tokens should hold it
+	PermissionAll = "*"
+)
+
+// Reasons returned alongside 401 and 403 responses so callers can react programmatically
+const (
+	ReasonMissingToken      = "missing_token"
+	ReasonMalformedToken    = "malformed_token"
+	ReasonInvalidSignature  = "invalid_signature"
+	ReasonInvalidAlgorithm  = "invalid_algorithm"
+	ReasonTokenExpired      = "token_expired"
+	ReasonMissingExpiry     = "missing_expiry"
+	ReasonInvalidAudience   = "invalid_audience"
+	ReasonInvalidToken      = "invalid_token"
+	ReasonTokenLifetime     = "token_lifetime_too_long"
+	ReasonServerMismatch    = "server_mismatch"
+	ReasonMissingPermission = "missing_permission"
+)
+
+// errAlgorithmNotAllowed is returned for tokens signed with an algorithm outside the allow list
+var errAlgorithmNotAllowed = errors.New("signing algorithm not allowed")
+
+// Claims are the JWT claims Wings understands
+type Claims struct {
+	ServerID    string   `json:"server_id,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// HasPermission reports whether the token grants a permission
+func (c *Claims) HasPermission(permission string) bool {
+	if c == nil {
+		return false
+	}
+	for _, granted := range c.Permissions {
+		if granted == permission || granted == PermissionAll {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessServer reports whether the token is scoped to a server. A
+// PermissionAll token without a server_id claim is the panel's own, and
+// reaches every server on the node.
+func (c *Claims) CanAccessServer(serverID string) bool {
+	if c == nil {
+		return false
+	}
+	if c.ServerID == "" {
+		return c.isNodeToken()
+	}
+	return c.ServerID == serverID
+}
+
+// isNodeToken reports whether the token grants PermissionAll
+func (c *Claims) isNodeToken() bool {
+	for _, granted := range c.Permissions {
+		if granted == PermissionAll {
+			return true
+		}
+	}
+	return false
+}
+
+// parseToken validates a token's signature, algorithm, expiry and audience,
+// returning a machine-readable reason on failure
+func parseToken(tokenString string, cfg *config.Config) (*Claims, string, error) {
+	claims := &Claims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		// Only HMAC is supported since the secret is shared with the panel
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || !algorithmAllowed(token.Method.Alg(), cfg.TokenAlgorithms) {
+			return nil, fmt.Errorf("%w: %s", errAlgorithmNotAllowed, token.Method.Alg())
+		}
+		return []byte(cfg.TokenSecret), nil
+	},
+		jwt.WithAudience(cfg.TokenAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+
+	if err != nil || !token.Valid {
+		return nil, tokenErrorReason(err, claims), err
+	}
+
+	return claims, "", nil
+}
+
+// tokenErrorReason maps a JWT validation error onto a response reason, using
+// the claims that were parsed to tell which required claim is missing
+func tokenErrorReason(err error, claims *Claims) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return ReasonMalformedToken
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return ReasonInvalidSignature
+	case errors.Is(err, errAlgorithmNotAllowed):
+		return ReasonInvalidAlgorithm
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return ReasonTokenExpired
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return ReasonInvalidAudience
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		// Both exp and aud are required
+		if claims.ExpiresAt == nil {
+			return ReasonMissingExpiry
+		}
+		return ReasonInvalidAudience
+	default:
+		return ReasonInvalidToken
+	}
+}
+
+// algorithmAllowed reports whether alg is in the configured allow list
+func algorithmAllowed(alg string, allowed []string) bool {
+	for _, candidate := range allowed {
+		if candidate == alg {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/mambapanel/wings/internal/crashguard"
+	"github.com/mambapanel/wings/internal/docker"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// GetCrashPolicy returns the policy the crash guard applies to a server and
+// whether it comes from the server's configuration, an override or the default
+func (h *Handlers) GetCrashPolicy(c *fiber.Ctx) error {
+	return h.crashPolicyResponse(c, c.Params("serverId"))
+}
+
+// SetCrashPolicy overrides a server's configured crash policy without
+// recreating its container. Fields left out take their default values.
+func (h *Handlers) SetCrashPolicy(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	var body docker.CrashPolicyConfig
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	return h.updateCrashPolicy(c, serverID, &body)
+}
+
+// DeleteCrashPolicy removes a server's policy override, returning it to
+// the policy in its configuration
+func (h *Handlers) DeleteCrashPolicy(c *fiber.Ctx) error {
+	return h.updateCrashPolicy(c, c.Params("serverId"), nil)
+}
+
+// ListCrashReports lists the crash reports kept for a server, newest
+// first. Console output is left out; fetch a single report for it.
+func (h *Handlers) ListCrashReports(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	if !docker.ValidServerID(serverID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid server ID",
+		})
+	}
+	if h.services.CrashGuard == nil {
+		return crashGuardUnavailable(c)
+	}
+
+	reports, err := h.services.CrashGuard.CrashReports(serverID)
+	if err != nil {
+		return h.crashReportError(c, serverID, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"crashes": reports,
+	})
+}
+
+// GetCrashReport returns one crash report, including the console output
+// and container state captured when the server died
+func (h *Handlers) GetCrashReport(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	if !docker.ValidServerID(serverID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid server ID",
+		})
+	}
+	if h.services.CrashGuard == nil {
+		return crashGuardUnavailable(c)
+	}
+
+	report, err := h.services.CrashGuard.CrashReport(serverID, c.Params("crashId"))
+	if err != nil {
+		return h.crashReportError(c, serverID, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"crash":   report,
+	})
+}
+
+// crashReportError maps a failure reading crash reports to a status code and writes the error response
+func (h *Handlers) crashReportError(c *fiber.Ctx, serverID string, err error) error {
+	status := fiber.StatusInternalServerError
+	if errors.Is(err, crashguard.ErrCrashReportNotFound) {
+		status = fiber.StatusNotFound
+	} else {
+		h.logger.Error("Failed to read crash reports",
+			zap.String("serverId", serverID),
+			zap.Error(err))
+	}
+
+	return c.Status(status).JSON(fiber.Map{
+		"success": false,
+		"error":   err.Error(),
+	})
+}
+
+// updateCrashPolicy sets or, with a nil config, removes a server's override
+func (h *Handlers) updateCrashPolicy(c *fiber.Ctx, serverID string, cfg *docker.CrashPolicyConfig) error {
+	if !docker.ValidServerID(serverID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid server ID",
+		})
+	}
+	if h.services.CrashGuard == nil {
+		return crashGuardUnavailable(c)
+	}
+	if _, err := h.dockerClient.FindServerContainer(serverID); err != nil {
+		return h.crashPolicyError(c, serverID, err)
+	}
+
+	if err := h.services.CrashGuard.SetPolicy(serverID, cfg); err != nil {
+		h.logger.Warn("Failed to update crash policy",
+			zap.String("serverId", serverID),
+			zap.Error(err))
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	return h.crashPolicyResponse(c, serverID)
+}
+
+// crashPolicyResponse writes a server's effective crash policy
+func (h *Handlers) crashPolicyResponse(c *fiber.Ctx, serverID string) error {
+	if h.services.CrashGuard == nil {
+		return crashGuardUnavailable(c)
+	}
+
+	container, err := h.dockerClient.FindServerContainer(serverID)
+	if err != nil {
+		return h.crashPolicyError(c, serverID, err)
+	}
+
+	policy, source := h.services.CrashGuard.Policy(serverID, container.Labels)
+	return c.JSON(fiber.Map{
+		"success": true,
+		"policy":  policy.Config(),
+		"source":  source,
+	})
+}
+
+// crashPolicyError writes the response for a failed container lookup
+func (h *Handlers) crashPolicyError(c *fiber.Ctx, serverID string, err error) error {
+	status := fiber.StatusInternalServerError
+	if errors.Is(err, docker.ErrServerNotFound) {
+		status = fiber.StatusNotFound
+	} else {
+		h.logger.Error("Failed to find server container",
+			zap.String("serverId", serverID),
+			zap.Error(err))
+	}
+
+	return c.Status(status).JSON(fiber.Map{
+		"success": false,
+		"error":   err.Error(),
+	})
+}
+
+// setDesiredState tells the crash guard what a power action is about to do
+// to a server, on nodes running it. The returned function puts back the
+// previous state, for when the action fails and the server stays as it was.
+func (h *Handlers) setDesiredState(serverID string, state crashguard.DesiredState) (restore func()) {
+	guard := h.services.CrashGuard
+	if guard == nil {
+		return func() {}
+	}
+
+	previous := guard.DesiredState(serverID)
+	guard.SetDesiredState(serverID, state)
+	return func() {
+		guard.SetDesiredState(serverID, previous)
+	}
+}
+
+// crashGuardUnavailable rejects crash policy requests on a node running without the guard
+func crashGuardUnavailable(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+		"success": false,
+		"error":   "Crash guard is not running on this node",
+	})
+}
@@ -0,0 +1,613 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mambapanel/wings/internal/console"
+	"github.com/mambapanel/wings/internal/docker"
+	"github.com/mambapanel/wings/internal/files"
+	"github.com/docker/docker/errdefs"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// searchTimeout bounds how long a single search may walk a server's files
+const searchTimeout = 2 * time.Minute
+
+// Headers carrying resumable upload progress, named as in the tus protocol
+const (
+	headerUploadOffset = "Upload-Offset"
+	headerUploadLength = "Upload-Length"
+)
+
+// ListFiles lists a directory in the server's data directory
+func (h *Handlers) ListFiles(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	entries, err := h.services.Files.ListFiles(c.Context(), serverID, c.Query("path", "/"))
+	if err != nil {
+		return h.fileError(c, serverID, "list files", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"files":   entries,
+	})
+}
+
+// GetFileContents streams a file's contents, for the in-browser editor. The
+// ETag header carries the file's version; sending it back in If-Match when
+// saving makes the write fail if someone else changed the file meanwhile.
+func (h *Handlers) GetFileContents(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	// Taken before reading, so a change in between fails the next save rather than being lost
+	version, err := h.services.Files.FileVersion(c.Context(), serverID, c.Query("path"))
+	if err != nil {
+		return h.fileError(c, serverID, "read file", err)
+	}
+	c.Set(fiber.HeaderETag, formatETag(version))
+
+	return h.sendFile(c, false)
+}
+
+// DownloadFile streams a file as an attachment
+func (h *Handlers) DownloadFile(c *fiber.Ctx) error {
+	return h.sendFile(c, true)
+}
+
+// sendFile streams a file to the client without loading it into memory
+func (h *Handlers) sendFile(c *fiber.Ctx, attachment bool) error {
+	serverID := c.Params("serverId")
+	filePath := c.Query("path")
+
+	reader, size, err := h.services.Files.ReadFileStream(c.Context(), serverID, filePath)
+	if err != nil {
+		return h.fileError(c, serverID, "read file", err)
+	}
+
+	if attachment {
+		c.Attachment(path.Base(filePath))
+	} else {
+		c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+	}
+
+	// Fiber closes the reader once the body has been sent
+	return c.SendStream(reader, int(size))
+}
+
+// WriteFile streams the request body into a file, creating it if needed.
+// Uploads use the same handler; the body is never buffered whole.
+func (h *Handlers) WriteFile(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+	filePath := c.Query("path")
+
+	if filePath == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "path is required",
+		})
+	}
+
+	version, err := h.services.Files.WriteFileIfMatch(c.Context(), serverID, filePath, requestBody(c), int64(c.Request().Header.ContentLength()), parseETag(c.Get(fiber.HeaderIfMatch)))
+	if err != nil {
+		return h.fileError(c, serverID, "write file", err)
+	}
+
+	c.Set(fiber.HeaderETag, formatETag(version))
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "File written successfully",
+		"version": version,
+	})
+}
+
+// formatETag quotes a file version as an entity tag
+func formatETag(version string) string {
+	return `"` + version + `"`
+}
+
+// parseETag returns the file version in an If-Match header, or "" for none.
+// A wildcard matches any version, so it writes unconditionally.
+func parseETag(header string) string {
+	tag := strings.TrimSpace(header)
+	if tag == "*" {
+		return ""
+	}
+	tag = strings.TrimPrefix(tag, "W/")
+	return strings.Trim(tag, `"`)
+}
+
+// DeleteFiles deletes files or directories
+func (h *Handlers) DeleteFiles(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	var body struct {
+		Paths []string `json:"paths"`
+	}
+
+	if err := c.BodyParser(&body); err != nil || len(body.Paths) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	for _, p := range body.Paths {
+		if err := h.services.Files.DeleteFile(c.Context(), serverID, p); err != nil {
+			return h.fileError(c, serverID, "delete file", err)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Files deleted successfully",
+	})
+}
+
+// CreateDirectory creates a directory and any missing parents
+func (h *Handlers) CreateDirectory(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	var body struct {
+		Path string `json:"path"`
+	}
+
+	if err := c.BodyParser(&body); err != nil || body.Path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := h.services.Files.CreateDirectory(c.Context(), serverID, body.Path); err != nil {
+		return h.fileError(c, serverID, "create directory", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"message": "Directory created successfully",
+	})
+}
+
+// CompressFiles archives files in the server's data directory. The format
+// follows the output's extension, and the response is sent once the archive
+// is complete; progress is published to the server's console meanwhile.
+func (h *Handlers) CompressFiles(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	var body struct {
+		Paths  []string `json:"paths"`
+		Output string   `json:"output"`
+	}
+
+	if err := c.BodyParser(&body); err != nil || len(body.Paths) == 0 || body.Output == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	ctx := files.WithProgress(c.Context(), h.archiveProgress(serverID, "Compressing "+body.Output))
+	if err := h.services.Files.CompressFiles(ctx, serverID, body.Paths, body.Output); err != nil {
+		return h.fileError(c, serverID, "compress files", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Files compressed successfully",
+	})
+}
+
+// ExtractArchive extracts an archive into a directory, defaulting to the one
+// holding the archive. Like CompressFiles it responds once the job finishes.
+func (h *Handlers) ExtractArchive(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	var body struct {
+		Archive string `json:"archive"`
+		Target  string `json:"target"`
+	}
+
+	if err := c.BodyParser(&body); err != nil || body.Archive == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if body.Target == "" {
+		body.Target = path.Dir(body.Archive)
+	}
+
+	ctx := files.WithProgress(c.Context(), h.archiveProgress(serverID, "Extracting "+body.Archive))
+	if err := h.services.Files.ExtractArchive(ctx, serverID, body.Archive, body.Target); err != nil {
+		return h.fileError(c, serverID, "extract archive", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Archive extracted successfully",
+	})
+}
+
+// RenameFile gives a file or directory a new name in the same directory
+func (h *Handlers) RenameFile(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	var body struct {
+		Path string `json:"path"`
+		Name string `json:"name"`
+	}
+
+	if err := c.BodyParser(&body); err != nil || body.Path == "" || body.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := h.services.Files.Rename(c.Context(), serverID, body.Path, body.Name); err != nil {
+		return h.fileError(c, serverID, "rename file", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "File renamed successfully",
+	})
+}
+
+// MoveFile moves a file or directory to a new path
+func (h *Handlers) MoveFile(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	var body struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+
+	if err := c.BodyParser(&body); err != nil || body.From == "" || body.To == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := h.services.Files.Move(c.Context(), serverID, body.From, body.To); err != nil {
+		return h.fileError(c, serverID, "move file", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "File moved successfully",
+	})
+}
+
+// CopyFile recursively copies a file or directory. The conflict policy
+// defaults to failing when the destination exists.
+func (h *Handlers) CopyFile(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	var body struct {
+		From     string               `json:"from"`
+		To       string               `json:"to"`
+		Conflict files.ConflictPolicy `json:"conflict"`
+	}
+
+	if err := c.BodyParser(&body); err != nil || body.From == "" || body.To == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := h.services.Files.Copy(c.Context(), serverID, body.From, body.To, body.Conflict); err != nil {
+		return h.fileError(c, serverID, "copy file", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "File copied successfully",
+	})
+}
+
+// ChmodFile sets a path's permission bits from an octal string such as "0755"
+func (h *Handlers) ChmodFile(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	var body struct {
+		Path string `json:"path"`
+		Mode string `json:"mode"`
+	}
+
+	if err := c.BodyParser(&body); err != nil || body.Path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	mode, err := strconv.ParseUint(body.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "mode must be an octal permission between 0000 and 0777",
+		})
+	}
+
+	if err := h.services.Files.Chmod(c.Context(), serverID, body.Path, os.FileMode(mode)); err != nil {
+		return h.fileError(c, serverID, "change file mode", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "File mode changed successfully",
+	})
+}
+
+// ChownFile changes the owner of a path and everything under it
+func (h *Handlers) ChownFile(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	var body struct {
+		Path string `json:"path"`
+		UID  *int   `json:"uid"`
+		GID  *int   `json:"gid"`
+	}
+
+	if err := c.BodyParser(&body); err != nil || body.Path == "" || body.UID == nil || body.GID == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	if err := h.services.Files.Chown(c.Context(), serverID, body.Path, *body.UID, *body.GID); err != nil {
+		return h.fileError(c, serverID, "change file owner", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "File owner changed successfully",
+	})
+}
+
+// SearchFiles searches a server's files by name glob and optionally content,
+// streaming one JSON result per line as matches are found. The last line
+// reports whether the search finished, hit its result limit or failed.
+func (h *Handlers) SearchFiles(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	opts := files.SearchOptions{
+		Path:        c.Query("path", "/"),
+		Name:        c.Query("name"),
+		Content:     c.Query("content"),
+		Regex:       c.QueryBool("regex"),
+		IgnoreCase:  c.QueryBool("ignoreCase"),
+		MaxDepth:    c.QueryInt("maxDepth"),
+		MaxFileSize: int64(c.QueryInt("maxFileSize")),
+		MaxResults:  c.QueryInt("maxResults"),
+	}
+
+	// Bad patterns are rejected before the stream starts and the status is sent
+	if err := opts.Validate(); err != nil {
+		return h.fileError(c, serverID, "search files", err)
+	}
+
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithTimeout(context.Background(), searchTimeout)
+		defer cancel()
+
+		encoder := json.NewEncoder(w)
+		count := 0
+		err := h.services.Files.Search(ctx, serverID, opts, func(result files.SearchResult) error {
+			count++
+			if err := encoder.Encode(result); err != nil {
+				return err
+			}
+			// A failed flush means the client went away, which ends the walk
+			return w.Flush()
+		})
+
+		summary := searchSummary{Done: true, Results: count}
+		switch {
+		case errors.Is(err, files.ErrSearchLimit):
+			summary.Truncated = true
+		case err != nil:
+			summary.Error = err.Error()
+			h.logger.Warn("Search failed",
+				zap.String("serverId", serverID),
+				zap.Error(err))
+		}
+
+		encoder.Encode(summary)
+		w.Flush()
+	})
+
+	return nil
+}
+
+// searchSummary is the final line of a search stream
+type searchSummary struct {
+	Done      bool   `json:"done"`
+	Results   int    `json:"results"`
+	Truncated bool   `json:"truncated"`
+	Error     string `json:"error,omitempty"`
+}
+
+// CreateUpload starts a resumable upload of a known size
+func (h *Handlers) CreateUpload(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	var body struct {
+		Path string `json:"path"`
+		Size int64  `json:"size"`
+	}
+
+	if err := c.BodyParser(&body); err != nil || body.Path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	upload, err := h.services.Uploads.Create(serverID, body.Path, body.Size)
+	if err != nil {
+		return h.fileError(c, serverID, "create upload", err)
+	}
+
+	setUploadHeaders(c, upload)
+	c.Location(c.Path() + "/" + upload.ID)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success": true,
+		"upload":  upload,
+	})
+}
+
+// GetUpload reports how much of an upload has been received, so a client
+// can resume after a dropped connection
+func (h *Handlers) GetUpload(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	upload, err := h.services.Uploads.Get(serverID, c.Params("uploadId"))
+	if err != nil {
+		return h.fileError(c, serverID, "get upload", err)
+	}
+
+	setUploadHeaders(c, upload)
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"upload":  upload,
+	})
+}
+
+// WriteUploadChunk appends the request body to an upload. The Upload-Offset
+// header must match the bytes received so far.
+func (h *Handlers) WriteUploadChunk(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	offset, err := strconv.ParseInt(c.Get(headerUploadOffset), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid " + headerUploadOffset + " header",
+		})
+	}
+
+	upload, err := h.services.Uploads.WriteChunk(serverID, c.Params("uploadId"), offset, requestBody(c))
+	if upload != nil {
+		setUploadHeaders(c, upload)
+	}
+	if err != nil {
+		return h.fileError(c, serverID, "write upload chunk", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"upload":  upload,
+	})
+}
+
+// CompleteUpload moves a fully received upload into its target path
+func (h *Handlers) CompleteUpload(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	upload, err := h.services.Uploads.Complete(serverID, c.Params("uploadId"))
+	if err != nil {
+		return h.fileError(c, serverID, "complete upload", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"upload":  upload,
+	})
+}
+
+// CancelUpload discards an upload and the data received for it
+func (h *Handlers) CancelUpload(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	if err := h.services.Uploads.Cancel(serverID, c.Params("uploadId")); err != nil {
+		return h.fileError(c, serverID, "cancel upload", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Upload cancelled",
+	})
+}
+
+// archiveProgress publishes an archive job's progress to the server's console
+func (h *Handlers) archiveProgress(serverID, job string) files.ProgressFunc {
+	return func(progress files.ArchiveProgress) {
+		h.services.Console.Publish(serverID, console.LogEntry{
+			Type: "archive",
+			Line: fmt.Sprintf("[Wings] %s: %d%% (%d entries)", job, progress.Percent(), progress.Entries),
+		})
+	}
+}
+
+// setUploadHeaders reports upload progress using the tus header names
+func setUploadHeaders(c *fiber.Ctx, upload *files.Upload) {
+	c.Set(headerUploadOffset, strconv.FormatInt(upload.Offset, 10))
+	c.Set(headerUploadLength, strconv.FormatInt(upload.Size, 10))
+}
+
+// requestBody returns the request body as a stream. Fiber only streams bodies
+// larger than its body limit, so smaller ones are already in memory.
+func requestBody(c *fiber.Ctx) io.Reader {
+	if stream := c.Context().RequestBodyStream(); stream != nil {
+		return stream
+	}
+	return bytes.NewReader(c.Body())
+}
+
+// fileError maps file manager errors onto HTTP responses
+func (h *Handlers) fileError(c *fiber.Ctx, serverID, action string, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, files.ErrInvalidPath), errors.Is(err, files.ErrNotRegularFile), errors.Is(err, files.ErrUnsupportedArchive):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, files.ErrPathEscape), errors.Is(err, files.ErrSymlinkEscape), errors.Is(err, files.ErrSpecialFile), errors.Is(err, files.ErrOwnerNotAllowed):
+		status = fiber.StatusForbidden
+	case errors.Is(err, fs.ErrNotExist), errdefs.IsNotFound(err), errors.Is(err, files.ErrUploadNotFound), errors.Is(err, docker.ErrServerNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, files.ErrFileExists), errors.Is(err, files.ErrFileChanged), errors.Is(err, files.ErrUploadOffsetMismatch), errors.Is(err, files.ErrUploadIncomplete), errors.Is(err, files.ErrUploadBusy):
+		status = fiber.StatusConflict
+	case errors.Is(err, files.ErrUploadTooLarge), errors.Is(err, files.ErrArchiveTooLarge):
+		status = fiber.StatusRequestEntityTooLarge
+	case errors.Is(err, files.ErrQuotaExceeded):
+		status = fiber.StatusInsufficientStorage
+	}
+
+	if status == fiber.StatusInternalServerError {
+		h.logger.Error("Failed to "+action,
+			zap.String("serverId", serverID),
+			zap.Error(err))
+	} else {
+		h.logger.Warn("Rejected file operation",
+			zap.String("serverId", serverID),
+			zap.String("action", action),
+			zap.Error(err))
+	}
+
+	return c.Status(status).JSON(fiber.Map{
+		"success": false,
+		"error":   err.Error(),
+	})
+}
@@ -0,0 +1,325 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mambapanel/wings/internal/console"
+	"github.com/mambapanel/wings/internal/files"
+	"go.uber.org/zap"
+)
+
+// filesTestApp serves the file routes, without authentication, over a
+// HostManager rooted in a temp directory holding server "srv". Bodies over
+// 1KiB are streamed to the handlers, as production streams large uploads.
+func filesTestApp(t *testing.T, quotaLimit int64) (*fiber.App, string) {
+	t.Helper()
+
+	logger := zap.NewNop()
+	dataDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dataDir, "srv"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	quota := files.NewDiskQuota(dataDir, func(string) (int64, error) { return quotaLimit, nil }, nil, "", logger)
+	host := files.NewHostManager(dataDir, quota, nil, logger)
+	handlers := NewHandlers(logger, nil, testConfig(), &Services{
+		Console:   console.NewManager(nil, logger),
+		Files:     host,
+		Uploads:   files.NewUploadManager(host, logger),
+		DiskQuota: quota,
+	})
+
+	app := fiber.New(fiber.Config{StreamRequestBody: true, BodyLimit: 1024})
+	app.Get("/servers/:serverId/files/contents", handlers.GetFileContents)
+	app.Get("/servers/:serverId/files/download", handlers.DownloadFile)
+	app.Get("/servers/:serverId/files/search", handlers.SearchFiles)
+	app.Post("/servers/:serverId/files/write", handlers.WriteFile)
+	app.Post("/servers/:serverId/files/uploads", handlers.CreateUpload)
+	app.Get("/servers/:serverId/files/uploads/:uploadId", handlers.GetUpload)
+	app.Patch("/servers/:serverId/files/uploads/:uploadId", handlers.WriteUploadChunk)
+	app.Post("/servers/:serverId/files/uploads/:uploadId/complete", handlers.CompleteUpload)
+	return app, filepath.Join(dataDir, "srv")
+}
+
+// do sends a request and returns the response with its body read
+func do(t *testing.T, app *fiber.App, req *http.Request) (*http.Response, []byte) {
+	t.Helper()
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+// writeRequest builds a file write, conditional on ifMatch unless it is ""
+func writeRequest(path, content, ifMatch string) *http.Request {
+	req := httptest.NewRequest(fiber.MethodPost, "/servers/srv/files/write?path="+path, strings.NewReader(content))
+	if ifMatch != "" {
+		req.Header.Set(fiber.HeaderIfMatch, ifMatch)
+	}
+	return req
+}
+
+func TestFileContentsETag(t *testing.T) {
+	app, _ := filesTestApp(t, 0)
+
+	resp, body := do(t, app, writeRequest("/server.properties", "motd=one\n", ""))
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("write status = %d: %s", resp.StatusCode, body)
+	}
+	var written struct {
+		Version string `json:"version"`
+	}
+	json.Unmarshal(body, &written)
+	created := resp.Header.Get(fiber.HeaderETag)
+	if created != `"`+written.Version+`"` {
+		t.Fatalf("ETag %s does not quote version %q", created, written.Version)
+	}
+
+	// The editor reads the file along with the version it saves against
+	resp, body = do(t, app, httptest.NewRequest(fiber.MethodGet, "/servers/srv/files/contents?path=/server.properties", nil))
+	if resp.StatusCode != fiber.StatusOK || string(body) != "motd=one\n" {
+		t.Fatalf("contents = %d %q", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get(fiber.HeaderETag); got != created {
+		t.Fatalf("contents ETag = %s, want %s from the write", got, created)
+	}
+	if got := resp.Header.Get(fiber.HeaderContentType); !strings.HasPrefix(got, "text/plain") {
+		t.Fatalf("contents Content-Type = %q", got)
+	}
+
+	// Saving against the version read succeeds and moves it on
+	resp, body = do(t, app, writeRequest("/server.properties", "motd=two\n", created))
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("conditional write status = %d: %s", resp.StatusCode, body)
+	}
+	saved := resp.Header.Get(fiber.HeaderETag)
+	if saved == created {
+		t.Fatalf("ETag unchanged after a write")
+	}
+
+	// A second editor still holding the first version is refused
+	resp, _ = do(t, app, writeRequest("/server.properties", "motd=three\n", created))
+	if resp.StatusCode != fiber.StatusConflict {
+		t.Fatalf("stale write status = %d, want %d", resp.StatusCode, fiber.StatusConflict)
+	}
+
+	// Weak tags compare by version, and a wildcard writes unconditionally
+	for _, ifMatch := range []string{"W/" + saved, "*"} {
+		resp, body = do(t, app, writeRequest("/server.properties", "motd=four\n", ifMatch))
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("write with If-Match %s status = %d: %s", ifMatch, resp.StatusCode, body)
+		}
+	}
+
+	resp, _ = do(t, app, writeRequest("", "motd=five\n", ""))
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("write without path status = %d, want %d", resp.StatusCode, fiber.StatusBadRequest)
+	}
+}
+
+func TestFileStreaming(t *testing.T) {
+	app, serverDir := filesTestApp(t, 0)
+
+	// Larger than the body limit, so the handler reads it as a stream
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	resp, body := do(t, app, writeRequest("/world/region.mca", string(content), ""))
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("write status = %d: %s", resp.StatusCode, body)
+	}
+	if got, _ := os.ReadFile(filepath.Join(serverDir, "world", "region.mca")); !bytes.Equal(got, content) {
+		t.Fatalf("streamed write stored %d bytes, want %d", len(got), len(content))
+	}
+
+	resp, body = do(t, app, httptest.NewRequest(fiber.MethodGet, "/servers/srv/files/download?path=/world/region.mca", nil))
+	if resp.StatusCode != fiber.StatusOK || !bytes.Equal(body, content) {
+		t.Fatalf("download = %d with %d bytes, want %d", resp.StatusCode, len(body), len(content))
+	}
+	if got := resp.Header.Get(fiber.HeaderContentDisposition); !strings.Contains(got, `filename="region.mca"`) {
+		t.Fatalf("download Content-Disposition = %q", got)
+	}
+	if got := resp.Header.Get(fiber.HeaderContentLength); got != "65536" {
+		t.Fatalf("download Content-Length = %q, want 65536", got)
+	}
+}
+
+func TestFileUploads(t *testing.T) {
+	app, serverDir := filesTestApp(t, 0)
+
+	req := httptest.NewRequest(fiber.MethodPost, "/servers/srv/files/uploads", strings.NewReader(`{"path":"/plugins/a.jar","size":10}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, body := do(t, app, req)
+	if resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("create status = %d: %s", resp.StatusCode, body)
+	}
+	var created struct {
+		Upload files.Upload `json:"upload"`
+	}
+	json.Unmarshal(body, &created)
+	location := "/servers/srv/files/uploads/" + created.Upload.ID
+	if got := resp.Header.Get(fiber.HeaderLocation); got != location {
+		t.Fatalf("Location = %q, want %q", got, location)
+	}
+
+	chunk := func(offset, data string) *http.Response {
+		req := httptest.NewRequest(fiber.MethodPatch, location, strings.NewReader(data))
+		req.Header.Set(headerUploadOffset, offset)
+		resp, _ := do(t, app, req)
+		return resp
+	}
+
+	if resp := chunk("0", "0123"); resp.StatusCode != fiber.StatusOK || resp.Header.Get(headerUploadOffset) != "4" {
+		t.Fatalf("chunk = %d at offset %s", resp.StatusCode, resp.Header.Get(headerUploadOffset))
+	}
+
+	// A resent chunk is refused with the offset to resume from
+	if resp := chunk("0", "0123"); resp.StatusCode != fiber.StatusConflict || resp.Header.Get(headerUploadOffset) != "4" {
+		t.Fatalf("resent chunk = %d at offset %s, want %d at 4", resp.StatusCode, resp.Header.Get(headerUploadOffset), fiber.StatusConflict)
+	}
+	if resp := chunk("four", "4567"); resp.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("chunk with a bad offset = %d, want %d", resp.StatusCode, fiber.StatusBadRequest)
+	}
+
+	resp, _ = do(t, app, httptest.NewRequest(fiber.MethodGet, location, nil))
+	if resp.StatusCode != fiber.StatusOK || resp.Header.Get(headerUploadOffset) != "4" || resp.Header.Get(headerUploadLength) != "10" {
+		t.Fatalf("upload status = %d, %s of %s", resp.StatusCode, resp.Header.Get(headerUploadOffset), resp.Header.Get(headerUploadLength))
+	}
+	resp, _ = do(t, app, httptest.NewRequest(fiber.MethodPost, location+"/complete", nil))
+	if resp.StatusCode != fiber.StatusConflict {
+		t.Fatalf("completing a partial upload = %d, want %d", resp.StatusCode, fiber.StatusConflict)
+	}
+
+	// Bytes past the declared size are refused, keeping the ones that fit
+	if resp := chunk("4", "456789abcdef"); resp.StatusCode != fiber.StatusRequestEntityTooLarge || resp.Header.Get(headerUploadOffset) != "10" {
+		t.Fatalf("oversized chunk = %d at offset %s, want %d at 10", resp.StatusCode, resp.Header.Get(headerUploadOffset), fiber.StatusRequestEntityTooLarge)
+	}
+	resp, body = do(t, app, httptest.NewRequest(fiber.MethodPost, location+"/complete", nil))
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("complete = %d: %s", resp.StatusCode, body)
+	}
+	if got, _ := os.ReadFile(filepath.Join(serverDir, "plugins", "a.jar")); string(got) != "0123456789" {
+		t.Fatalf("completed upload holds %q", got)
+	}
+}
+
+func TestSearchFilesStream(t *testing.T) {
+	app, serverDir := filesTestApp(t, 0)
+	for name, content := range map[string]string{
+		"server.properties":      "motd=A Minecraft Server\n",
+		"config/paper.yml":       "motd: hello\n",
+		"config/spigot.yml":      "settings: {}\n",
+		"plugins/Essentials.yml": "motd: welcome\n",
+	} {
+		path := filepath.Join(serverDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// search returns the results streamed for a query, and the summary line
+	search := func(query string) ([]files.SearchResult, searchSummary) {
+		t.Helper()
+
+		resp, body := do(t, app, httptest.NewRequest(fiber.MethodGet, "/servers/srv/files/search?"+query, nil))
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("search %s status = %d: %s", query, resp.StatusCode, body)
+		}
+		if got := resp.Header.Get(fiber.HeaderContentType); got != "application/x-ndjson" {
+			t.Fatalf("search Content-Type = %q", got)
+		}
+
+		var results []files.SearchResult
+		var summary searchSummary
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			if bytes.Contains(scanner.Bytes(), []byte(`"done":true`)) {
+				json.Unmarshal(scanner.Bytes(), &summary)
+				continue
+			}
+			var result files.SearchResult
+			if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+				t.Fatalf("search line %q: %v", scanner.Text(), err)
+			}
+			results = append(results, result)
+		}
+		if !summary.Done {
+			t.Fatalf("search %s ended without a summary: %s", query, body)
+		}
+		return results, summary
+	}
+
+	results, summary := search("name=*.yml&content=motd")
+	if len(results) != 2 || summary.Results != 2 || summary.Truncated || summary.Error != "" {
+		t.Fatalf("search = %+v, summary %+v", results, summary)
+	}
+	for _, result := range results {
+		if len(result.Matches) != 1 || result.Matches[0].Line != 1 {
+			t.Fatalf("result %s matches = %+v", result.Path, result.Matches)
+		}
+	}
+
+	if results, summary := search("name=*.yml&maxResults=1"); len(results) != 1 || !summary.Truncated {
+		t.Fatalf("limited search = %d results, summary %+v", len(results), summary)
+	}
+
+	// Bad patterns fail before the stream starts
+	resp, _ := do(t, app, httptest.NewRequest(fiber.MethodGet, "/servers/srv/files/search?content=(&regex=true", nil))
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("bad pattern status = %d, want %d", resp.StatusCode, fiber.StatusBadRequest)
+	}
+}
+
+func TestFileErrorStatus(t *testing.T) {
+	app, serverDir := filesTestApp(t, 100)
+	os.MkdirAll(filepath.Join(serverDir, "world"), 0755)
+	if err := os.Symlink("/etc", filepath.Join(serverDir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"missing file", httptest.NewRequest(fiber.MethodGet, "/servers/srv/files/contents?path=/missing.txt", nil), fiber.StatusNotFound},
+		{"directory", httptest.NewRequest(fiber.MethodGet, "/servers/srv/files/contents?path=/world", nil), fiber.StatusBadRequest},
+		{"symlink out of the server", httptest.NewRequest(fiber.MethodGet, "/servers/srv/files/download?path=/escape/hostname", nil), fiber.StatusForbidden},
+		{"write through a symlink", writeRequest("/escape/cron.d/job", "* * * * * root id\n", ""), fiber.StatusForbidden},
+		{"over quota", writeRequest("/big.dat", strings.Repeat("x", 200), ""), fiber.StatusInsufficientStorage},
+		{"unknown upload", httptest.NewRequest(fiber.MethodGet, "/servers/srv/files/uploads/missing", nil), fiber.StatusNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, body := do(t, app, tt.req)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.status, body)
+			}
+
+			var reply struct {
+				Success bool   `json:"success"`
+				Error   string `json:"error"`
+			}
+			if err := json.Unmarshal(body, &reply); err != nil || reply.Success || reply.Error == "" {
+				t.Fatalf("error body = %s", body)
+			}
+		})
+	}
+}
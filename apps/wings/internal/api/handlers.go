@@ -1,11 +1,20 @@
 package api
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"runtime"
 	"time"
 
 	"github.com/mambapanel/wings/internal/config"
+	"github.com/mambapanel/wings/internal/console"
+	"github.com/mambapanel/wings/internal/crashguard"
 	"github.com/mambapanel/wings/internal/docker"
+	"github.com/mambapanel/wings/internal/installer"
+	"github.com/mambapanel/wings/internal/rcon"
+	"github.com/docker/docker/errdefs"
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/zap"
 )
@@ -14,13 +23,15 @@ type Handlers struct {
 	logger       *zap.Logger
 	dockerClient *docker.Client
 	config       *config.Config
+	services     *Services
 }
 
-func NewHandlers(logger *zap.Logger, dockerClient *docker.Client, cfg *config.Config) *Handlers {
+func NewHandlers(logger *zap.Logger, dockerClient *docker.Client, cfg *config.Config, services *Services) *Handlers {
 	return &Handlers{
 		logger:       logger,
 		dockerClient: dockerClient,
 		config:       cfg,
+		services:     services,
 	}
 }
 
@@ -40,6 +51,269 @@ func (h *Handlers) GetSystemStatus(c *fiber.Ctx) error {
 	})
 }
 
+// CreateServer provisions a new game server container
+func (h *Handlers) CreateServer(c *fiber.Ctx) error {
+	var body docker.ServerConfig
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	// The route has no :serverId for Authorize to check, so the scope is checked against the body
+	if !claimsFrom(c).CanAccessServer(body.ServerID) {
+		h.logger.Warn("Token not valid for server",
+			zap.String("serverId", body.ServerID),
+			zap.String("tokenServerId", claimsFrom(c).ServerID))
+		return deny(c, fiber.StatusForbidden, ReasonServerMismatch, "Token not valid for this server")
+	}
+
+	if err := body.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if body.RCON != nil {
+		if err := rcon.Protocol(body.RCON.Protocol).Validate(); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+	}
+
+	body.DataPath = h.serverDataPath(body.ServerID)
+
+	containerID, err := h.dockerClient.CreateServer(body)
+	if err != nil {
+		h.logger.Error("Failed to create server",
+			zap.String("serverId", body.ServerID),
+			zap.String("image", body.Image),
+			zap.Error(err))
+
+		status := fiber.StatusInternalServerError
+		if errdefs.IsConflict(err) {
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	// Stored once the container exists, so a conflicting create leaves the
+	// existing server's password alone
+	if body.RCON != nil {
+		if err := h.services.RCONCredentials.SetPassword(body.ServerID, body.RCON.Password); err != nil {
+			h.logger.Error("Failed to store RCON password",
+				zap.String("serverId", body.ServerID),
+				zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":     false,
+				"error":       err.Error(),
+				"containerId": containerID,
+			})
+		}
+	}
+
+	h.logger.Info("Server created",
+		zap.String("serverId", body.ServerID),
+		zap.String("containerId", containerID))
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"success":     true,
+		"message":     "Server created successfully",
+		"containerId": containerID,
+	})
+}
+
+// serverDataPath returns the host directory holding a server's data
+func (h *Handlers) serverDataPath(serverID string) string {
+	return filepath.Join(h.config.DataDir, serverID)
+}
+
+// deleteStep reports the outcome of one stage of a server deletion
+type deleteStep struct {
+	Step    string `json:"step"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// DeleteServer tears down a server's container, data and in-memory state.
+// Every step tolerates already-removed resources so the panel can retry.
+func (h *Handlers) DeleteServer(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	if !docker.ValidServerID(serverID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid server ID",
+		})
+	}
+
+	steps := []deleteStep{}
+	failed := false
+	run := func(name string, fn func() error) {
+		step := deleteStep{Step: name, Success: true}
+		if err := fn(); err != nil {
+			step.Success = false
+			step.Error = err.Error()
+			failed = true
+
+			h.logger.Error("Server deletion step failed",
+				zap.String("serverId", serverID),
+				zap.String("step", name),
+				zap.Error(err))
+		}
+		steps = append(steps, step)
+	}
+
+	// Stop guarding first so killing the container does not trigger a restart
+	run("crashguard", func() error {
+		if h.services.CrashGuard != nil {
+			h.services.CrashGuard.RemoveServer(serverID)
+		}
+		return nil
+	})
+	run("console", func() error {
+		h.services.Console.RemoveStream(serverID)
+		return nil
+	})
+	run("rcon", func() error {
+		h.services.RCON.RemoveClient(serverID)
+		return h.services.RCONCredentials.Remove(serverID)
+	})
+	run("installer", func() error {
+		return h.services.Installer.Forget(serverID)
+	})
+	run("uploads", func() error {
+		h.services.Uploads.ForgetServer(serverID)
+		return nil
+	})
+	run("quota", func() error {
+		h.services.DiskQuota.Forget(serverID)
+		return nil
+	})
+	run("stats", func() error {
+		h.services.StatsStreams.RemoveStream(serverID)
+		return nil
+	})
+	run("metrics", func() error {
+		if h.services.Metrics != nil {
+			h.services.Metrics.RemoveServer(serverID)
+		}
+		return nil
+	})
+	run("container", func() error {
+		return h.dockerClient.RemoveServer(serverID)
+	})
+	run("volume", func() error {
+		return os.RemoveAll(h.serverDataPath(serverID))
+	})
+
+	if failed {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   "Server deletion did not complete",
+			"steps":   steps,
+		})
+	}
+
+	h.logger.Info("Server deleted", zap.String("serverId", serverID))
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Server deleted successfully",
+		"steps":   steps,
+	})
+}
+
+// InstallServer runs the install script for a server in an ephemeral container
+func (h *Handlers) InstallServer(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	var body installer.Script
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	return h.startInstall(c, serverID, func() error {
+		return h.services.Installer.Install(serverID, body)
+	})
+}
+
+// ReinstallServer re-runs the install script, optionally wiping the server's data
+func (h *Handlers) ReinstallServer(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	var body struct {
+		installer.Script
+		Wipe bool `json:"wipe"`
+	}
+
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	return h.startInstall(c, serverID, func() error {
+		// The installer stops the server, which must not look like a crash
+		restore := h.setDesiredState(serverID, crashguard.DesiredStopped)
+		err := h.services.Installer.Reinstall(serverID, body.Script, body.Wipe)
+		if err != nil {
+			restore()
+		}
+		return err
+	})
+}
+
+// startInstall maps installer errors onto HTTP responses
+func (h *Handlers) startInstall(c *fiber.Ctx, serverID string, start func() error) error {
+	if err := start(); err != nil {
+		status := fiber.StatusBadRequest
+		if errors.Is(err, installer.ErrInstallInProgress) {
+			status = fiber.StatusConflict
+		}
+
+		h.logger.Warn("Failed to start server install",
+			zap.String("serverId", serverID),
+			zap.Error(err))
+		return c.Status(status).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"success": true,
+		"message": "Install started",
+	})
+}
+
+// GetInstallStatus returns the outcome of the most recent install
+func (h *Handlers) GetInstallStatus(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	result, exists := h.services.Installer.GetResult(serverID)
+	if !exists {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"success": false,
+			"error":   "No install recorded for server",
+		})
+	}
+
+	return c.JSON(result)
+}
+
 // ServerPowerAction handles power actions for servers
 func (h *Handlers) ServerPowerAction(c *fiber.Ctx) error {
 	serverID := c.Params("serverId")
@@ -55,15 +329,34 @@ func (h *Handlers) ServerPowerAction(c *fiber.Ctx) error {
 		})
 	}
 
+	// Each power action is a separate permission
+	if permission := "control." + body.Action; !claimsFrom(c).HasPermission(permission) {
+		switch body.Action {
+		case "start", "stop", "restart", "kill":
+			return denyPermission(c, h.logger, permission)
+		}
+	}
+
 	var err error
+	var restore func()
 	switch body.Action {
 	case "start":
+		if !h.services.Installer.IsInstalled(serverID) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"success": false,
+				"error":   "Server install has not completed",
+			})
+		}
+		restore = h.setDesiredState(serverID, crashguard.DesiredRunning)
 		err = h.dockerClient.StartContainer(serverID)
 	case "stop":
+		restore = h.setDesiredState(serverID, crashguard.DesiredStopped)
 		err = h.dockerClient.StopContainer(serverID)
 	case "restart":
+		restore = h.setDesiredState(serverID, crashguard.DesiredRestarting)
 		err = h.dockerClient.RestartContainer(serverID)
 	case "kill":
+		restore = h.setDesiredState(serverID, crashguard.DesiredStopped)
 		err = h.dockerClient.KillContainer(serverID)
 	default:
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -73,6 +366,9 @@ func (h *Handlers) ServerPowerAction(c *fiber.Ctx) error {
 	}
 
 	if err != nil {
+		// The server is still in whatever state it was, so the guard should treat it as before
+		restore()
+
 		h.logger.Error("Failed to execute power action",
 			zap.String("serverId", serverID),
 			zap.String("action", body.Action),
@@ -83,6 +379,11 @@ func (h *Handlers) ServerPowerAction(c *fiber.Ctx) error {
 		})
 	}
 
+	// Started by hand, the server gets a full set of crash restarts again
+	if h.services.CrashGuard != nil && (body.Action == "start" || body.Action == "restart") {
+		h.services.CrashGuard.ResetAttempts(serverID)
+	}
+
 	return c.JSON(fiber.Map{
 		"success": true,
 		"message": "Power action executed successfully",
@@ -110,9 +411,10 @@ func (h *Handlers) GetServerLogs(c *fiber.Ctx) error {
 	})
 }
 
-// SendServerCommand sends a command to a server
+// SendServerCommand writes a command to the server process's stdin
 func (h *Handlers) SendServerCommand(c *fiber.Ctx) error {
 	serverID := c.Params("serverId")
+	containerID, _ := c.Locals("containerId").(string)
 
 	var body struct {
 		Command string `json:"command"`
@@ -125,8 +427,22 @@ func (h *Handlers) SendServerCommand(c *fiber.Ctx) error {
 		})
 	}
 
-	// TODO: Implement command execution
-	h.logger.Info("Received command",
+	if err := h.services.Console.SendCommand(serverID, containerID, body.Command); err != nil {
+		h.logger.Warn("Failed to send command",
+			zap.String("serverId", serverID),
+			zap.Error(err))
+
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, console.ErrServerNotRunning) {
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	h.logger.Info("Command sent",
 		zap.String("serverId", serverID),
 		zap.String("command", body.Command))
 
@@ -140,25 +456,128 @@ func (h *Handlers) SendServerCommand(c *fiber.Ctx) error {
 func (h *Handlers) GetServerStats(c *fiber.Ctx) error {
 	serverID := c.Params("serverId")
 
-	stats, err := h.dockerClient.GetContainerStats(serverID)
+	snapshot, err := h.services.Stats.Collect(c.Context(), serverID)
 	if err != nil {
 		h.logger.Error("Failed to get server stats",
 			zap.String("serverId", serverID),
 			zap.Error(err))
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+
+		status := fiber.StatusInternalServerError
+		if errdefs.IsNotFound(err) {
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(fiber.Map{
 			"success": false,
 			"error":   err.Error(),
 		})
 	}
 
-	// TODO: Parse and format stats properly
-	return c.JSON(fiber.Map{
-		"cpuUsage":    0.0,
-		"memoryUsage": 0,
-		"diskUsage":   0,
-		"networkRx":   0,
-		"networkTx":   0,
-		"uptime":      0,
-		"_raw":        stats,
+	// Docker reports no disk usage for bind mounts, the quota tracker walks them instead
+	if usage, ok := h.services.DiskQuota.Usage(serverID); ok {
+		snapshot.DiskUsage = uint64(usage)
+	}
+
+	return c.JSON(snapshot)
+}
+
+// ResolveServerContainer looks up the container for the route's server and
+// stores its ID in the request locals
+func (h *Handlers) ResolveServerContainer(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	container, err := h.dockerClient.FindServerContainer(serverID)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, docker.ErrServerNotFound) {
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	c.Locals("containerId", container.ID)
+	return c.Next()
+}
+
+// ConsoleWebSocket attaches a WebSocket client to the server's shared console stream
+func (h *Handlers) ConsoleWebSocket(conn *websocket.Conn) {
+	serverID := conn.Params("serverId")
+	containerID, _ := conn.Locals("containerId").(string)
+	defer conn.Close()
+
+	stream, err := h.services.Console.GetOrCreateStream(serverID, containerID)
+	if err != nil {
+		h.logger.Warn("Failed to open console stream",
+			zap.String("serverId", serverID),
+			zap.Error(err))
+		conn.WriteJSON(fiber.Map{
+			"type":  "error",
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// ?commands=rcon sends this client's commands over RCON, for games that
+	// do not read stdin, which takes the RCON grant rather than console write
+	routeRCON := conn.Query("commands") == "rcon"
+	sendPermission := PermissionConsoleWrite
+	if routeRCON {
+		sendPermission = PermissionRCONExecute
+	}
+
+	// Writing to the console and running shell commands are separate grants
+	claims, _ := conn.Locals("claims").(*Claims)
+	stream.AddClient(conn, console.ClientPermissions{
+		SendCommands: claims.HasPermission(sendPermission),
+		Exec:         claims.HasPermission(PermissionConsoleExec),
 	})
+	defer stream.RemoveClient(conn)
+
+	if routeRCON {
+		stream.RouteCommands(conn, func(command string) (string, error) {
+			return h.rconExecute(serverID, command)
+		})
+	}
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if err := stream.HandleCommand(conn, message); err != nil {
+			h.logger.Warn("Console command failed",
+				zap.String("serverId", serverID),
+				zap.Error(err))
+			stream.SendError(conn, err.Error())
+		}
+	}
+}
+
+// StatsWebSocket streams live resource stats to a WebSocket client
+func (h *Handlers) StatsWebSocket(conn *websocket.Conn) {
+	serverID := conn.Params("serverId")
+	containerID, _ := conn.Locals("containerId").(string)
+	defer conn.Close()
+
+	if err := h.services.StatsStreams.Subscribe(serverID, containerID, conn); err != nil {
+		h.logger.Warn("Failed to subscribe to stats stream",
+			zap.String("serverId", serverID),
+			zap.Error(err))
+		conn.WriteJSON(fiber.Map{
+			"type":  "error",
+			"error": err.Error(),
+		})
+		return
+	}
+	defer h.services.StatsStreams.Unsubscribe(serverID, conn)
+
+	// Stats only flow to the client; reading detects when it disconnects
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
 }
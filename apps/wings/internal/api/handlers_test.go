@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mambapanel/wings/internal/config"
+	"github.com/mambapanel/wings/internal/console"
+	"github.com/mambapanel/wings/internal/docker"
+	"github.com/mambapanel/wings/internal/files"
+	"github.com/mambapanel/wings/internal/installer"
+	"github.com/mambapanel/wings/internal/rcon"
+	"github.com/mambapanel/wings/internal/stats"
+	"go.uber.org/zap"
+)
+
+// fakeDaemon is a Docker daemon that answers container removals with a fixed
+// status and records whether the server's data was still there at the time
+type fakeDaemon struct {
+	removeStatus int
+	dataPath     string
+
+	mu       sync.Mutex
+	removed  []string
+	dataLeft bool
+}
+
+func (d *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/_ping") {
+		w.Header().Set("API-Version", "1.43")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodDelete || !strings.Contains(r.URL.Path, "/containers/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	d.mu.Lock()
+	d.removed = append(d.removed, r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+	_, err := os.Stat(d.dataPath)
+	d.dataLeft = err == nil
+	d.mu.Unlock()
+
+	if d.removeStatus == http.StatusNoContent {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(d.removeStatus)
+	json.NewEncoder(w).Encode(map[string]string{"message": http.StatusText(d.removeStatus)})
+}
+
+// deleteTestApp serves DeleteServer against a fake Docker daemon, with every
+// service backed by temp directories
+func deleteTestApp(t *testing.T, daemon *fakeDaemon) (*fiber.App, *config.Config) {
+	t.Helper()
+
+	server := httptest.NewServer(daemon)
+	t.Cleanup(server.Close)
+	t.Setenv("DOCKER_HOST", "tcp://"+server.Listener.Addr().String())
+
+	dockerClient, err := docker.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dockerClient.Close() })
+
+	logger := zap.NewNop()
+	cfg := testConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.StateDir = t.TempDir()
+
+	consoleManager := console.NewManager(dockerClient.GetClient(), logger)
+	diskQuota := files.NewDiskQuota(cfg.DataDir, nil, nil, "", logger)
+	hostFiles := files.NewHostManager(cfg.DataDir, diskQuota, nil, logger)
+
+	handlers := NewHandlers(logger, dockerClient, cfg, &Services{
+		Console:         consoleManager,
+		Files:           hostFiles,
+		Uploads:         files.NewUploadManager(hostFiles, logger),
+		DiskQuota:       diskQuota,
+		Installer:       installer.NewManager(dockerClient, consoleManager, cfg.DataDir, cfg.StateDir, logger),
+		RCON:            rcon.NewPool(logger),
+		RCONCredentials: rcon.NewCredentials(cfg.StateDir),
+		StatsStreams:    stats.NewManager(dockerClient.GetClient(), logger),
+	})
+
+	app := fiber.New()
+	app.Delete("/servers/:serverId", handlers.DeleteServer)
+	return app, cfg
+}
+
+func TestDeleteServer(t *testing.T) {
+	// Guarding stops before the container goes, and the data goes last
+	wantSteps := []string{"crashguard", "console", "rcon", "installer", "uploads", "quota", "stats", "metrics", "container", "volume"}
+
+	for _, tt := range []struct {
+		name         string
+		removeStatus int
+		wantStatus   int
+		wantFailed   []string
+	}{
+		{name: "container removed", removeStatus: http.StatusNoContent, wantStatus: fiber.StatusOK},
+		{name: "no container", removeStatus: http.StatusNotFound, wantStatus: fiber.StatusOK},
+		{
+			name:         "daemon error",
+			removeStatus: http.StatusInternalServerError,
+			wantStatus:   fiber.StatusInternalServerError,
+			wantFailed:   []string{"container"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			daemon := &fakeDaemon{removeStatus: tt.removeStatus}
+			app, cfg := deleteTestApp(t, daemon)
+
+			daemon.dataPath = filepath.Join(cfg.DataDir, "srv")
+			if err := os.MkdirAll(filepath.Join(daemon.dataPath, "world"), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodDelete, "/servers/srv", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			var body struct {
+				Success bool         `json:"success"`
+				Steps   []deleteStep `json:"steps"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Success != (len(tt.wantFailed) == 0) {
+				t.Fatalf("success = %v with failed steps %v", body.Success, tt.wantFailed)
+			}
+
+			var names, failed []string
+			for _, step := range body.Steps {
+				names = append(names, step.Step)
+				if !step.Success {
+					if step.Error == "" {
+						t.Fatalf("step %s failed without an error", step.Step)
+					}
+					failed = append(failed, step.Step)
+				}
+			}
+			if !slices.Equal(names, wantSteps) {
+				t.Fatalf("steps = %v, want %v", names, wantSteps)
+			}
+			if !slices.Equal(failed, tt.wantFailed) {
+				t.Fatalf("failed steps = %v, want %v", failed, tt.wantFailed)
+			}
+
+			// Any install container goes with the installer step, the server's
+			// own before its data, and a failure does not stop the steps after it
+			if !slices.Equal(daemon.removed, []string{"srv_installer", "srv"}) || !daemon.dataLeft {
+				t.Fatalf("container removals = %v with data left %v, want the server's before the volume", daemon.removed, daemon.dataLeft)
+			}
+			if _, err := os.Stat(daemon.dataPath); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("server data kept after delete")
+			}
+		})
+	}
+}
+
+func TestDeleteServerInvalidID(t *testing.T) {
+	daemon := &fakeDaemon{removeStatus: http.StatusNoContent}
+	app, _ := deleteTestApp(t, daemon)
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodDelete, "/servers/.srv", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, fiber.StatusBadRequest)
+	}
+	if len(daemon.removed) != 0 {
+		t.Fatalf("container removed for an invalid server ID")
+	}
+}
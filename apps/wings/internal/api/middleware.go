@@ -2,48 +2,121 @@ package api
 
 import (
 	"strings"
+	"time"
 
 	"github.com/mambapanel/wings/internal/config"
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 	"go.uber.org/zap"
 )
 
+// maxWebSocketTokenLifetime caps how long a query-string token may be valid for,
+// since URLs end up in proxy and browser logs
+const maxWebSocketTokenLifetime = 15 * time.Minute
+
 func AuthMiddleware(logger *zap.Logger, cfg *config.Config) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"success": false,
-				"error":   "Missing authorization header",
-			})
+			// Browsers cannot set headers on WebSocket upgrades, so those
+			// requests carry a short-lived token in the query string instead
+			if websocket.IsWebSocketUpgrade(c) && c.Query("token") != "" {
+				return authenticateWebSocket(c, logger, cfg)
+			}
+
+			return deny(c, fiber.StatusUnauthorized, ReasonMissingToken, "Missing authorization header")
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenString == authHeader {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"success": false,
-				"error":   "Invalid authorization format",
-			})
+			return deny(c, fiber.StatusUnauthorized, ReasonMalformedToken, "Invalid authorization format")
 		}
 
 		// Parse and validate JWT token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.TokenSecret), nil
-		})
-
-		if err != nil || !token.Valid {
-			logger.Warn("Invalid token", zap.Error(err))
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"success": false,
-				"error":   "Invalid token",
-			})
+		claims, reason, err := parseToken(tokenString, cfg)
+		if err != nil {
+			logger.Warn("Invalid token", zap.String("reason", reason), zap.Error(err))
+			return deny(c, fiber.StatusUnauthorized, reason, "Invalid token")
+		}
+
+		c.Locals("claims", claims)
+
+		return c.Next()
+	}
+}
+
+// authenticateWebSocket validates a query-string token, which must expire
+// within maxWebSocketTokenLifetime of being issued
+func authenticateWebSocket(c *fiber.Ctx, logger *zap.Logger, cfg *config.Config) error {
+	claims, reason, err := parseToken(c.Query("token"), cfg)
+	if err != nil {
+		logger.Warn("Invalid WebSocket token", zap.String("reason", reason), zap.Error(err))
+		return deny(c, fiber.StatusUnauthorized, reason, "Invalid token")
+	}
+
+	if claims.IssuedAt == nil || claims.ExpiresAt.Sub(claims.IssuedAt.Time) > maxWebSocketTokenLifetime {
+		return deny(c, fiber.StatusUnauthorized, ReasonTokenLifetime, "WebSocket token lifetime too long")
+	}
+
+	c.Locals("claims", claims)
+
+	return c.Next()
+}
+
+// Authorize checks the token's scope for a route. Routes with a :serverId
+// parameter require a token issued for that server; an empty permission
+// only checks the server scope, leaving finer checks to the handler.
+func Authorize(logger *zap.Logger, permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims := claimsFrom(c)
+
+		if serverID := c.Params("serverId"); serverID != "" && !claims.CanAccessServer(serverID) {
+			logger.Warn("Token not valid for server",
+				zap.String("serverId", serverID),
+				zap.String("tokenServerId", claims.ServerID))
+			return deny(c, fiber.StatusForbidden, ReasonServerMismatch, "Token not valid for this server")
+		}
+
+		if permission != "" && !claims.HasPermission(permission) {
+			return denyPermission(c, logger, permission)
 		}
 
 		return c.Next()
 	}
 }
 
+// claimsFrom returns the validated claims stored by AuthMiddleware
+func claimsFrom(c *fiber.Ctx) *Claims {
+	claims, _ := c.Locals("claims").(*Claims)
+	if claims == nil {
+		return &Claims{}
+	}
+	return claims
+}
+
+// denyPermission rejects a request whose token lacks a permission
+func denyPermission(c *fiber.Ctx, logger *zap.Logger, permission string) error {
+	logger.Warn("Token missing permission",
+		zap.String("path", c.Path()),
+		zap.String("permission", permission))
+
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"success":    false,
+		"error":      "Missing permission " + permission,
+		"reason":     ReasonMissingPermission,
+		"permission": permission,
+	})
+}
+
+// deny writes an authentication or authorization failure with a machine-readable reason
+func deny(c *fiber.Ctx, status int, reason, message string) error {
+	return c.Status(status).JSON(fiber.Map{
+		"success": false,
+		"error":   message,
+		"reason":  reason,
+	})
+}
+
 func LoggerMiddleware(logger *zap.Logger) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		logger.Info("Request",
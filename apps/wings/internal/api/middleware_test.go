@@ -0,0 +1,210 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mambapanel/wings/internal/config"
+	"go.uber.org/zap"
+)
+
+func testConfig() *config.Config {
+	return &config.Config{
+		TokenSecret:     "test-secret",
+		TokenAudience:   "wings",
+		TokenAlgorithms: []string{"HS256"},
+	}
+}
+
+func testApp(cfg *config.Config) *fiber.App {
+	logger := zap.NewNop()
+	app := fiber.New()
+	app.Use(AuthMiddleware(logger, cfg))
+	app.Get("/servers/:serverId/stats", Authorize(logger, PermissionStatsRead), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	return app
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, claims Claims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(method, claims).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
+func validClaims() Claims {
+	now := time.Now()
+	return Claims{
+		ServerID:    "server-1",
+		Permissions: []string{PermissionStatsRead},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{"wings"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	expired := validClaims()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	wrongAudience := validClaims()
+	wrongAudience.Audience = jwt.ClaimStrings{"other"}
+
+	noPermission := validClaims()
+	noPermission.Permissions = []string{PermissionConsoleRead}
+
+	wildcard := validClaims()
+	wildcard.Permissions = []string{PermissionAll}
+
+	nodeToken := validClaims()
+	nodeToken.ServerID = ""
+	nodeToken.Permissions = []string{PermissionAll}
+
+	unscoped := validClaims()
+	unscoped.ServerID = ""
+
+	noAudience := validClaims()
+	noAudience.Audience = nil
+
+	noExpiry := validClaims()
+	noExpiry.ExpiresAt = nil
+
+	tests := []struct {
+		name       string
+		method     jwt.SigningMethod
+		claims     Claims
+		path       string
+		wantStatus int
+		wantReason string
+	}{
+		{"valid", jwt.SigningMethodHS256, validClaims(), "/servers/server-1/stats", fiber.StatusOK, ""},
+		{"wildcard permission", jwt.SigningMethodHS256, wildcard, "/servers/server-1/stats", fiber.StatusOK, ""},
+		{"expired", jwt.SigningMethodHS256, expired, "/servers/server-1/stats", fiber.StatusUnauthorized, ReasonTokenExpired},
+		{"wrong audience", jwt.SigningMethodHS256, wrongAudience, "/servers/server-1/stats", fiber.StatusUnauthorized, ReasonInvalidAudience},
+		{"no audience", jwt.SigningMethodHS256, noAudience, "/servers/server-1/stats", fiber.StatusUnauthorized, ReasonInvalidAudience},
+		{"no expiry", jwt.SigningMethodHS256, noExpiry, "/servers/server-1/stats", fiber.StatusUnauthorized, ReasonMissingExpiry},
+		{"disallowed algorithm", jwt.SigningMethodHS512, validClaims(), "/servers/server-1/stats", fiber.StatusUnauthorized, ReasonInvalidAlgorithm},
+		{"other server", jwt.SigningMethodHS256, validClaims(), "/servers/server-2/stats", fiber.StatusForbidden, ReasonServerMismatch},
+		{"node token", jwt.SigningMethodHS256, nodeToken, "/servers/server-2/stats", fiber.StatusOK, ""},
+		{"wildcard scoped to other server", jwt.SigningMethodHS256, wildcard, "/servers/server-2/stats", fiber.StatusForbidden, ReasonServerMismatch},
+		{"unscoped without wildcard", jwt.SigningMethodHS256, unscoped, "/servers/server-1/stats", fiber.StatusForbidden, ReasonServerMismatch},
+		{"missing permission", jwt.SigningMethodHS256, noPermission, "/servers/server-1/stats", fiber.StatusForbidden, ReasonMissingPermission},
+	}
+
+	app := testApp(testConfig())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+signToken(t, tt.method, tt.claims))
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			if tt.wantReason == "" {
+				return
+			}
+
+			var body struct {
+				Reason string `json:"reason"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("failed to decode body: %v", err)
+			}
+			if body.Reason != tt.wantReason {
+				t.Fatalf("reason = %q, want %q", body.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestAuthorizationMissingToken(t *testing.T) {
+	app := testApp(testConfig())
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/servers/server-1/stats", nil))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, fiber.StatusUnauthorized)
+	}
+}
+
+func TestCreateServerScope(t *testing.T) {
+	logger := zap.NewNop()
+	cfg := testConfig()
+	handlers := NewHandlers(logger, nil, cfg, &Services{})
+
+	app := fiber.New()
+	app.Use(AuthMiddleware(logger, cfg))
+	app.Post("/servers", Authorize(logger, PermissionServerCreate), handlers.CreateServer)
+
+	scoped := validClaims()
+	scoped.Permissions = []string{PermissionServerCreate}
+
+	nodeToken := validClaims()
+	nodeToken.ServerID = ""
+	nodeToken.Permissions = []string{PermissionAll}
+
+	// Bodies without an image fail validation, so requests let through stop there
+	tests := []struct {
+		name       string
+		claims     Claims
+		serverID   string
+		wantStatus int
+		wantReason string
+	}{
+		{"own server", scoped, "server-1", fiber.StatusBadRequest, ""},
+		{"other server", scoped, "server-2", fiber.StatusForbidden, ReasonServerMismatch},
+		{"no server ID", scoped, "", fiber.StatusForbidden, ReasonServerMismatch},
+		{"node token", nodeToken, "server-2", fiber.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/servers", strings.NewReader(`{"serverId":"`+tt.serverID+`"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodHS256, tt.claims))
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			var body struct {
+				Reason string `json:"reason"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("failed to decode body: %v", err)
+			}
+			if body.Reason != tt.wantReason {
+				t.Fatalf("reason = %q, want %q", body.Reason, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/mambapanel/wings/internal/docker"
+	"github.com/mambapanel/wings/internal/rcon"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// SendRCONCommand runs a one-off command over the server's RCON connection
+// and returns the game's response. The RCON address and password come from
+// the server's configuration, never from the request.
+func (h *Handlers) SendRCONCommand(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	var body struct {
+		Command string `json:"command"`
+	}
+
+	if err := c.BodyParser(&body); err != nil || body.Command == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	response, err := h.rconExecute(serverID, body.Command)
+	if err != nil {
+		return h.rconError(c, serverID, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success":  true,
+		"response": response,
+	})
+}
+
+// SetRCONPassword replaces a server's stored RCON password, for when the
+// game's password is rotated. Pooled connections log in again with it.
+func (h *Handlers) SetRCONPassword(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	var body struct {
+		Password string `json:"password"`
+	}
+
+	if err := c.BodyParser(&body); err != nil || body.Password == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+	}
+
+	container, err := h.dockerClient.FindServerContainer(serverID)
+	if err != nil {
+		return h.rconError(c, serverID, err)
+	}
+	if container.Labels[docker.LabelRCONPort] == "" {
+		return h.rconError(c, serverID, docker.ErrRCONNotConfigured)
+	}
+
+	if err := h.services.RCONCredentials.SetPassword(serverID, body.Password); err != nil {
+		h.logger.Error("Failed to store RCON password",
+			zap.String("serverId", serverID),
+			zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	h.logger.Info("RCON password updated", zap.String("serverId", serverID))
+
+	return c.JSON(fiber.Map{
+		"success": true,
+	})
+}
+
+// GetRCONStats reports the state of a server's pooled RCON connection. A
+// server without one reports as neither connected nor reconnecting.
+func (h *Handlers) GetRCONStats(c *fiber.Ctx) error {
+	serverID := c.Params("serverId")
+
+	stats, exists := h.services.RCON.Stats(serverID)
+	if !exists {
+		stats = rcon.PoolStats{ServerID: serverID}
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"rcon":    stats,
+	})
+}
+
+// rconExecute runs a command through the pool using the server's stored RCON settings
+func (h *Handlers) rconExecute(serverID, command string) (string, error) {
+	endpoint, err := h.dockerClient.ServerRCON(serverID)
+	if err != nil {
+		return "", err
+	}
+	password, err := h.services.RCONCredentials.Password(serverID)
+	if err != nil {
+		return "", err
+	}
+
+	target := rcon.Target{
+		Protocol: rcon.Protocol(endpoint.Protocol),
+		Host:     endpoint.Host,
+		Port:     endpoint.Port,
+		Password: password,
+	}
+
+	h.logger.Info("Sending RCON command",
+		zap.String("serverId", serverID),
+		zap.String("protocol", string(target.Protocol)),
+		zap.String("command", command))
+
+	return h.services.RCON.Execute(serverID, target, command)
+}
+
+// rconError maps an RCON failure to a status code and writes the error response
+func (h *Handlers) rconError(c *fiber.Ctx, serverID string, err error) error {
+	// Anything else is the game server failing to answer
+	status := fiber.StatusBadGateway
+	switch {
+	case errors.Is(err, docker.ErrServerNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, docker.ErrRCONNotConfigured), errors.Is(err, rcon.ErrNoPassword),
+		errors.Is(err, rcon.ErrCommandTooLong), errors.Is(err, rcon.ErrInvalidCommand):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, docker.ErrContainerNotRunning):
+		status = fiber.StatusConflict
+	case errors.Is(err, rcon.ErrReconnecting):
+		status = fiber.StatusServiceUnavailable
+	}
+
+	h.logger.Warn("RCON command failed",
+		zap.String("serverId", serverID),
+		zap.Error(err))
+
+	return c.Status(status).JSON(fiber.Map{
+		"success": false,
+		"error":   err.Error(),
+	})
+}
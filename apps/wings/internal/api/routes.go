@@ -2,13 +2,36 @@ package api
 
 import (
 	"github.com/mambapanel/wings/internal/config"
+	"github.com/mambapanel/wings/internal/console"
+	"github.com/mambapanel/wings/internal/crashguard"
 	"github.com/mambapanel/wings/internal/docker"
+	"github.com/mambapanel/wings/internal/files"
+	"github.com/mambapanel/wings/internal/installer"
+	"github.com/mambapanel/wings/internal/metrics"
+	"github.com/mambapanel/wings/internal/rcon"
+	"github.com/mambapanel/wings/internal/stats"
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"go.uber.org/zap"
 )
 
-func SetupRoutes(app *fiber.App, logger *zap.Logger, dockerClient *docker.Client, cfg *config.Config) {
+// Services holds the long-running subsystems the handlers depend on
+type Services struct {
+	Console         *console.Manager
+	Files           files.Filesystem
+	Uploads         *files.UploadManager
+	DiskQuota       *files.DiskQuota
+	Installer       *installer.Manager
+	RCON            *rcon.Pool
+	RCONCredentials *rcon.Credentials
+	Stats           *stats.Collector
+	StatsStreams    *stats.Manager
+	CrashGuard      *crashguard.Guard // nil when the mTLS API client is unavailable
+	Metrics         *metrics.Emitter  // nil when the mTLS API client is unavailable
+}
+
+func SetupRoutes(app *fiber.App, logger *zap.Logger, dockerClient *docker.Client, cfg *config.Config, services *Services) {
 	// Middleware
 	app.Use(cors.New())
 	app.Use(LoggerMiddleware(logger))
@@ -19,7 +42,7 @@ func SetupRoutes(app *fiber.App, logger *zap.Logger, dockerClient *docker.Client
 	})
 
 	// Create handlers
-	handlers := NewHandlers(logger, dockerClient, cfg)
+	handlers := NewHandlers(logger, dockerClient, cfg, services)
 
 	// API routes
 	api := app.Group("/api")
@@ -28,11 +51,58 @@ func SetupRoutes(app *fiber.App, logger *zap.Logger, dockerClient *docker.Client
 	api.Use(AuthMiddleware(logger, cfg))
 
 	// System routes
-	api.Get("/system/status", handlers.GetSystemStatus)
+	api.Get("/system/status", Authorize(logger, PermissionSystemRead), handlers.GetSystemStatus)
 
 	// Server routes
-	api.Post("/servers/:serverId/power", handlers.ServerPowerAction)
-	api.Get("/servers/:serverId/logs", handlers.GetServerLogs)
-	api.Post("/servers/:serverId/command", handlers.SendServerCommand)
-	api.Get("/servers/:serverId/stats", handlers.GetServerStats)
+	api.Post("/servers", Authorize(logger, PermissionServerCreate), handlers.CreateServer)
+	api.Delete("/servers/:serverId", Authorize(logger, PermissionServerDelete), handlers.DeleteServer)
+	api.Post("/servers/:serverId/power", Authorize(logger, ""), handlers.ServerPowerAction)
+	api.Get("/servers/:serverId/logs", Authorize(logger, PermissionConsoleRead), handlers.GetServerLogs)
+	api.Post("/servers/:serverId/command", Authorize(logger, PermissionConsoleWrite), handlers.ResolveServerContainer, handlers.SendServerCommand)
+	api.Post("/servers/:serverId/rcon", Authorize(logger, PermissionRCONExecute), handlers.SendRCONCommand)
+	api.Put("/servers/:serverId/rcon/password", Authorize(logger, PermissionServerUpdate), handlers.SetRCONPassword)
+	api.Get("/servers/:serverId/rcon/stats", Authorize(logger, PermissionConsoleRead), handlers.GetRCONStats)
+	api.Get("/servers/:serverId/stats", Authorize(logger, PermissionStatsRead), handlers.GetServerStats)
+	api.Get("/servers/:serverId/stats/ws", requireWebSocket, Authorize(logger, PermissionStatsRead), handlers.ResolveServerContainer, websocket.New(handlers.StatsWebSocket))
+	api.Get("/servers/:serverId/ws", requireWebSocket, Authorize(logger, PermissionConsoleRead), handlers.ResolveServerContainer, websocket.New(handlers.ConsoleWebSocket))
+	api.Post("/servers/:serverId/install", Authorize(logger, PermissionServerInstall), handlers.InstallServer)
+	api.Post("/servers/:serverId/reinstall", Authorize(logger, PermissionServerInstall), handlers.ReinstallServer)
+	api.Get("/servers/:serverId/install", Authorize(logger, PermissionServerInstall), handlers.GetInstallStatus)
+	api.Get("/servers/:serverId/crash-policy", Authorize(logger, PermissionServerUpdate), handlers.GetCrashPolicy)
+	api.Put("/servers/:serverId/crash-policy", Authorize(logger, PermissionServerUpdate), handlers.SetCrashPolicy)
+	api.Delete("/servers/:serverId/crash-policy", Authorize(logger, PermissionServerUpdate), handlers.DeleteCrashPolicy)
+	api.Get("/servers/:serverId/crashes", Authorize(logger, PermissionConsoleRead), handlers.ListCrashReports)
+	api.Get("/servers/:serverId/crashes/:crashId", Authorize(logger, PermissionConsoleRead), handlers.GetCrashReport)
+
+	// File routes
+	api.Get("/servers/:serverId/files/list", Authorize(logger, PermissionFilesRead), handlers.ListFiles)
+	api.Get("/servers/:serverId/files/contents", Authorize(logger, PermissionFilesRead), handlers.GetFileContents)
+	api.Get("/servers/:serverId/files/download", Authorize(logger, PermissionFilesRead), handlers.DownloadFile)
+	api.Get("/servers/:serverId/files/search", Authorize(logger, PermissionFilesRead), handlers.SearchFiles)
+	api.Post("/servers/:serverId/files/write", Authorize(logger, PermissionFilesWrite), handlers.WriteFile)
+	api.Post("/servers/:serverId/files/upload", Authorize(logger, PermissionFilesWrite), handlers.WriteFile)
+	api.Post("/servers/:serverId/files/create-directory", Authorize(logger, PermissionFilesWrite), handlers.CreateDirectory)
+	api.Post("/servers/:serverId/files/compress", Authorize(logger, PermissionFilesWrite), handlers.CompressFiles)
+	api.Post("/servers/:serverId/files/extract", Authorize(logger, PermissionFilesWrite), handlers.ExtractArchive)
+	api.Post("/servers/:serverId/files/rename", Authorize(logger, PermissionFilesWrite), handlers.RenameFile)
+	api.Post("/servers/:serverId/files/move", Authorize(logger, PermissionFilesWrite), handlers.MoveFile)
+	api.Post("/servers/:serverId/files/copy", Authorize(logger, PermissionFilesWrite), handlers.CopyFile)
+	api.Post("/servers/:serverId/files/chmod", Authorize(logger, PermissionFilesWrite), handlers.ChmodFile)
+	api.Post("/servers/:serverId/files/chown", Authorize(logger, PermissionFilesWrite), handlers.ChownFile)
+	api.Post("/servers/:serverId/files/delete", Authorize(logger, PermissionFilesDelete), handlers.DeleteFiles)
+
+	// Resumable upload routes
+	api.Post("/servers/:serverId/files/uploads", Authorize(logger, PermissionFilesWrite), handlers.CreateUpload)
+	api.Get("/servers/:serverId/files/uploads/:uploadId", Authorize(logger, PermissionFilesWrite), handlers.GetUpload)
+	api.Patch("/servers/:serverId/files/uploads/:uploadId", Authorize(logger, PermissionFilesWrite), handlers.WriteUploadChunk)
+	api.Post("/servers/:serverId/files/uploads/:uploadId/complete", Authorize(logger, PermissionFilesWrite), handlers.CompleteUpload)
+	api.Delete("/servers/:serverId/files/uploads/:uploadId", Authorize(logger, PermissionFilesWrite), handlers.CancelUpload)
+}
+
+// requireWebSocket rejects plain HTTP requests to WebSocket endpoints
+func requireWebSocket(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+	return c.Next()
 }
@@ -5,10 +5,14 @@ import (
 )
 
 type Config struct {
-	Host        string `mapstructure:"host"`
-	Port        int    `mapstructure:"port"`
-	Debug       bool   `mapstructure:"debug"`
-	TokenSecret string `mapstructure:"token_secret"`
+	Host            string   `mapstructure:"host"`
+	Port            int      `mapstructure:"port"`
+	Debug           bool     `mapstructure:"debug"`
+	TokenSecret     string   `mapstructure:"token_secret"`
+	TokenAudience   string   `mapstructure:"token_audience"`
+	TokenAlgorithms []string `mapstructure:"token_algorithms"`
+	DataDir         string   `mapstructure:"data_dir"`
+	StateDir        string   `mapstructure:"state_dir"`
 }
 
 func Load() (*Config, error) {
@@ -22,6 +26,10 @@ func Load() (*Config, error) {
 	viper.SetDefault("host", "0.0.0.0")
 	viper.SetDefault("port", 8080)
 	viper.SetDefault("debug", false)
+	viper.SetDefault("token_audience", "wings")
+	viper.SetDefault("token_algorithms", []string{"HS256"})
+	viper.SetDefault("data_dir", "/var/lib/mamba/volumes")
+	viper.SetDefault("state_dir", "/var/lib/mamba/state")
 
 	// Environment variables
 	viper.SetEnvPrefix("WINGS")
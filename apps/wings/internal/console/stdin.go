@@ -0,0 +1,123 @@
+package console
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"go.uber.org/zap"
+)
+
+// ErrServerNotRunning is returned when a command is sent to a stopped server
+var ErrServerNotRunning = errors.New("server is not running")
+
+// Stdin holds a hijacked attach connection to a container's stdin so
+// commands reach the server process the same way typed console input would
+type Stdin struct {
+	serverID     string
+	containerID  string
+	dockerClient dockerAPI
+	logger       *zap.Logger
+
+	conn *types.HijackedResponse
+	mu   sync.Mutex
+}
+
+// NewStdin creates a stdin writer for a container; it attaches on first use
+func NewStdin(serverID, containerID string, dockerClient *client.Client, logger *zap.Logger) *Stdin {
+	return newStdin(serverID, containerID, dockerClient, logger)
+}
+
+func newStdin(serverID, containerID string, dockerClient dockerAPI, logger *zap.Logger) *Stdin {
+	return &Stdin{
+		serverID:     serverID,
+		containerID:  containerID,
+		dockerClient: dockerClient,
+		logger:       logger,
+	}
+}
+
+// Write sends a single-line command to the server process followed by a newline
+func (s *Stdin) Write(command string) error {
+	if strings.ContainsAny(command, "\r\n") {
+		return fmt.Errorf("command must be a single line")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.attach(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.conn.Conn.Write([]byte(command + "\n")); err != nil {
+		s.closeLocked()
+		return fmt.Errorf("failed to write to server stdin: %w", err)
+	}
+
+	return nil
+}
+
+// Close releases the attach connection
+func (s *Stdin) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closeLocked()
+}
+
+// attach opens the hijacked connection; the caller must hold s.mu
+func (s *Stdin) attach() error {
+	inspect, err := s.dockerClient.ContainerInspect(context.Background(), s.containerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if inspect.State == nil || !inspect.State.Running {
+		return ErrServerNotRunning
+	}
+	if !inspect.Config.OpenStdin {
+		return fmt.Errorf("container was not created with stdin open")
+	}
+
+	resp, err := s.dockerClient.ContainerAttach(context.Background(), s.containerID, container.AttachOptions{
+		Stream: true,
+		Stdin:  true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach to container stdin: %w", err)
+	}
+
+	s.conn = &resp
+	s.logger.Debug("Attached to server stdin", zap.String("serverID", s.serverID))
+
+	// Docker closes the connection when the container exits; drop it then so
+	// the next command reattaches to the new process
+	go func(conn *types.HijackedResponse) {
+		io.Copy(io.Discard, conn.Reader)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.conn == conn {
+			s.closeLocked()
+		}
+	}(s.conn)
+
+	return nil
+}
+
+// closeLocked closes the connection; the caller must hold s.mu
+func (s *Stdin) closeLocked() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+		s.logger.Debug("Detached from server stdin", zap.String("serverID", s.serverID))
+	}
+}
@@ -0,0 +1,61 @@
+package console
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestManagerSendCommand(t *testing.T) {
+	docker := newFakeDocker()
+	m := NewManager(nil, zap.NewNop())
+	m.dockerClient = docker
+
+	// Anything that would make the server read a second command is refused
+	// before touching the container
+	for _, command := range []string{"say hi\nstop", "stop\r", "say hi\r\nop attacker", "\n"} {
+		if err := m.SendCommand("srv", "ctr", command); err == nil {
+			t.Fatalf("SendCommand(%q) succeeded", command)
+		}
+	}
+	if docker.attaches != 0 {
+		t.Fatalf("rejected commands attached to the container")
+	}
+
+	// Commands share one attach, each arriving as a single line
+	for _, command := range []string{"say hello", "list"} {
+		if err := m.SendCommand("srv", "ctr", command); err != nil {
+			t.Fatalf("SendCommand(%q): %v", command, err)
+		}
+		if got := <-docker.input; got != command {
+			t.Fatalf("stdin got %q, want %q", got, command)
+		}
+	}
+	if docker.attaches != 1 {
+		t.Fatalf("%d attaches for one container, want 1", docker.attaches)
+	}
+
+	// A recreated container gets a new attach
+	if err := m.SendCommand("srv", "ctr-2", "list"); err != nil {
+		t.Fatalf("SendCommand: %v", err)
+	}
+	<-docker.input
+	if docker.attaches != 2 {
+		t.Fatalf("%d attaches after the container changed, want 2", docker.attaches)
+	}
+	m.RemoveStream("srv")
+}
+
+func TestStdinServerNotRunning(t *testing.T) {
+	docker := newFakeDocker()
+	docker.stopped = true
+	stdin := newStdin("srv", "ctr", docker, zap.NewNop())
+
+	if err := stdin.Write("list"); !errors.Is(err, ErrServerNotRunning) {
+		t.Fatalf("Write to a stopped server error = %v, want %v", err, ErrServerNotRunning)
+	}
+	if docker.attaches != 0 {
+		t.Fatalf("attached to a stopped server")
+	}
+}
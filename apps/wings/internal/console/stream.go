@@ -4,12 +4,15 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 	"github.com/gofiber/contrib/websocket"
 	"go.uber.org/zap"
@@ -17,31 +20,70 @@ import (
 
 // LogEntry represents a single log line with metadata
 type LogEntry struct {
-	Type      string `json:"type"`      // "stdout" or "stderr"
+	Type      string `json:"type"`      // "stdout", "stderr", "install", "archive", "rcon" or "error"
 	Line      string `json:"line"`      // Log line content
 	Timestamp string `json:"timestamp"` // ISO 8601 timestamp
 }
 
 // CommandMessage represents a command sent from client
 type CommandMessage struct {
-	Type    string `json:"type"`    // "command"
+	Type    string `json:"type"`    // "command" for server stdin, "exec" for a shell in the container
 	Command string `json:"command"` // Command to execute
 }
 
+// ErrExecNotPermitted is returned when a client without exec access sends an exec message
+var ErrExecNotPermitted = errors.New("exec not permitted")
+
+// ErrCommandNotPermitted is returned when a read-only client sends a console command
+var ErrCommandNotPermitted = errors.New("console commands not permitted")
+
+// ClientPermissions controls what a WebSocket client may send
+type ClientPermissions struct {
+	SendCommands bool // Client may write to the server's stdin
+	Exec         bool // Client may run shell commands in the container
+}
+
+// CommandRouter sends a console command to the server some other way than
+// stdin, such as RCON, and returns the server's response
+type CommandRouter func(command string) (string, error)
+
+// clientState tracks a connected WebSocket client
+type clientState struct {
+	writeLock   sync.Mutex // Serializes writes to the connection
+	removed     bool       // Set under writeLock once the connection may be released
+	permissions ClientPermissions
+	router      CommandRouter // nil to write commands to stdin
+}
+
+// dockerAPI is the part of the Docker client console streams and stdin use
+type dockerAPI interface {
+	ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error)
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
+	ContainerAttach(ctx context.Context, containerID string, options container.AttachOptions) (types.HijackedResponse, error)
+	ContainerExecCreate(ctx context.Context, containerID string, config types.ExecConfig) (types.IDResponse, error)
+	ContainerExecAttach(ctx context.Context, execID string, config types.ExecStartCheck) (types.HijackedResponse, error)
+	ContainerExecStart(ctx context.Context, execID string, config types.ExecStartCheck) error
+}
+
 // Stream manages a WebSocket console stream for a container
 type Stream struct {
 	serverID     string
 	containerID  string
-	dockerClient *client.Client
+	dockerClient dockerAPI
 	logger       *zap.Logger
 
 	// WebSocket connections
-	clients     map[*websocket.Conn]bool
+	clients     map[*websocket.Conn]*clientState
 	clientsLock sync.RWMutex
 
+	// Server process stdin, shared with the Manager
+	stdin *Stdin
+
 	// Stream control
-	ctx    context.Context
-	cancel context.CancelFunc
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running bool
+	runLock sync.Mutex
 
 	// Buffering
 	buffer     []LogEntry
@@ -51,6 +93,10 @@ type Stream struct {
 
 // NewStream creates a new console stream
 func NewStream(serverID, containerID string, dockerClient *client.Client, logger *zap.Logger) *Stream {
+	return newStream(serverID, containerID, dockerClient, logger)
+}
+
+func newStream(serverID, containerID string, dockerClient dockerAPI, logger *zap.Logger) *Stream {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &Stream{
@@ -58,7 +104,7 @@ func NewStream(serverID, containerID string, dockerClient *client.Client, logger
 		containerID:  containerID,
 		dockerClient: dockerClient,
 		logger:       logger,
-		clients:      make(map[*websocket.Conn]bool),
+		clients:      make(map[*websocket.Conn]*clientState),
 		buffer:       make([]LogEntry, 0),
 		bufferSize:   100, // Keep last 100 lines
 		ctx:          ctx,
@@ -66,12 +112,12 @@ func NewStream(serverID, containerID string, dockerClient *client.Client, logger
 	}
 }
 
-// AddClient adds a WebSocket client to the stream
-func (s *Stream) AddClient(conn *websocket.Conn) {
+// AddClient adds a WebSocket client to the stream with the given permissions
+func (s *Stream) AddClient(conn *websocket.Conn, permissions ClientPermissions) {
 	s.clientsLock.Lock()
 	defer s.clientsLock.Unlock()
 
-	s.clients[conn] = true
+	s.clients[conn] = &clientState{permissions: permissions}
 	s.logger.Info("Client added to console stream",
 		zap.String("serverID", s.serverID),
 		zap.Int("totalClients", len(s.clients)))
@@ -80,15 +126,36 @@ func (s *Stream) AddClient(conn *websocket.Conn) {
 	go s.sendBufferToClient(conn)
 }
 
-// RemoveClient removes a WebSocket client from the stream
-func (s *Stream) RemoveClient(conn *websocket.Conn) {
+// RouteCommands sends a client's console commands through router instead of
+// the server's stdin, for games that do not read stdin. Responses go back to
+// that client only, since other clients never saw the command.
+func (s *Stream) RouteCommands(conn *websocket.Conn, router CommandRouter) {
 	s.clientsLock.Lock()
 	defer s.clientsLock.Unlock()
 
+	if state, exists := s.clients[conn]; exists {
+		state.router = router
+	}
+}
+
+// RemoveClient removes a WebSocket client from the stream. It waits for a
+// write in progress, so the connection is not used once it returns.
+func (s *Stream) RemoveClient(conn *websocket.Conn) {
+	s.clientsLock.Lock()
+	state, exists := s.clients[conn]
 	delete(s.clients, conn)
+	remaining := len(s.clients)
+	s.clientsLock.Unlock()
+
+	if exists {
+		state.writeLock.Lock()
+		state.removed = true
+		state.writeLock.Unlock()
+	}
+
 	s.logger.Info("Client removed from console stream",
 		zap.String("serverID", s.serverID),
-		zap.Int("totalClients", len(s.clients)))
+		zap.Int("totalClients", remaining))
 }
 
 // Start begins streaming container logs
@@ -104,16 +171,51 @@ func (s *Stream) Start() error {
 		Tail:       "100", // Start with last 100 lines
 	}
 
+	s.runLock.Lock()
+	defer s.runLock.Unlock()
+
+	if s.running {
+		return nil
+	}
+
 	logReader, err := s.dockerClient.ContainerLogs(s.ctx, s.containerID, options)
 	if err != nil {
 		return fmt.Errorf("failed to attach to container logs: %w", err)
 	}
 
+	s.running = true
 	go s.streamLogs(logReader)
 
 	return nil
 }
 
+// reattach points an idle stream at a (possibly recreated) container
+func (s *Stream) reattach(containerID string, stdin *Stdin) {
+	s.runLock.Lock()
+	defer s.runLock.Unlock()
+
+	s.containerID = containerID
+	s.stdin = stdin
+}
+
+// IsRunning reports whether the stream is currently following container logs
+func (s *Stream) IsRunning() bool {
+	s.runLock.Lock()
+	defer s.runLock.Unlock()
+
+	return s.running
+}
+
+// Publish buffers and broadcasts a log entry produced outside the container log stream
+func (s *Stream) Publish(entry LogEntry) {
+	if entry.Timestamp == "" {
+		entry.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	s.addToBuffer(entry)
+	s.broadcast(entry)
+}
+
 // Stop stops the console stream
 func (s *Stream) Stop() {
 	s.logger.Info("Stopping console stream", zap.String("serverID", s.serverID))
@@ -123,15 +225,23 @@ func (s *Stream) Stop() {
 	s.clientsLock.Lock()
 	defer s.clientsLock.Unlock()
 
-	for conn := range s.clients {
+	for conn, state := range s.clients {
+		state.writeLock.Lock()
+		state.removed = true
 		conn.Close()
+		state.writeLock.Unlock()
 	}
-	s.clients = make(map[*websocket.Conn]bool)
+	s.clients = make(map[*websocket.Conn]*clientState)
 }
 
 // streamLogs reads from log reader and broadcasts to clients
 func (s *Stream) streamLogs(reader io.ReadCloser) {
 	defer reader.Close()
+	defer func() {
+		s.runLock.Lock()
+		s.running = false
+		s.runLock.Unlock()
+	}()
 
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
@@ -196,9 +306,13 @@ func (s *Stream) addToBuffer(entry LogEntry) {
 // broadcast sends a log entry to all connected clients
 func (s *Stream) broadcast(entry LogEntry) {
 	s.clientsLock.RLock()
-	defer s.clientsLock.RUnlock()
+	conns := make([]*websocket.Conn, 0, len(s.clients))
+	for conn := range s.clients {
+		conns = append(conns, conn)
+	}
+	s.clientsLock.RUnlock()
 
-	if len(s.clients) == 0 {
+	if len(conns) == 0 {
 		return
 	}
 
@@ -209,8 +323,8 @@ func (s *Stream) broadcast(entry LogEntry) {
 	}
 
 	// Send to all clients
-	for conn := range s.clients {
-		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+	for _, conn := range conns {
+		if err := s.send(conn, data); err != nil {
 			s.logger.Error("Failed to send log to client", zap.Error(err))
 			// Remove disconnected client
 			go s.RemoveClient(conn)
@@ -218,6 +332,39 @@ func (s *Stream) broadcast(entry LogEntry) {
 	}
 }
 
+// send writes a message to a client, serializing concurrent writers
+func (s *Stream) send(conn *websocket.Conn, data []byte) error {
+	s.clientsLock.RLock()
+	state, exists := s.clients[conn]
+	s.clientsLock.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("client not connected")
+	}
+
+	state.writeLock.Lock()
+	defer state.writeLock.Unlock()
+
+	// The client may have been removed while this write waited
+	if state.removed {
+		return fmt.Errorf("client not connected")
+	}
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// SendError reports a failure to a single client
+func (s *Stream) SendError(conn *websocket.Conn, message string) error {
+	data, err := json.Marshal(LogEntry{
+		Type:      "error",
+		Line:      message,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	return s.send(conn, data)
+}
+
 // sendBufferToClient sends buffered logs to a new client
 func (s *Stream) sendBufferToClient(conn *websocket.Conn) {
 	s.bufferLock.RLock()
@@ -230,7 +377,7 @@ func (s *Stream) sendBufferToClient(conn *websocket.Conn) {
 			continue
 		}
 
-		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		if err := s.send(conn, data); err != nil {
 			s.logger.Error("Failed to send buffered log to client", zap.Error(err))
 			return
 		}
@@ -248,19 +395,92 @@ func (s *Stream) HandleCommand(conn *websocket.Conn, message []byte) error {
 		return fmt.Errorf("invalid command format: %w", err)
 	}
 
-	if cmd.Type != "command" {
+	s.clientsLock.RLock()
+	state, exists := s.clients[conn]
+	s.clientsLock.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("client not connected")
+	}
+
+	switch cmd.Type {
+	case "command":
+		if !state.permissions.SendCommands {
+			return ErrCommandNotPermitted
+		}
+
+		if state.router != nil {
+			return s.routeCommand(conn, state.router, cmd.Command)
+		}
+
+		s.runLock.Lock()
+		stdin := s.stdin
+		s.runLock.Unlock()
+
+		if stdin == nil {
+			return ErrServerNotRunning
+		}
+
+		s.logger.Info("Sending console command",
+			zap.String("serverID", s.serverID),
+			zap.String("command", cmd.Command))
+
+		return stdin.Write(cmd.Command)
+	case "exec":
+		if !state.permissions.Exec {
+			return ErrExecNotPermitted
+		}
+
+		return s.execCommand(conn, cmd.Command)
+	default:
 		return fmt.Errorf("unknown message type: %s", cmd.Type)
 	}
+}
 
-	s.logger.Info("Executing command",
+// routeCommand sends a command through a client's router and sends the response back to it
+func (s *Stream) routeCommand(conn *websocket.Conn, router CommandRouter, command string) error {
+	s.logger.Info("Sending routed console command",
 		zap.String("serverID", s.serverID),
-		zap.String("command", cmd.Command))
+		zap.String("command", command))
+
+	response, err := router(command)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(response, "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+
+		data, err := json.Marshal(LogEntry{
+			Type:      "rcon",
+			Line:      strings.TrimSuffix(line, "\r"),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			continue
+		}
+
+		if err := s.send(conn, data); err != nil {
+			return fmt.Errorf("failed to send command response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// execCommand runs a shell command in the container and sends its output to the client
+func (s *Stream) execCommand(conn *websocket.Conn, command string) error {
+	s.logger.Info("Executing shell command",
+		zap.String("serverID", s.serverID),
+		zap.String("command", command))
 
 	// Create exec instance
 	execConfig := types.ExecConfig{
 		AttachStdout: true,
 		AttachStderr: true,
-		Cmd:          []string{"/bin/sh", "-c", cmd.Command},
+		Cmd:          []string{"/bin/sh", "-c", command},
 	}
 
 	execID, err := s.dockerClient.ContainerExecCreate(s.ctx, s.containerID, execConfig)
@@ -296,7 +516,7 @@ func (s *Stream) HandleCommand(conn *websocket.Conn, message []byte) error {
 			continue
 		}
 
-		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		if err := s.send(conn, data); err != nil {
 			return fmt.Errorf("failed to send command output: %w", err)
 		}
 	}
@@ -307,9 +527,10 @@ func (s *Stream) HandleCommand(conn *websocket.Conn, message []byte) error {
 // Manager manages multiple console streams
 type Manager struct {
 	streams     map[string]*Stream // serverID -> Stream
+	stdins      map[string]*Stdin  // serverID -> Stdin
 	streamsLock sync.RWMutex
 
-	dockerClient *client.Client
+	dockerClient dockerAPI
 	logger       *zap.Logger
 }
 
@@ -317,6 +538,7 @@ type Manager struct {
 func NewManager(dockerClient *client.Client, logger *zap.Logger) *Manager {
 	return &Manager{
 		streams:      make(map[string]*Stream),
+		stdins:       make(map[string]*Stdin),
 		dockerClient: dockerClient,
 		logger:       logger,
 	}
@@ -327,13 +549,20 @@ func (m *Manager) GetOrCreateStream(serverID, containerID string) (*Stream, erro
 	m.streamsLock.Lock()
 	defer m.streamsLock.Unlock()
 
-	// Check if stream already exists
+	// Check if stream already exists, reattaching if its log follow has ended
 	if stream, exists := m.streams[serverID]; exists {
+		if !stream.IsRunning() {
+			stream.reattach(containerID, m.stdinLocked(serverID, containerID))
+			if err := stream.Start(); err != nil {
+				return nil, err
+			}
+		}
 		return stream, nil
 	}
 
 	// Create new stream
-	stream := NewStream(serverID, containerID, m.dockerClient, m.logger)
+	stream := newStream(serverID, containerID, m.dockerClient, m.logger)
+	stream.stdin = m.stdinLocked(serverID, containerID)
 	if err := stream.Start(); err != nil {
 		return nil, err
 	}
@@ -344,6 +573,20 @@ func (m *Manager) GetOrCreateStream(serverID, containerID string) (*Stream, erro
 	return stream, nil
 }
 
+// Publish sends a log entry to a server's stream, creating an idle stream so
+// the entry is replayed to clients that connect later
+func (m *Manager) Publish(serverID string, entry LogEntry) {
+	m.streamsLock.Lock()
+	stream, exists := m.streams[serverID]
+	if !exists {
+		stream = newStream(serverID, "", m.dockerClient, m.logger)
+		m.streams[serverID] = stream
+	}
+	m.streamsLock.Unlock()
+
+	stream.Publish(entry)
+}
+
 // RemoveStream removes and stops a stream
 func (m *Manager) RemoveStream(serverID string) {
 	m.streamsLock.Lock()
@@ -354,6 +597,35 @@ func (m *Manager) RemoveStream(serverID string) {
 		delete(m.streams, serverID)
 		m.logger.Info("Removed console stream", zap.String("serverID", serverID))
 	}
+
+	if stdin, exists := m.stdins[serverID]; exists {
+		stdin.Close()
+		delete(m.stdins, serverID)
+	}
+}
+
+// SendCommand writes a command to the server process's stdin
+func (m *Manager) SendCommand(serverID, containerID, command string) error {
+	m.streamsLock.Lock()
+	stdin := m.stdinLocked(serverID, containerID)
+	m.streamsLock.Unlock()
+
+	return stdin.Write(command)
+}
+
+// stdinLocked returns the stdin writer for a server, replacing it if the
+// server's container has been recreated; the caller must hold streamsLock
+func (m *Manager) stdinLocked(serverID, containerID string) *Stdin {
+	if stdin, exists := m.stdins[serverID]; exists {
+		if stdin.containerID == containerID {
+			return stdin
+		}
+		stdin.Close()
+	}
+
+	stdin := newStdin(serverID, containerID, m.dockerClient, m.logger)
+	m.stdins[serverID] = stdin
+	return stdin
 }
 
 // GetStream returns an existing stream
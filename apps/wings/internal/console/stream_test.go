@@ -0,0 +1,360 @@
+package console
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// fakeDocker is a Docker daemon for one container. Log streams stay open
+// until cancelled, lines written to an attached stdin arrive on input, and
+// execs print execOutput.
+type fakeDocker struct {
+	stopped    bool
+	execOutput string
+	input      chan string
+
+	mu       sync.Mutex
+	attaches int
+	execs    [][]string
+}
+
+func newFakeDocker() *fakeDocker {
+	return &fakeDocker{input: make(chan string, 16)}
+}
+
+func (d *fakeDocker) ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		<-ctx.Done()
+		writer.CloseWithError(ctx.Err())
+	}()
+	return reader, nil
+}
+
+func (d *fakeDocker) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{State: &types.ContainerState{Running: !d.stopped}},
+		Config:            &container.Config{OpenStdin: true},
+	}, nil
+}
+
+func (d *fakeDocker) ContainerAttach(ctx context.Context, containerID string, options container.AttachOptions) (types.HijackedResponse, error) {
+	d.mu.Lock()
+	d.attaches++
+	d.mu.Unlock()
+
+	conn, daemon := net.Pipe()
+	go func() {
+		scanner := bufio.NewScanner(daemon)
+		for scanner.Scan() {
+			d.input <- scanner.Text()
+		}
+	}()
+	return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(conn)}, nil
+}
+
+func (d *fakeDocker) ContainerExecCreate(ctx context.Context, containerID string, config types.ExecConfig) (types.IDResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.execs = append(d.execs, config.Cmd)
+	return types.IDResponse{ID: "exec"}, nil
+}
+
+func (d *fakeDocker) ContainerExecAttach(ctx context.Context, execID string, config types.ExecStartCheck) (types.HijackedResponse, error) {
+	conn, _ := net.Pipe()
+	return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(strings.NewReader(d.execOutput))}, nil
+}
+
+func (d *fakeDocker) ContainerExecStart(ctx context.Context, execID string, config types.ExecStartCheck) error {
+	return nil
+}
+
+// testConn is both ends of a WebSocket connection: the server's, which the
+// stream writes to, and the browser's
+type testConn struct {
+	server *websocket.Conn
+	client *fastws.Conn
+}
+
+func dialTestConn(t *testing.T) testConn {
+	t.Helper()
+
+	conns := make(chan *websocket.Conn)
+	done := make(chan struct{})
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws", websocket.New(func(conn *websocket.Conn) {
+		conns <- conn
+		<-done
+	}))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(listener)
+	t.Cleanup(func() {
+		close(done)
+		app.Shutdown()
+	})
+
+	client, _, err := fastws.DefaultDialer.Dial("ws://"+listener.Addr().String()+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return testConn{server: <-conns, client: client}
+}
+
+// subscribe connects a client to a stream until the test ends
+func subscribe(t *testing.T, stream *Stream, permissions ClientPermissions) testConn {
+	t.Helper()
+
+	conn := dialTestConn(t)
+	stream.AddClient(conn.server, permissions)
+	t.Cleanup(func() { stream.RemoveClient(conn.server) })
+	return conn
+}
+
+// read returns the next log entry the browser receives
+func (c testConn) read(t *testing.T) LogEntry {
+	t.Helper()
+
+	c.client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := c.client.ReadMessage()
+	if err != nil {
+		t.Fatalf("reading from console: %v", err)
+	}
+
+	var entry LogEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("console sent %q: %v", data, err)
+	}
+	return entry
+}
+
+func newTestStream(docker *fakeDocker) *Stream {
+	stream := newStream("srv", "ctr", docker, zap.NewNop())
+	stream.stdin = newStdin("srv", "ctr", docker, zap.NewNop())
+	return stream
+}
+
+func TestStreamHandleCommand(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		permissions ClientPermissions
+		message     string
+		wantErr     error
+		wantInput   string
+		wantExec    []string
+	}{
+		{
+			name:        "command",
+			permissions: ClientPermissions{SendCommands: true},
+			message:     `{"type":"command","command":"say hello"}`,
+			wantInput:   "say hello",
+		},
+		{
+			name:    "command from a read-only client",
+			message: `{"type":"command","command":"stop"}`,
+			wantErr: ErrCommandNotPermitted,
+		},
+		{
+			name:        "exec",
+			permissions: ClientPermissions{Exec: true},
+			message:     `{"type":"exec","command":"ls -la; df -h"}`,
+			wantExec:    []string{"/bin/sh", "-c", "ls -la; df -h"},
+		},
+		{
+			// Console access alone never reaches a shell
+			name:        "exec without exec access",
+			permissions: ClientPermissions{SendCommands: true},
+			message:     `{"type":"exec","command":"rm -rf /"}`,
+			wantErr:     ErrExecNotPermitted,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			docker := newFakeDocker()
+			docker.execOutput = "total 0\nserver.jar\n"
+			stream := newTestStream(docker)
+			conn := subscribe(t, stream, tt.permissions)
+
+			err := stream.HandleCommand(conn.server, []byte(tt.message))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("HandleCommand error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantInput != "" {
+				if got := <-docker.input; got != tt.wantInput {
+					t.Fatalf("stdin got %q, want %q", got, tt.wantInput)
+				}
+			}
+
+			if tt.wantExec == nil {
+				if len(docker.execs) != 0 {
+					t.Fatalf("exec created: %q", docker.execs)
+				}
+				return
+			}
+			if len(docker.execs) != 1 || !slices.Equal(docker.execs[0], tt.wantExec) {
+				t.Fatalf("execs = %q, want %q", docker.execs, tt.wantExec)
+			}
+			for _, want := range []string{"total 0", "server.jar"} {
+				if entry := conn.read(t); entry.Type != "stdout" || entry.Line != want {
+					t.Fatalf("exec output = %+v, want stdout %q", entry, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamHandleCommandInvalid(t *testing.T) {
+	stream := newTestStream(newFakeDocker())
+	conn := dialTestConn(t)
+
+	// Messages from a client that is not subscribed are refused
+	if err := stream.HandleCommand(conn.server, []byte(`{"type":"command","command":"stop"}`)); err == nil {
+		t.Fatalf("HandleCommand from an unknown client succeeded")
+	}
+
+	stream.AddClient(conn.server, ClientPermissions{SendCommands: true, Exec: true})
+	defer stream.RemoveClient(conn.server)
+	for _, message := range []string{`not json`, `{"type":"kill"}`} {
+		if err := stream.HandleCommand(conn.server, []byte(message)); err == nil {
+			t.Fatalf("HandleCommand(%s) succeeded", message)
+		}
+	}
+
+	// A stream that never had a container has no stdin to write to
+	idle := newStream("srv", "", newFakeDocker(), zap.NewNop())
+	idle.AddClient(conn.server, ClientPermissions{SendCommands: true})
+	defer idle.RemoveClient(conn.server)
+	if err := idle.HandleCommand(conn.server, []byte(`{"type":"command","command":"stop"}`)); !errors.Is(err, ErrServerNotRunning) {
+		t.Fatalf("HandleCommand without stdin error = %v, want %v", err, ErrServerNotRunning)
+	}
+}
+
+func TestStreamRouteCommands(t *testing.T) {
+	docker := newFakeDocker()
+	stream := newTestStream(docker)
+	routed := subscribe(t, stream, ClientPermissions{SendCommands: true})
+	other := subscribe(t, stream, ClientPermissions{SendCommands: true})
+
+	var commands []string
+	stream.RouteCommands(routed.server, func(command string) (string, error) {
+		commands = append(commands, command)
+		return "players: 2\r\n\nmap: de_dust2\n", nil
+	})
+
+	if err := stream.HandleCommand(routed.server, []byte(`{"type":"command","command":"status"}`)); err != nil {
+		t.Fatalf("HandleCommand: %v", err)
+	}
+	if !slices.Equal(commands, []string{"status"}) {
+		t.Fatalf("router got %q, want [status]", commands)
+	}
+	for _, want := range []string{"players: 2", "map: de_dust2"} {
+		if entry := routed.read(t); entry.Type != "rcon" || entry.Line != want {
+			t.Fatalf("response = %+v, want rcon %q", entry, want)
+		}
+	}
+
+	// The response went to the sender only; the other client's next message
+	// is the next thing published
+	stream.Publish(LogEntry{Type: "stdout", Line: "after"})
+	if entry := other.read(t); entry.Line != "after" {
+		t.Fatalf("other client got %+v, want the published entry", entry)
+	}
+
+	// Clients without a router still write to stdin
+	if err := stream.HandleCommand(other.server, []byte(`{"type":"command","command":"status"}`)); err != nil {
+		t.Fatalf("HandleCommand: %v", err)
+	}
+	if got := <-docker.input; got != "status" {
+		t.Fatalf("stdin got %q, want status", got)
+	}
+
+	// Router failures are returned to the handler
+	stream.RouteCommands(routed.server, func(string) (string, error) {
+		return "", errors.New("rcon unavailable")
+	})
+	if err := stream.HandleCommand(routed.server, []byte(`{"type":"command","command":"status"}`)); err == nil {
+		t.Fatalf("HandleCommand with a failing router succeeded")
+	}
+}
+
+func TestManagerPublish(t *testing.T) {
+	m := NewManager(nil, zap.NewNop())
+	m.dockerClient = newFakeDocker()
+
+	// Entries published before anyone connects are kept for replay
+	m.Publish("srv", LogEntry{Type: "install", Line: "Installing"})
+	stream, exists := m.GetStream("srv")
+	if !exists {
+		t.Fatalf("Publish did not create a stream")
+	}
+	if stream.IsRunning() {
+		t.Fatalf("stream created by Publish follows container logs")
+	}
+
+	conn := subscribe(t, stream, ClientPermissions{})
+	entry := conn.read(t)
+	if entry.Type != "install" || entry.Line != "Installing" || entry.Timestamp == "" {
+		t.Fatalf("replayed entry = %+v", entry)
+	}
+
+	// Later entries go straight to connected clients
+	m.Publish("srv", LogEntry{Type: "archive", Line: "Compressing"})
+	if entry := conn.read(t); entry.Type != "archive" || entry.Line != "Compressing" {
+		t.Fatalf("published entry = %+v", entry)
+	}
+
+	// The idle stream starts following logs once the server has a container
+	if got, err := m.GetOrCreateStream("srv", "ctr"); err != nil || got != stream || !stream.IsRunning() {
+		t.Fatalf("GetOrCreateStream = %p, %v; want the published stream, running", got, err)
+	}
+	m.RemoveStream("srv")
+}
+
+func TestStreamSerializesWrites(t *testing.T) {
+	stream := newTestStream(newFakeDocker())
+	conn := subscribe(t, stream, ClientPermissions{})
+
+	// Broadcasts and errors for one client race to its connection, which
+	// only takes one writer at a time
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			stream.Publish(LogEntry{Type: "stdout", Line: "line"})
+		}()
+		go func() {
+			defer wg.Done()
+			stream.SendError(conn.server, "failed")
+		}()
+	}
+
+	for i := 0; i < 2*writers; i++ {
+		conn.read(t)
+	}
+	wg.Wait()
+}
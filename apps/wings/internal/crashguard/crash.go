@@ -0,0 +1,59 @@
+package crashguard
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// DesiredState is what a server's container is meant to be doing
+type DesiredState string
+
+const (
+	DesiredRunning    DesiredState = "running"
+	DesiredStopped    DesiredState = "stopped"
+	DesiredRestarting DesiredState = "restarting" // Stopping on the way to running again
+)
+
+// CrashReason says why a container died
+type CrashReason string
+
+const (
+	CrashReasonOOM    CrashReason = "oom"    // Killed by the kernel for exceeding its memory limit
+	CrashReasonSignal CrashReason = "signal" // Killed by a signal, such as SIGKILL or SIGSEGV
+	CrashReasonExit   CrashReason = "exit"   // Exited by itself with a non-zero code
+)
+
+// crash describes one unexpected death of a server's container
+type crash struct {
+	exitCode  int
+	reason    CrashReason
+	signal    int // Set for CrashReasonSignal
+	oomKilled bool
+}
+
+// classifyCrash works out why a container died from its exit code and
+// whether the kernel OOM-killed it. Shells report death by signal N as
+// exit code 128+N.
+func classifyCrash(exitCode int, oomKilled bool) crash {
+	c := crash{exitCode: exitCode, reason: CrashReasonExit, oomKilled: oomKilled}
+
+	switch {
+	case oomKilled:
+		c.reason = CrashReasonOOM
+	case exitCode > 128 && exitCode < 128+65:
+		c.reason = CrashReasonSignal
+		c.signal = exitCode - 128
+	}
+	return c
+}
+
+// String describes the crash for logs and failure reasons
+func (c crash) String() string {
+	switch c.reason {
+	case CrashReasonOOM:
+		return fmt.Sprintf("out of memory (exit code %d)", c.exitCode)
+	case CrashReasonSignal:
+		return fmt.Sprintf("killed by signal %d, %s (exit code %d)", c.signal, syscall.Signal(c.signal), c.exitCode)
+	}
+	return fmt.Sprintf("exited with code %d", c.exitCode)
+}
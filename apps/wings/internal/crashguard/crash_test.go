@@ -0,0 +1,232 @@
+package crashguard
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mambapanel/wings/internal/docker"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"go.uber.org/zap"
+)
+
+func TestClassifyCrash(t *testing.T) {
+	tests := []struct {
+		exitCode  int
+		oomKilled bool
+		reason    CrashReason
+		signal    int
+	}{
+		{exitCode: 1, reason: CrashReasonExit},
+		{exitCode: 139, reason: CrashReasonSignal, signal: 11},
+		{exitCode: 137, reason: CrashReasonSignal, signal: 9},
+		{exitCode: 137, oomKilled: true, reason: CrashReasonOOM},
+		{exitCode: 255, reason: CrashReasonExit},
+	}
+
+	for _, tt := range tests {
+		c := classifyCrash(tt.exitCode, tt.oomKilled)
+		if c.reason != tt.reason || c.signal != tt.signal {
+			t.Fatalf("classifyCrash(%d, %v) = %s signal %d, want %s signal %d",
+				tt.exitCode, tt.oomKilled, c.reason, c.signal, tt.reason, tt.signal)
+		}
+	}
+}
+
+func TestGuardDesiredState(t *testing.T) {
+	guard := NewGuard(nil, nil, "node", t.TempDir(), zap.NewNop())
+
+	if state := guard.DesiredState("srv"); state != DesiredRunning {
+		t.Fatalf("DesiredState of untouched server = %s, want %s", state, DesiredRunning)
+	}
+
+	guard.SetDesiredState("srv", DesiredRestarting)
+	if state := guard.DesiredState("srv"); state != DesiredRestarting {
+		t.Fatalf("DesiredState = %s, want %s", state, DesiredRestarting)
+	}
+
+	// The container coming back up means it is meant to run again
+	guard.handleContainerStart("0123456789abcdef", "srv")
+	if state := guard.DesiredState("srv"); state != DesiredRunning {
+		t.Fatalf("DesiredState after start = %s, want %s", state, DesiredRunning)
+	}
+}
+
+func TestGuardForgetsRemovedServers(t *testing.T) {
+	guard := NewGuard(nil, nil, "node", t.TempDir(), zap.NewNop())
+
+	guard.RemoveServer("srv")
+	if !guard.isRemoved("srv") {
+		t.Fatalf("server is not marked removed")
+	}
+
+	// Once the container is destroyed nothing more is heard about the server
+	guard.handleEvent(events.Message{
+		Action: "destroy",
+		Actor: events.Actor{
+			ID:         "0123456789abcdef",
+			Attributes: map[string]string{"io.mamba.server_id": "srv"},
+		},
+	})
+	if guard.isRemoved("srv") || len(guard.removed) != 0 {
+		t.Fatalf("removed server was kept after its container was destroyed")
+	}
+}
+
+// fakeDocker stands in for the Docker client, with the container dead and
+// every restart recorded. When logs is set, reading logs waits for it to close.
+type fakeDocker struct {
+	restarts chan string
+	logs     chan struct{}
+}
+
+func (d *fakeDocker) Events(context.Context, types.EventsOptions) (<-chan events.Message, <-chan error) {
+	return nil, nil
+}
+
+func (d *fakeDocker) ContainerInspect(context.Context, string) (types.ContainerJSON, error) {
+	return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{State: &types.ContainerState{}}}, nil
+}
+
+func (d *fakeDocker) ContainerRestart(_ context.Context, containerID string, _ container.StopOptions) error {
+	d.restarts <- containerID
+	return nil
+}
+
+func (d *fakeDocker) ContainerLogs(ctx context.Context, _ string, _ container.LogsOptions) (io.ReadCloser, error) {
+	if d.logs != nil {
+		select {
+		case <-d.logs:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+// fakePoster accepts every event
+type fakePoster struct{}
+
+func (fakePoster) Post(string, []byte) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func TestGuardRestartsOnlyServersMeantToRun(t *testing.T) {
+	const containerID = "0123456789abcdef"
+
+	tests := []struct {
+		desired DesiredState
+		restart bool
+	}{
+		{desired: DesiredRunning, restart: true},
+		{desired: DesiredStopped, restart: false},
+		{desired: DesiredRestarting, restart: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.desired), func(t *testing.T) {
+			fake := &fakeDocker{restarts: make(chan string, 1)}
+			guard := NewGuard(nil, nil, "node", t.TempDir(), zap.NewNop())
+			guard.dockerClient = fake
+			guard.apiClient = fakePoster{}
+
+			noBackoff := 0
+			if err := guard.SetPolicy("srv", &docker.CrashPolicyConfig{BackoffSeconds: &noBackoff}); err != nil {
+				t.Fatal(err)
+			}
+			guard.SetDesiredState("srv", tt.desired)
+
+			guard.handleContainerDie(containerID, "srv", events.Message{
+				Action: "die",
+				Actor: events.Actor{
+					ID:         containerID,
+					Attributes: map[string]string{"io.mamba.server_id": "srv", "exitCode": "1"},
+				},
+			})
+
+			// Only a restart is scheduled, so a skipped one has already been decided
+			if !tt.restart {
+				select {
+				case <-fake.restarts:
+					t.Fatalf("server meant to be %s was restarted", tt.desired)
+				default:
+				}
+				if state := guard.GetContainerState(containerID); state != nil {
+					t.Fatalf("crash counted for server meant to be %s: %+v", tt.desired, state)
+				}
+				return
+			}
+
+			select {
+			case restarted := <-fake.restarts:
+				if restarted != containerID {
+					t.Fatalf("restarted %s, want %s", restarted, containerID)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("crashed server was not restarted")
+			}
+		})
+	}
+}
+
+func TestGuardRecordsCrashesOutsideEventLoop(t *testing.T) {
+	const containerID = "0123456789abcdef"
+
+	fake := &fakeDocker{restarts: make(chan string, 1), logs: make(chan struct{})}
+	guard := NewGuard(nil, nil, "node", t.TempDir(), zap.NewNop())
+	guard.dockerClient = fake
+	guard.apiClient = fakePoster{}
+	defer guard.Stop()
+
+	noBackoff := 0
+	if err := guard.SetPolicy("srv", &docker.CrashPolicyConfig{BackoffSeconds: &noBackoff}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Docker being slow to hand over the crashed server's logs holds up no
+	// other event
+	handled := make(chan struct{})
+	go func() {
+		guard.handleEvent(events.Message{
+			Action: "die",
+			Actor: events.Actor{
+				ID:         containerID,
+				Attributes: map[string]string{"io.mamba.server_id": "srv", "exitCode": "1"},
+			},
+		})
+		guard.handleEvent(events.Message{
+			Action: "start",
+			Actor: events.Actor{
+				ID:         "fedcba9876543210",
+				Attributes: map[string]string{"io.mamba.server_id": "other"},
+			},
+		})
+		close(handled)
+	}()
+	select {
+	case <-handled:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("event loop blocked on recording a crash")
+	}
+
+	// The restart follows once the report is written
+	select {
+	case <-fake.restarts:
+		t.Fatalf("restarted before the crash was recorded")
+	default:
+	}
+	close(fake.logs)
+	select {
+	case <-fake.restarts:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("crashed server was not restarted")
+	}
+	if reports, err := guard.CrashReports("srv"); err != nil || len(reports) != 1 {
+		t.Fatalf("CrashReports = %d reports, %v; want 1", len(reports), err)
+	}
+}
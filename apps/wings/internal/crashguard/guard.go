@@ -4,6 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
@@ -12,28 +17,11 @@ import (
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
+	"github.com/mambapanel/wings/internal/docker"
 	"github.com/mambapanel/wings/internal/mtls"
 	"go.uber.org/zap"
 )
 
-// RestartPolicy defines how the guard should handle crashes
-type RestartPolicy struct {
-	MaxAttempts      int
-	BackoffBase      time.Duration
-	BackoffMultiplier float64
-	BackoffMax       time.Duration
-}
-
-// DefaultRestartPolicy returns the default restart policy
-func DefaultRestartPolicy() RestartPolicy {
-	return RestartPolicy{
-		MaxAttempts:      5,
-		BackoffBase:      2 * time.Second,
-		BackoffMultiplier: 2.0,
-		BackoffMax:       5 * time.Minute,
-	}
-}
-
 // containerState tracks restart attempts for a container
 type containerState struct {
 	serverID       string
@@ -44,25 +32,48 @@ type containerState struct {
 	consecutiveFails int
 }
 
+// dockerAPI is the part of the Docker client the guard uses
+type dockerAPI interface {
+	Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error)
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
+	ContainerRestart(ctx context.Context, containerID string, options container.StopOptions) error
+	ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error)
+}
+
+// eventPoster sends crash and failure events to the panel API
+type eventPoster interface {
+	Post(path string, body []byte) (*http.Response, error)
+}
+
 // Guard monitors containers and restarts them on crash
 type Guard struct {
-	dockerClient *client.Client
-	apiClient    *mtls.APIClient
+	dockerClient dockerAPI
+	apiClient    eventPoster
 	logger       *zap.Logger
 	nodeID       string
-	policy       RestartPolicy
+	policy       RestartPolicy // For servers without a policy of their own
+	stateDir     string
 
 	// State tracking
-	states     map[string]*containerState // containerID -> state
+	states     map[string]*containerState           // containerID -> state
+	removed    map[string]bool                      // serverID -> being deleted
+	overrides  map[string]*docker.CrashPolicyConfig // serverID -> policy set through the API
+	desired    map[string]DesiredState              // serverID -> state set by the last power action
 	statesLock sync.RWMutex
 
+	// dieHooks are called with the server ID whenever a server's container stops
+	dieHooks []func(serverID string)
+
+	// samples returns a server's last resource sample for crash reports, if set
+	samples func(serverID string) (ResourceSample, bool)
+
 	// Control
 	ctx    context.Context
 	cancel context.CancelFunc
 }
 
 // NewGuard creates a new crash guard
-func NewGuard(dockerClient *client.Client, apiClient *mtls.APIClient, nodeID string, logger *zap.Logger) *Guard {
+func NewGuard(dockerClient *client.Client, apiClient *mtls.APIClient, nodeID, stateDir string, logger *zap.Logger) *Guard {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &Guard{
@@ -71,21 +82,35 @@ func NewGuard(dockerClient *client.Client, apiClient *mtls.APIClient, nodeID str
 		logger:       logger,
 		nodeID:       nodeID,
 		policy:       DefaultRestartPolicy(),
+		stateDir:     filepath.Join(stateDir, "crashguard"),
 		states:       make(map[string]*containerState),
+		removed:      make(map[string]bool),
+		overrides:    make(map[string]*docker.CrashPolicyConfig),
+		desired:      make(map[string]DesiredState),
 		ctx:          ctx,
 		cancel:       cancel,
 	}
 }
 
-// Start begins monitoring container events
+// Start loads saved policy overrides and begins monitoring container events
 func (g *Guard) Start() {
+	g.loadPolicies()
+
 	g.logger.Info("Starting crash guard",
 		zap.Int("maxAttempts", g.policy.MaxAttempts),
-		zap.Duration("baseBackoff", g.policy.BackoffBase))
+		zap.Duration("baseBackoff", g.policy.BackoffBase),
+		zap.Int("policyOverrides", len(g.overrides)))
 
 	go g.monitorEvents()
 }
 
+// OnContainerDie registers a function to call whenever a server's container
+// stops, for subsystems holding state tied to the running container. Hooks
+// must be registered before Start.
+func (g *Guard) OnContainerDie(hook func(serverID string)) {
+	g.dieHooks = append(g.dieHooks, hook)
+}
+
 // Stop stops the crash guard
 func (g *Guard) Stop() {
 	g.logger.Info("Stopping crash guard")
@@ -100,6 +125,7 @@ func (g *Guard) monitorEvents() {
 	eventFilter.Add("event", "die")
 	eventFilter.Add("event", "stop")
 	eventFilter.Add("event", "start")
+	eventFilter.Add("event", "destroy")
 
 	// Listen for events
 	eventChan, errChan := g.dockerClient.Events(g.ctx, types.EventsOptions{
@@ -135,9 +161,23 @@ func (g *Guard) handleEvent(event events.Message) {
 
 	switch event.Action {
 	case "die", "stop":
+		for _, hook := range g.dieHooks {
+			hook(serverID)
+		}
+
+		// A stopped container also dies, and only the die event carries its exit code
+		if event.Action != "die" {
+			return
+		}
+		if g.isRemoved(serverID) {
+			g.logger.Debug("Ignoring stop of removed server", zap.String("serverID", serverID))
+			return
+		}
 		g.handleContainerDie(containerID, serverID, event)
 	case "start":
 		g.handleContainerStart(containerID, serverID)
+	case "destroy":
+		g.handleContainerDestroy(serverID)
 	}
 }
 
@@ -151,12 +191,53 @@ func (g *Guard) handleContainerDie(containerID, serverID string, event events.Me
 		zap.String("containerID", containerID[:12]),
 		zap.String("exitCode", exitCode))
 
-	// Exit code 0 is normal shutdown, don't restart
-	if exitCode == "0" {
-		g.logger.Debug("Container exited normally, not restarting", zap.String("serverID", serverID))
+	// Stopped or being restarted by a power action, so the exit code says nothing
+	if desired := g.DesiredState(serverID); desired != DesiredRunning {
+		g.logger.Debug("Container stopped intentionally, not restarting",
+			zap.String("serverID", serverID),
+			zap.String("desiredState", string(desired)))
+		return
+	}
+
+	policy, source := g.Policy(serverID, event.Actor.Attributes)
+	if !policy.Enabled {
+		g.logger.Debug("Crash restarts disabled for server", zap.String("serverID", serverID))
 		return
 	}
 
+	// Looking into the crash waits on Docker, which must not hold up the
+	// events of every other server
+	go g.handleCrash(containerID, serverID, exitCode, policy, source)
+}
+
+// handleCrash inspects a container that died while meant to be running,
+// records the crash and schedules a restart. Its Docker calls are bounded by
+// crashRecordTimeout.
+func (g *Guard) handleCrash(containerID, serverID, exitCode string, policy RestartPolicy, source PolicySource) {
+	ctx, cancel := context.WithTimeout(g.ctx, crashRecordTimeout)
+	defer cancel()
+
+	// A missing or unreadable exit code is treated as a crash
+	code, err := strconv.Atoi(exitCode)
+	if err != nil {
+		code = -1
+	}
+
+	// An OOM kill is a crash whatever code the game's policy expects
+	info := g.inspect(ctx, containerID)
+	crash := classifyCrash(code, info != nil && info.State != nil && info.State.OOMKilled)
+	if !crash.oomKilled && !policy.IsCrash(code) {
+		g.logger.Debug("Container exited normally, not restarting",
+			zap.String("serverID", serverID),
+			zap.String("exitCode", exitCode))
+		return
+	}
+
+	g.logger.Warn("Container crashed",
+		zap.String("serverID", serverID),
+		zap.String("reason", string(crash.reason)),
+		zap.Stringer("crash", crash))
+
 	// Check if container should be restarted
 	g.statesLock.Lock()
 	state, exists := g.states[containerID]
@@ -167,53 +248,79 @@ func (g *Guard) handleContainerDie(containerID, serverID string, event events.Me
 		g.states[containerID] = state
 	}
 
+	// A server that stayed up long enough starts over with a full set of attempts
+	if policy.ResetAfter > 0 && !state.lastCrash.IsZero() && time.Since(state.lastCrash) > policy.ResetAfter {
+		state.attempts = 0
+		state.failed = false
+	}
+
 	state.lastCrash = time.Now()
 	state.attempts++
 	state.consecutiveFails++
+	attempt := state.attempts
 
 	// Check if max attempts exceeded
-	if state.attempts >= g.policy.MaxAttempts {
+	exceeded := state.attempts > policy.MaxAttempts
+	if exceeded {
+		state.failed = true
+	}
+	g.statesLock.Unlock()
+
+	report := g.recordCrash(ctx, containerID, serverID, crash, attempt, info)
+
+	if exceeded {
 		g.logger.Error("Container exceeded max restart attempts",
 			zap.String("serverID", serverID),
-			zap.Int("attempts", state.attempts))
-
-		state.failed = true
-		g.statesLock.Unlock()
+			zap.Int("attempts", attempt))
 
 		// Notify API that server has failed
-		g.notifyServerFailed(serverID, fmt.Sprintf("Exceeded max restart attempts (%d)", g.policy.MaxAttempts))
+		g.notifyServerFailed(serverID, fmt.Sprintf("Exceeded max restart attempts (%d), last crash: %s", policy.MaxAttempts, crash), report)
 		return
 	}
 
 	// Calculate backoff delay
-	backoff := g.calculateBackoff(state.attempts)
+	backoff := policy.Backoff(attempt)
 
 	g.logger.Info("Scheduling container restart",
 		zap.String("serverID", serverID),
-		zap.Int("attempt", state.attempts),
-		zap.Duration("backoff", backoff))
-
-	g.statesLock.Unlock()
+		zap.Int("attempt", attempt),
+		zap.Duration("backoff", backoff),
+		zap.String("policy", string(source)),
+		zap.String("crashId", report.ID))
 
 	// Wait for backoff period, then restart
 	go func() {
 		time.Sleep(backoff)
 
+		// The user may have stopped or started the server in the meantime
+		if desired := g.DesiredState(serverID); desired != DesiredRunning || g.isRemoved(serverID) {
+			g.logger.Info("Skipping restart of server no longer meant to be restarted",
+				zap.String("serverID", serverID),
+				zap.String("desiredState", string(desired)))
+			return
+		}
+		if g.isRunning(containerID) {
+			g.logger.Info("Skipping restart of server already running", zap.String("serverID", serverID))
+			return
+		}
+
 		if err := g.restartContainer(containerID, serverID); err != nil {
 			g.logger.Error("Failed to restart container",
 				zap.String("serverID", serverID),
 				zap.Error(err))
 
-			g.notifyServerFailed(serverID, fmt.Sprintf("Restart failed: %v", err))
+			g.notifyServerFailed(serverID, fmt.Sprintf("Restart failed: %v", err), report)
 		} else {
 			g.logger.Info("Container restarted successfully",
 				zap.String("serverID", serverID),
-				zap.Int("attempt", state.attempts))
+				zap.Int("attempt", attempt))
 
+			g.statesLock.Lock()
 			state.lastRestart = time.Now()
+			g.statesLock.Unlock()
 
 			// Notify API of crash event
-			g.notifyCrashEvent(serverID, state.attempts, exitCode)
+			g.notifyCrashEvent(serverID, exitCode, report)
 		}
 	}()
 }
@@ -227,12 +334,27 @@ func (g *Guard) handleContainerStart(containerID, serverID string) {
 	g.statesLock.Lock()
 	defer g.statesLock.Unlock()
 
+	// A server recreated under the same ID is guarded again
+	delete(g.removed, serverID)
+
+	// However it was started, a running server is meant to keep running
+	delete(g.desired, serverID)
+
 	// Reset consecutive fails on successful start
 	if state, exists := g.states[containerID]; exists {
 		state.consecutiveFails = 0
 	}
 }
 
+// handleContainerDestroy forgets a deleted server once its container is
+// gone, since no more events can arrive for it
+func (g *Guard) handleContainerDestroy(serverID string) {
+	g.statesLock.Lock()
+	defer g.statesLock.Unlock()
+
+	delete(g.removed, serverID)
+}
+
 // restartContainer attempts to restart a container
 func (g *Guard) restartContainer(containerID, serverID string) error {
 	g.logger.Info("Restarting container",
@@ -248,29 +370,22 @@ func (g *Guard) restartContainer(containerID, serverID string) error {
 	return nil
 }
 
-// calculateBackoff calculates exponential backoff delay
-func (g *Guard) calculateBackoff(attempts int) time.Duration {
-	backoff := float64(g.policy.BackoffBase) * float64(g.policy.BackoffMultiplier) * float64(attempts-1)
-	duration := time.Duration(backoff)
-
-	if duration > g.policy.BackoffMax {
-		return g.policy.BackoffMax
+// notifyCrashEvent notifies the API of a crash event. The exit code, as
+// Docker reported it, and restart attempt keep their original keys; the
+// crash report's summary goes under "crash", as in failed events.
+func (g *Guard) notifyCrashEvent(serverID, exitCode string, report *CrashReport) {
+	metadata := map[string]interface{}{
+		"exitCode":       exitCode,
+		"restartAttempt": report.RestartAttempt,
+		"crash":          report.summary(),
 	}
 
-	return duration
-}
-
-// notifyCrashEvent notifies the API of a crash event
-func (g *Guard) notifyCrashEvent(serverID string, attempts int, exitCode string) {
 	payload := map[string]interface{}{
 		"nodeId":    g.nodeID,
 		"serverId":  serverID,
 		"eventType": "crash",
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
-		"metadata": map[string]interface{}{
-			"exitCode":       exitCode,
-			"restartAttempt": attempts,
-		},
+		"metadata":  metadata,
 	}
 
 	data, err := json.Marshal(payload)
@@ -295,16 +410,22 @@ func (g *Guard) notifyCrashEvent(serverID string, attempts int, exitCode string)
 	g.logger.Debug("Crash event sent to API", zap.String("serverID", serverID))
 }
 
-// notifyServerFailed notifies the API that a server has failed
-func (g *Guard) notifyServerFailed(serverID, reason string) {
+// notifyServerFailed notifies the API that a server has failed, summarizing
+// the report of the crash that led to it
+func (g *Guard) notifyServerFailed(serverID, reason string, report *CrashReport) {
+	metadata := map[string]interface{}{
+		"reason": reason,
+	}
+	if report != nil {
+		metadata["crash"] = report.summary()
+	}
+
 	payload := map[string]interface{}{
 		"nodeId":    g.nodeID,
 		"serverId":  serverID,
 		"eventType": "failed",
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
-		"metadata": map[string]interface{}{
-			"reason": reason,
-		},
+		"metadata":  metadata,
 	}
 
 	data, err := json.Marshal(payload)
@@ -345,3 +466,95 @@ func (g *Guard) ResetContainerState(containerID string) {
 	delete(g.states, containerID)
 	g.logger.Info("Container restart state reset", zap.String("containerID", containerID[:12]))
 }
+
+// ResetAttempts forgets a server's restart attempts, called when a power
+// action starts it. A server the guard gave up on is restarted on crash again.
+func (g *Guard) ResetAttempts(serverID string) {
+	g.statesLock.Lock()
+	defer g.statesLock.Unlock()
+
+	for containerID, state := range g.states {
+		if state.serverID == serverID {
+			delete(g.states, containerID)
+		}
+	}
+}
+
+// RemoveServer drops all restart state, crash reports and any policy override for a server
+// and stops guarding it until its container is destroyed or starts again
+func (g *Guard) RemoveServer(serverID string) {
+	g.statesLock.Lock()
+	defer g.statesLock.Unlock()
+
+	for containerID, state := range g.states {
+		if state.serverID == serverID {
+			delete(g.states, containerID)
+		}
+	}
+	g.removed[serverID] = true
+	delete(g.desired, serverID)
+
+	if err := os.RemoveAll(g.reportDir(serverID)); err != nil {
+		g.logger.Warn("Failed to remove crash reports", zap.String("serverID", serverID), zap.Error(err))
+	}
+
+	if _, exists := g.overrides[serverID]; exists {
+		delete(g.overrides, serverID)
+		if err := os.Remove(g.policyPath(serverID)); err != nil && !os.IsNotExist(err) {
+			g.logger.Warn("Failed to remove crash policy", zap.String("serverID", serverID), zap.Error(err))
+		}
+	}
+
+	g.logger.Info("Server removed from crash guard", zap.String("serverID", serverID))
+}
+
+// isRemoved reports whether a server is being deleted
+func (g *Guard) isRemoved(serverID string) bool {
+	g.statesLock.RLock()
+	defer g.statesLock.RUnlock()
+
+	return g.removed[serverID]
+}
+
+// SetDesiredState records what a power action is about to do to a server,
+// so the container stopping as a result is not mistaken for a crash. It must
+// be called before the action is sent to Docker.
+func (g *Guard) SetDesiredState(serverID string, state DesiredState) {
+	g.statesLock.Lock()
+	defer g.statesLock.Unlock()
+
+	if state == DesiredRunning {
+		delete(g.desired, serverID)
+	} else {
+		g.desired[serverID] = state
+	}
+	g.logger.Debug("Server desired state set", zap.String("serverID", serverID), zap.String("state", string(state)))
+}
+
+// DesiredState returns what a server's container is meant to be doing.
+// Servers no power action has touched since Wings started are meant to run.
+func (g *Guard) DesiredState(serverID string) DesiredState {
+	g.statesLock.RLock()
+	defer g.statesLock.RUnlock()
+
+	if state, exists := g.desired[serverID]; exists {
+		return state
+	}
+	return DesiredRunning
+}
+
+// inspect returns a dead container's details, or nil if they cannot be read
+func (g *Guard) inspect(ctx context.Context, containerID string) *types.ContainerJSON {
+	info, err := g.dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		g.logger.Warn("Failed to inspect crashed container", zap.String("containerID", containerID[:12]), zap.Error(err))
+		return nil
+	}
+	return &info
+}
+
+// isRunning reports whether a container is running
+func (g *Guard) isRunning(containerID string) bool {
+	info, err := g.dockerClient.ContainerInspect(g.ctx, containerID)
+	return err == nil && info.State != nil && info.State.Running
+}
@@ -0,0 +1,241 @@
+package crashguard
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mambapanel/wings/internal/docker"
+	"go.uber.org/zap"
+)
+
+// PolicySource says where a server's effective policy came from
+type PolicySource string
+
+const (
+	PolicySourceDefault  PolicySource = "default"  // No policy configured
+	PolicySourceServer   PolicySource = "server"   // The server's configuration
+	PolicySourceOverride PolicySource = "override" // Set through the API
+)
+
+// RestartPolicy defines how the guard should handle crashes
+type RestartPolicy struct {
+	Enabled           bool
+	MaxAttempts       int
+	BackoffBase       time.Duration
+	BackoffMultiplier float64
+	BackoffMax        time.Duration
+
+	// CrashExitCodes, when set, are the only exit codes counted as crashes.
+	// Otherwise every non-zero code not in CleanExitCodes is.
+	CrashExitCodes []int
+
+	// CleanExitCodes are non-zero exit codes the game uses for a normal shutdown
+	CleanExitCodes []int
+
+	// ResetAfter is how long a server must go without crashing before its
+	// restart attempts are forgotten. At 0 they are kept until a power
+	// action starts the server.
+	ResetAfter time.Duration
+}
+
+// DefaultRestartPolicy returns the default restart policy
+func DefaultRestartPolicy() RestartPolicy {
+	return RestartPolicy{
+		Enabled:           true,
+		MaxAttempts:       5,
+		BackoffBase:       2 * time.Second,
+		BackoffMultiplier: 2.0,
+		BackoffMax:        5 * time.Minute,
+		ResetAfter:        10 * time.Minute,
+	}
+}
+
+// IsCrash reports whether a container exiting with exitCode crashed
+func (p RestartPolicy) IsCrash(exitCode int) bool {
+	if exitCode == 0 || slices.Contains(p.CleanExitCodes, exitCode) {
+		return false
+	}
+	if len(p.CrashExitCodes) > 0 {
+		return slices.Contains(p.CrashExitCodes, exitCode)
+	}
+	return true
+}
+
+// Backoff returns the delay before the given restart attempt, growing by
+// the multiplier with each attempt up to BackoffMax
+func (p RestartPolicy) Backoff(attempt int) time.Duration {
+	backoff := float64(p.BackoffBase)
+	for i := 1; i < attempt && backoff < float64(p.BackoffMax); i++ {
+		backoff *= p.BackoffMultiplier
+	}
+	return min(time.Duration(backoff), p.BackoffMax)
+}
+
+// Config returns the policy in its configuration form
+func (p RestartPolicy) Config() docker.CrashPolicyConfig {
+	return docker.CrashPolicyConfig{
+		Enabled:           &p.Enabled,
+		MaxAttempts:       &p.MaxAttempts,
+		BackoffSeconds:    seconds(p.BackoffBase),
+		BackoffMultiplier: &p.BackoffMultiplier,
+		BackoffMaxSeconds: seconds(p.BackoffMax),
+		CrashExitCodes:    p.CrashExitCodes,
+		CleanExitCodes:    p.CleanExitCodes,
+		CooldownSeconds:   seconds(p.ResetAfter),
+	}
+}
+
+// policyFromConfig returns the restart policy a configuration describes
+func policyFromConfig(c *docker.CrashPolicyConfig) RestartPolicy {
+	policy := DefaultRestartPolicy()
+	if c == nil {
+		return policy
+	}
+
+	if c.Enabled != nil {
+		policy.Enabled = *c.Enabled
+	}
+	if c.MaxAttempts != nil {
+		policy.MaxAttempts = *c.MaxAttempts
+	}
+	if c.BackoffSeconds != nil {
+		policy.BackoffBase = time.Duration(*c.BackoffSeconds) * time.Second
+	}
+	if c.BackoffMultiplier != nil {
+		policy.BackoffMultiplier = *c.BackoffMultiplier
+	}
+	if c.BackoffMaxSeconds != nil {
+		policy.BackoffMax = time.Duration(*c.BackoffMaxSeconds) * time.Second
+	}
+	if c.CooldownSeconds != nil {
+		policy.ResetAfter = time.Duration(*c.CooldownSeconds) * time.Second
+	}
+	if c.CrashExitCodes != nil {
+		policy.CrashExitCodes = c.CrashExitCodes
+	}
+	if c.CleanExitCodes != nil {
+		policy.CleanExitCodes = c.CleanExitCodes
+	}
+	return policy
+}
+
+// Policy returns a server's effective restart policy and where it came from.
+// labels are the server container's, which carry its configured policy.
+func (g *Guard) Policy(serverID string, labels map[string]string) (RestartPolicy, PolicySource) {
+	g.statesLock.RLock()
+	override, exists := g.overrides[serverID]
+	g.statesLock.RUnlock()
+
+	if exists {
+		return policyFromConfig(override), PolicySourceOverride
+	}
+
+	raw, exists := labels[docker.LabelCrashPolicy]
+	if !exists || raw == "" {
+		return g.policy, PolicySourceDefault
+	}
+
+	var cfg docker.CrashPolicyConfig
+	if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+		g.logger.Warn("Ignoring invalid crash policy label", zap.String("serverID", serverID), zap.Error(err))
+		return g.policy, PolicySourceDefault
+	}
+	if err := cfg.Validate(); err != nil {
+		g.logger.Warn("Ignoring invalid crash policy label", zap.String("serverID", serverID), zap.Error(err))
+		return g.policy, PolicySourceDefault
+	}
+	return policyFromConfig(&cfg), PolicySourceServer
+}
+
+// SetPolicy overrides a server's configured policy, taking effect from its
+// next crash and surviving restarts of Wings. A nil config removes the
+// override, returning the server to its configured policy.
+func (g *Guard) SetPolicy(serverID string, cfg *docker.CrashPolicyConfig) error {
+	if cfg != nil {
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+	}
+
+	g.statesLock.Lock()
+	defer g.statesLock.Unlock()
+
+	if cfg == nil {
+		if err := os.Remove(g.policyPath(serverID)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove crash policy: %w", err)
+		}
+		delete(g.overrides, serverID)
+		g.logger.Info("Crash policy override removed", zap.String("serverID", serverID))
+		return nil
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(g.stateDir, 0700); err != nil {
+		return fmt.Errorf("failed to create crash guard state directory: %w", err)
+	}
+	if err := os.WriteFile(g.policyPath(serverID), data, 0600); err != nil {
+		return fmt.Errorf("failed to save crash policy: %w", err)
+	}
+
+	g.overrides[serverID] = cfg
+	g.logger.Info("Crash policy override set", zap.String("serverID", serverID), zap.ByteString("policy", data))
+	return nil
+}
+
+// loadPolicies reads the overrides saved by a previous run of Wings
+func (g *Guard) loadPolicies() {
+	entries, err := os.ReadDir(g.stateDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			g.logger.Warn("Failed to read crash policy overrides", zap.Error(err))
+		}
+		return
+	}
+
+	g.statesLock.Lock()
+	defer g.statesLock.Unlock()
+
+	for _, entry := range entries {
+		serverID, isPolicy := strings.CutSuffix(entry.Name(), ".json")
+		if !isPolicy || entry.IsDir() {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(g.stateDir, entry.Name()))
+		if err != nil {
+			g.logger.Warn("Failed to read crash policy", zap.String("serverID", serverID), zap.Error(err))
+			continue
+		}
+
+		var cfg docker.CrashPolicyConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			g.logger.Warn("Failed to parse crash policy", zap.String("serverID", serverID), zap.Error(err))
+			continue
+		}
+		// Saved by an older Wings or edited by hand; the server falls back to its configured policy
+		if err := cfg.Validate(); err != nil {
+			g.logger.Warn("Ignoring invalid crash policy", zap.String("serverID", serverID), zap.Error(err))
+			continue
+		}
+		g.overrides[serverID] = &cfg
+	}
+}
+
+// policyPath returns where a server's policy override is saved
+func (g *Guard) policyPath(serverID string) string {
+	return filepath.Join(g.stateDir, serverID+".json")
+}
+
+// seconds converts a duration to whole seconds for a PolicyConfig
+func seconds(d time.Duration) *int {
+	s := int(d / time.Second)
+	return &s
+}
@@ -0,0 +1,105 @@
+package crashguard
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mambapanel/wings/internal/docker"
+	"go.uber.org/zap"
+)
+
+func TestRestartPolicyIsCrash(t *testing.T) {
+	policy := DefaultRestartPolicy()
+	policy.CleanExitCodes = []int{1}
+
+	for code, want := range map[int]bool{0: false, 1: false, 2: true, 137: true, -1: true} {
+		if got := policy.IsCrash(code); got != want {
+			t.Fatalf("IsCrash(%d) = %v, want %v", code, got, want)
+		}
+	}
+
+	policy.CrashExitCodes = []int{137}
+	if policy.IsCrash(2) || !policy.IsCrash(137) {
+		t.Fatalf("IsCrash with crashExitCodes set counted the wrong codes")
+	}
+}
+
+func TestRestartPolicyBackoff(t *testing.T) {
+	policy := DefaultRestartPolicy()
+
+	want := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second}
+	for i, backoff := range want {
+		if got := policy.Backoff(i + 1); got != backoff {
+			t.Fatalf("Backoff(%d) = %s, want %s", i+1, got, backoff)
+		}
+	}
+	if got := policy.Backoff(100); got != policy.BackoffMax {
+		t.Fatalf("Backoff(100) = %s, want %s", got, policy.BackoffMax)
+	}
+}
+
+func TestGuardPolicy(t *testing.T) {
+	stateDir := t.TempDir()
+	guard := NewGuard(nil, nil, "node", stateDir, zap.NewNop())
+
+	labels := map[string]string{docker.LabelCrashPolicy: `{"maxAttempts":2,"cleanExitCodes":[1]}`}
+	policy, source := guard.Policy("srv", labels)
+	if source != PolicySourceServer || policy.MaxAttempts != 2 || policy.IsCrash(1) || policy.BackoffBase != 2*time.Second {
+		t.Fatalf("Policy from label = %+v, %s", policy, source)
+	}
+
+	if _, source := guard.Policy("srv", map[string]string{docker.LabelCrashPolicy: `{"maxAttempts":0}`}); source != PolicySourceDefault {
+		t.Fatalf("Policy from invalid label source = %s, want %s", source, PolicySourceDefault)
+	}
+
+	disabled := false
+	if err := guard.SetPolicy("srv", &docker.CrashPolicyConfig{Enabled: &disabled}); err != nil {
+		t.Fatalf("SetPolicy: %v", err)
+	}
+
+	// Overrides outlive the guard that set them
+	restarted := NewGuard(nil, nil, "node", stateDir, zap.NewNop())
+	restarted.loadPolicies()
+	if policy, source := restarted.Policy("srv", labels); source != PolicySourceOverride || policy.Enabled {
+		t.Fatalf("Policy after restart = %+v, %s", policy, source)
+	}
+
+	if err := restarted.SetPolicy("srv", nil); err != nil {
+		t.Fatalf("SetPolicy(nil): %v", err)
+	}
+	if _, source := restarted.Policy("srv", labels); source != PolicySourceServer {
+		t.Fatalf("Policy after removing override source = %s, want %s", source, PolicySourceServer)
+	}
+
+	invalid := -1
+	if err := guard.SetPolicy("srv", &docker.CrashPolicyConfig{BackoffSeconds: &invalid}); err == nil {
+		t.Fatalf("SetPolicy accepted a negative backoff")
+	}
+
+	// A saved override that no longer validates is ignored on load
+	if err := os.WriteFile(filepath.Join(stateDir, "crashguard", "other.json"), []byte(`{"maxAttempts":0}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := NewGuard(nil, nil, "node", stateDir, zap.NewNop())
+	reloaded.loadPolicies()
+	if _, source := reloaded.Policy("other", nil); source != PolicySourceDefault {
+		t.Fatalf("Policy from invalid saved override source = %s, want %s", source, PolicySourceDefault)
+	}
+}
+
+func TestGuardResetAttempts(t *testing.T) {
+	guard := NewGuard(nil, nil, "node", t.TempDir(), zap.NewNop())
+	guard.states["c1"] = &containerState{serverID: "srv", attempts: 5, failed: true}
+	guard.states["c2"] = &containerState{serverID: "other", attempts: 2}
+
+	// Without a cooldown, only starting the server clears what it used up
+	guard.ResetAttempts("srv")
+	if state := guard.GetContainerState("c1"); state != nil {
+		t.Fatalf("state after reset = %+v, want none", state)
+	}
+	if state := guard.GetContainerState("c2"); state == nil || state.attempts != 2 {
+		t.Fatalf("other server's state = %+v, want 2 attempts", state)
+	}
+}
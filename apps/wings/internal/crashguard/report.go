@@ -0,0 +1,242 @@
+package crashguard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"go.uber.org/zap"
+)
+
+const (
+	// crashReportLogLines is how many console lines a crash report keeps
+	crashReportLogLines = 100
+
+	// crashReportRetention is how many crash reports are kept per server
+	crashReportRetention = 20
+
+	// crashSummaryLines is how many of the last console lines go in the crash event
+	crashSummaryLines = 5
+
+	// crashRecordTimeout bounds the Docker calls made to look into one crash
+	crashRecordTimeout = 30 * time.Second
+)
+
+// ErrCrashReportNotFound is returned for a crash report that does not exist
+var ErrCrashReportNotFound = errors.New("crash report not found")
+
+// ResourceSample is a server's resource usage as last sampled before it crashed
+type ResourceSample struct {
+	Timestamp       string  `json:"timestamp"`
+	CPUUsagePercent float64 `json:"cpuUsagePercent"`
+	MemUsageMB      int64   `json:"memUsageMb"`
+	DiskUsageMB     int64   `json:"diskUsageMb"`
+	Uptime          int64   `json:"uptimeSeconds"`
+}
+
+// CrashReport records what is known about one crash, so it can be looked
+// into after the server has been restarted
+type CrashReport struct {
+	ID             string          `json:"id"`
+	ServerID       string          `json:"serverId"`
+	ContainerID    string          `json:"containerId"`
+	Timestamp      string          `json:"timestamp"`
+	ExitCode       int             `json:"exitCode"`
+	Reason         CrashReason     `json:"reason"`
+	Signal         int             `json:"signal,omitempty"`
+	OOMKilled      bool            `json:"oomKilled"`
+	Error          string          `json:"error,omitempty"` // Docker's error for the container, if any
+	StartedAt      string          `json:"startedAt,omitempty"`
+	FinishedAt     string          `json:"finishedAt,omitempty"`
+	RestartAttempt int             `json:"restartAttempt"`
+	Resources      *ResourceSample `json:"resources,omitempty"`
+	Logs           []string        `json:"logs,omitempty"`
+}
+
+// OnResourceSample sets where crash reports get a server's last resource
+// sample from. It must be set before Start.
+func (g *Guard) OnResourceSample(source func(serverID string) (ResourceSample, bool)) {
+	g.samples = source
+}
+
+// CrashReports returns a server's crash reports, newest first, without their logs
+func (g *Guard) CrashReports(serverID string) ([]CrashReport, error) {
+	entries, err := os.ReadDir(g.reportDir(serverID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []CrashReport{}, nil
+		}
+		return nil, err
+	}
+
+	reports := make([]CrashReport, 0, len(entries))
+	for _, entry := range entries {
+		id, isReport := strings.CutSuffix(entry.Name(), ".json")
+		if !isReport {
+			continue
+		}
+
+		report, err := g.CrashReport(serverID, id)
+		if err != nil {
+			g.logger.Warn("Failed to read crash report",
+				zap.String("serverID", serverID),
+				zap.String("crashId", id),
+				zap.Error(err))
+			continue
+		}
+		report.Logs = nil
+		reports = append(reports, *report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ID > reports[j].ID })
+	return reports, nil
+}
+
+// CrashReport returns one of a server's crash reports
+func (g *Guard) CrashReport(serverID, id string) (*CrashReport, error) {
+	if id == "" || filepath.Base(id) != id {
+		return nil, ErrCrashReportNotFound
+	}
+
+	data, err := os.ReadFile(filepath.Join(g.reportDir(serverID), id+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrCrashReportNotFound
+		}
+		return nil, err
+	}
+
+	var report CrashReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse crash report: %w", err)
+	}
+	return &report, nil
+}
+
+// recordCrash snapshots a crashed container's state, console output and
+// last resource usage into a report saved on the node
+func (g *Guard) recordCrash(ctx context.Context, containerID, serverID string, crash crash, attempt int, info *types.ContainerJSON) *CrashReport {
+	now := time.Now().UTC()
+	report := &CrashReport{
+		// Sortable, and unique as a server cannot crash twice in a nanosecond
+		ID:             now.Format("20060102T150405.000000000Z"),
+		ServerID:       serverID,
+		ContainerID:    containerID,
+		Timestamp:      now.Format(time.RFC3339),
+		ExitCode:       crash.exitCode,
+		Reason:         crash.reason,
+		Signal:         crash.signal,
+		OOMKilled:      crash.oomKilled,
+		RestartAttempt: attempt,
+		Logs:           g.tailLogs(ctx, containerID),
+	}
+
+	if info != nil && info.State != nil {
+		report.Error = info.State.Error
+		report.StartedAt = info.State.StartedAt
+		report.FinishedAt = info.State.FinishedAt
+	}
+	if g.samples != nil {
+		if sample, exists := g.samples(serverID); exists {
+			report.Resources = &sample
+		}
+	}
+
+	if err := g.saveCrashReport(report); err != nil {
+		g.logger.Error("Failed to save crash report", zap.String("serverID", serverID), zap.Error(err))
+	}
+	return report
+}
+
+// saveCrashReport writes a report and prunes the server's oldest ones
+func (g *Guard) saveCrashReport(report *CrashReport) error {
+	dir := g.reportDir(report.ServerID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, report.ID+".json"), data, 0600); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	// Entries are sorted by name, which for report IDs is oldest first
+	for len(entries) > crashReportRetention {
+		os.Remove(filepath.Join(dir, entries[0].Name()))
+		entries = entries[1:]
+	}
+	return nil
+}
+
+// tailLogs returns the last console lines a container printed
+func (g *Guard) tailLogs(ctx context.Context, containerID string) []string {
+	reader, err := g.dockerClient.ContainerLogs(ctx, containerID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       strconv.Itoa(crashReportLogLines),
+	})
+	if err != nil {
+		g.logger.Warn("Failed to read crashed container logs", zap.String("containerID", containerID[:12]), zap.Error(err))
+		return nil
+	}
+	defer reader.Close()
+
+	// Both streams go to one buffer so lines stay in the order they were printed
+	var output bytes.Buffer
+	if _, err := stdcopy.StdCopy(&output, &output, reader); err != nil {
+		g.logger.Warn("Failed to read crashed container logs", zap.String("containerID", containerID[:12]), zap.Error(err))
+	}
+
+	text := strings.TrimRight(output.String(), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// reportDir returns where a server's crash reports are saved
+func (g *Guard) reportDir(serverID string) string {
+	return filepath.Join(g.stateDir, "reports", serverID)
+}
+
+// summary returns the report's highlights for an event payload
+func (r *CrashReport) summary() map[string]interface{} {
+	summary := map[string]interface{}{
+		"crashId":        r.ID,
+		"exitCode":       r.ExitCode,
+		"reason":         r.Reason,
+		"oomKilled":      r.OOMKilled,
+		"restartAttempt": r.RestartAttempt,
+		"finishedAt":     r.FinishedAt,
+		"lastLines":      r.Logs[max(0, len(r.Logs)-crashSummaryLines):],
+	}
+	if r.Reason == CrashReasonSignal {
+		summary["signal"] = r.Signal
+	}
+	if r.Error != "" {
+		summary["error"] = r.Error
+	}
+	if r.Resources != nil {
+		summary["memUsageMb"] = r.Resources.MemUsageMB
+		summary["cpuUsagePercent"] = r.Resources.CPUUsagePercent
+	}
+	return summary
+}
@@ -0,0 +1,105 @@
+package crashguard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestCrashReports(t *testing.T) {
+	guard := NewGuard(nil, nil, "node", t.TempDir(), zap.NewNop())
+
+	for i := 0; i < crashReportRetention+2; i++ {
+		report := &CrashReport{
+			ID:       fmt.Sprintf("20260101T000000.%09dZ", i),
+			ServerID: "srv",
+			ExitCode: 1,
+			Reason:   CrashReasonExit,
+			Logs:     []string{"a", "b", "c", "d", "e", "f", fmt.Sprint(i)},
+		}
+		if err := guard.saveCrashReport(report); err != nil {
+			t.Fatalf("saveCrashReport: %v", err)
+		}
+	}
+
+	// The oldest reports are pruned, and listings leave out the logs
+	reports, err := guard.CrashReports("srv")
+	if err != nil {
+		t.Fatalf("CrashReports: %v", err)
+	}
+	if len(reports) != crashReportRetention {
+		t.Fatalf("CrashReports returned %d reports, want %d", len(reports), crashReportRetention)
+	}
+	newest := fmt.Sprintf("20260101T000000.%09dZ", crashReportRetention+1)
+	if reports[0].ID != newest || reports[0].Logs != nil {
+		t.Fatalf("CrashReports newest = %+v, want %s without logs", reports[0], newest)
+	}
+
+	report, err := guard.CrashReport("srv", newest)
+	if err != nil {
+		t.Fatalf("CrashReport: %v", err)
+	}
+	lastLines := report.summary()["lastLines"].([]string)
+	if len(lastLines) != crashSummaryLines || lastLines[len(lastLines)-1] != fmt.Sprint(crashReportRetention+1) {
+		t.Fatalf("summary lastLines = %q", lastLines)
+	}
+
+	for _, id := range []string{"20260101T000000.000000000Z", "../srv/" + newest, ""} {
+		if _, err := guard.CrashReport("srv", id); !errors.Is(err, ErrCrashReportNotFound) {
+			t.Fatalf("CrashReport(%q) error = %v, want %v", id, err, ErrCrashReportNotFound)
+		}
+	}
+
+	if reports, err := guard.CrashReports("other"); err != nil || len(reports) != 0 {
+		t.Fatalf("CrashReports for server without crashes = %v, %v", reports, err)
+	}
+}
+
+// recordingPoster keeps the last event body sent
+type recordingPoster struct {
+	body []byte
+}
+
+func (p *recordingPoster) Post(_ string, body []byte) (*http.Response, error) {
+	p.body = body
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func TestNotifyCrashEvent(t *testing.T) {
+	poster := &recordingPoster{}
+	guard := NewGuard(nil, nil, "node", t.TempDir(), zap.NewNop())
+	guard.apiClient = poster
+
+	guard.notifyCrashEvent("srv", "137", &CrashReport{
+		ID:             "20260101T000000.000000000Z",
+		ExitCode:       137,
+		Reason:         CrashReasonSignal,
+		Signal:         9,
+		RestartAttempt: 2,
+		Logs:           []string{"Killed"},
+	})
+
+	var event struct {
+		EventType string `json:"eventType"`
+		Metadata  struct {
+			ExitCode       string                 `json:"exitCode"`
+			RestartAttempt int                    `json:"restartAttempt"`
+			Crash          map[string]interface{} `json:"crash"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(poster.body, &event); err != nil {
+		t.Fatalf("crash event %s: %v", poster.body, err)
+	}
+
+	// Consumers of the original event keep their keys and types
+	if event.EventType != "crash" || event.Metadata.ExitCode != "137" || event.Metadata.RestartAttempt != 2 {
+		t.Fatalf("crash event = %s", poster.body)
+	}
+	if event.Metadata.Crash["crashId"] != "20260101T000000.000000000Z" || event.Metadata.Crash["reason"] != string(CrashReasonSignal) {
+		t.Fatalf("crash event summary = %v", event.Metadata.Crash)
+	}
+}
@@ -0,0 +1,493 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+)
+
+// Labels applied to every server container managed by Wings
+const (
+	LabelServerID    = "io.mamba.server_id"
+	LabelServerName  = "io.mamba.server_name"
+	LabelDiskLimitMB = "io.mamba.disk_limit_mb"
+
+	// Where the server's RCON listens, kept on the container so it comes
+	// from the server's configuration rather than whoever sends a command.
+	// The password is kept out of labels, in Wings' state directory.
+	LabelRCONProtocol = "io.mamba.rcon_protocol"
+	LabelRCONPort     = "io.mamba.rcon_port"
+
+	// LabelCrashPolicy holds the server's crash policy as JSON
+	LabelCrashPolicy = "io.mamba.crash_policy"
+)
+
+// ContainerDataPath is where the server data directory is mounted inside the container
+const ContainerDataPath = "/home/container"
+
+// serverIDPattern matches IDs usable both as container names and directory names
+var serverIDPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidServerID reports whether a server ID is safe to use as a container name and path component
+func ValidServerID(serverID string) bool {
+	return serverIDPattern.MatchString(serverID)
+}
+
+// PortMapping maps a host port to a container port
+type PortMapping struct {
+	Host      int    `json:"host"`
+	Container int    `json:"container"`
+	Protocol  string `json:"protocol"` // "tcp" or "udp"
+}
+
+// ServerConfig describes the container to create for a game server
+type ServerConfig struct {
+	ServerID       string            `json:"serverId"`
+	Name           string            `json:"name"`
+	Image          string            `json:"image"`
+	StartupCommand string            `json:"startupCommand"`
+	CPU            int64             `json:"cpu"`    // Millicores
+	Memory         int64             `json:"memory"` // MB
+	Disk           int64             `json:"disk"`   // MB
+	Ports          []PortMapping     `json:"ports"`
+	Environment    map[string]string `json:"environment"`
+
+	// User is the numeric "uid:gid" the server runs as, and the only owner
+	// its files can be given. Left empty, the image's user is used and
+	// ownership cannot be changed.
+	User string `json:"user,omitempty"`
+
+	// RCON is set for servers whose game exposes a remote console
+	RCON *RCONConfig `json:"rcon,omitempty"`
+
+	// CrashPolicy overrides how the crash guard restarts the server
+	CrashPolicy *CrashPolicyConfig `json:"crashPolicy,omitempty"`
+
+	// DataPath is the host directory bind-mounted as the server's data volume
+	DataPath string `json:"-"`
+}
+
+// RCONConfig describes how the game server's remote console is reached.
+// The password is not stored on the container; the caller saves it.
+type RCONConfig struct {
+	Protocol string `json:"protocol"` // One of the rcon package's protocols, defaults to Source RCON
+	Port     int    `json:"port"`     // Container port the game listens on
+	Password string `json:"password"`
+}
+
+// CrashPolicyConfig is a crash policy as servers are configured with it,
+// stored on the container under LabelCrashPolicy. Fields left out keep the
+// crash guard's default.
+type CrashPolicyConfig struct {
+	Enabled           *bool    `json:"enabled,omitempty"`
+	MaxAttempts       *int     `json:"maxAttempts,omitempty"`
+	BackoffSeconds    *int     `json:"backoffSeconds,omitempty"`
+	BackoffMultiplier *float64 `json:"backoffMultiplier,omitempty"`
+	BackoffMaxSeconds *int     `json:"backoffMaxSeconds,omitempty"`
+	CrashExitCodes    []int    `json:"crashExitCodes,omitempty"`
+	CleanExitCodes    []int    `json:"cleanExitCodes,omitempty"`
+	CooldownSeconds   *int     `json:"cooldownSeconds,omitempty"` // 0 keeps attempts until a power action starts the server
+}
+
+// Validate checks the configured values are usable
+func (c *CrashPolicyConfig) Validate() error {
+	if c.MaxAttempts != nil && *c.MaxAttempts < 1 {
+		return fmt.Errorf("maxAttempts must be at least 1")
+	}
+	if c.BackoffMultiplier != nil && *c.BackoffMultiplier < 1 {
+		return fmt.Errorf("backoffMultiplier must be at least 1")
+	}
+	for name, value := range map[string]*int{
+		"backoffSeconds":    c.BackoffSeconds,
+		"backoffMaxSeconds": c.BackoffMaxSeconds,
+		"cooldownSeconds":   c.CooldownSeconds,
+	} {
+		if value != nil && *value < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	for _, code := range append(slices.Clone(c.CrashExitCodes), c.CleanExitCodes...) {
+		if code < 1 || code > 255 {
+			return fmt.Errorf("invalid exit code %d", code)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the configuration can be turned into a container
+func (s *ServerConfig) Validate() error {
+	if s.ServerID == "" {
+		return fmt.Errorf("serverId is required")
+	}
+	if !ValidServerID(s.ServerID) {
+		return fmt.Errorf("invalid serverId: %s", s.ServerID)
+	}
+	if s.Image == "" {
+		return fmt.Errorf("image is required")
+	}
+	if s.CPU < 0 || s.Memory < 0 || s.Disk < 0 {
+		return fmt.Errorf("resource limits must not be negative")
+	}
+
+	for _, port := range s.Ports {
+		if port.Host < 1 || port.Host > 65535 || port.Container < 1 || port.Container > 65535 {
+			return fmt.Errorf("invalid port mapping %d:%d", port.Host, port.Container)
+		}
+		if port.Protocol != "tcp" && port.Protocol != "udp" {
+			return fmt.Errorf("invalid port protocol: %s", port.Protocol)
+		}
+	}
+
+	if s.User != "" {
+		if _, _, ok := ParseUser(s.User); !ok {
+			return fmt.Errorf("invalid user %q, expected uid:gid", s.User)
+		}
+	}
+
+	if s.RCON != nil {
+		if s.RCON.Port < 1 || s.RCON.Port > 65535 {
+			return fmt.Errorf("invalid rcon port %d", s.RCON.Port)
+		}
+		if s.RCON.Password == "" {
+			return fmt.Errorf("rcon password is required")
+		}
+	}
+
+	if s.CrashPolicy != nil {
+		if err := s.CrashPolicy.Validate(); err != nil {
+			return fmt.Errorf("invalid crash policy: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// CreateServer pulls the image and creates the container for a game server,
+// returning the container ID. The container is named after the server ID so
+// the existing per-server routes can address it directly.
+func (c *Client) CreateServer(cfg ServerConfig) (string, error) {
+	if err := cfg.Validate(); err != nil {
+		return "", err
+	}
+
+	if err := c.PullImage(cfg.Image); err != nil {
+		return "", err
+	}
+
+	if cfg.DataPath != "" {
+		if err := os.MkdirAll(cfg.DataPath, 0755); err != nil {
+			return "", fmt.Errorf("failed to create data directory: %w", err)
+		}
+	}
+
+	exposedPorts, portBindings := buildPortMap(cfg.Ports)
+
+	containerConfig := &container.Config{
+		Image:        cfg.Image,
+		Env:          buildEnv(cfg),
+		ExposedPorts: exposedPorts,
+		WorkingDir:   ContainerDataPath,
+		User:         cfg.User,
+		OpenStdin:    true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Labels: map[string]string{
+			LabelServerID:    cfg.ServerID,
+			LabelServerName:  cfg.Name,
+			LabelDiskLimitMB: strconv.FormatInt(cfg.Disk, 10),
+		},
+	}
+
+	if cfg.RCON != nil {
+		containerConfig.Labels[LabelRCONProtocol] = cfg.RCON.Protocol
+		containerConfig.Labels[LabelRCONPort] = strconv.Itoa(cfg.RCON.Port)
+	}
+
+	if cfg.CrashPolicy != nil {
+		policy, err := json.Marshal(cfg.CrashPolicy)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode crash policy: %w", err)
+		}
+		containerConfig.Labels[LabelCrashPolicy] = string(policy)
+	}
+
+	if cfg.StartupCommand != "" {
+		containerConfig.Cmd = []string{"/bin/sh", "-c", expandStartup(cfg.StartupCommand, cfg.Environment)}
+	}
+
+	hostConfig := &container.HostConfig{
+		PortBindings: portBindings,
+		Resources: container.Resources{
+			NanoCPUs:   cfg.CPU * 1_000_000,
+			Memory:     cfg.Memory * 1024 * 1024,
+			MemorySwap: cfg.Memory * 1024 * 1024,
+		},
+	}
+
+	if cfg.DataPath != "" {
+		hostConfig.Mounts = []mount.Mount{
+			{
+				Type:   mount.TypeBind,
+				Source: cfg.DataPath,
+				Target: ContainerDataPath,
+			},
+		}
+	}
+
+	resp, err := c.cli.ContainerCreate(c.ctx, containerConfig, hostConfig, nil, nil, cfg.ServerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create container: %w", err)
+	}
+
+	return resp.ID, nil
+}
+
+// ErrServerNotFound is returned when no container carries a server's label
+var ErrServerNotFound = errors.New("server container not found")
+
+// FindServerContainer resolves a server ID to its container using the io.mamba.server_id label
+func (c *Client) FindServerContainer(serverID string) (types.Container, error) {
+	containers, err := c.cli.ContainerList(c.ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelServerID+"="+serverID)),
+	})
+	if err != nil {
+		return types.Container{}, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	if len(containers) == 0 {
+		return types.Container{}, ErrServerNotFound
+	}
+
+	return containers[0], nil
+}
+
+// ServerDiskLimit returns a server's disk limit in MB from its container
+// label, with 0 meaning unlimited. A server without a container has no limit.
+func (c *Client) ServerDiskLimit(serverID string) (int64, error) {
+	container, err := c.FindServerContainer(serverID)
+	if errors.Is(err, ErrServerNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	label, exists := container.Labels[LabelDiskLimitMB]
+	if !exists || label == "" {
+		return 0, nil
+	}
+
+	limit, err := strconv.ParseInt(label, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s label %q: %w", LabelDiskLimitMB, label, err)
+	}
+
+	return limit, nil
+}
+
+// ErrUserNotConfigured is returned for a server whose container does not run as a numeric uid:gid
+var ErrUserNotConfigured = errors.New("server has no container user configured")
+
+// ParseUser parses a numeric "uid:gid" container user
+func ParseUser(user string) (uid, gid int, ok bool) {
+	uidPart, gidPart, found := strings.Cut(user, ":")
+	if !found {
+		return 0, 0, false
+	}
+	uid, uidErr := strconv.Atoi(uidPart)
+	gid, gidErr := strconv.Atoi(gidPart)
+	if uidErr != nil || gidErr != nil || uid < 0 || gid < 0 {
+		return 0, 0, false
+	}
+	return uid, gid, true
+}
+
+// ServerUser returns the uid and gid a server's container runs as
+func (c *Client) ServerUser(serverID string) (uid, gid int, err error) {
+	container, err := c.FindServerContainer(serverID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	info, err := c.cli.ContainerInspect(c.ctx, container.ID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if info.Config == nil {
+		return 0, 0, ErrUserNotConfigured
+	}
+	uid, gid, ok := ParseUser(info.Config.User)
+	if !ok {
+		return 0, 0, ErrUserNotConfigured
+	}
+	return uid, gid, nil
+}
+
+// Errors returned when looking up a server's RCON target
+var (
+	ErrRCONNotConfigured   = errors.New("server has no rcon configuration")
+	ErrContainerNotRunning = errors.New("server is not running")
+)
+
+// RCONEndpoint is where a server's remote console listens
+type RCONEndpoint struct {
+	Protocol string
+	Host     string
+	Port     int
+}
+
+// ServerRCON returns where to reach a server's remote console, using the RCON
+// settings stored on its container. The console is reached on the
+// container's own address, so the port need not be published on the host.
+func (c *Client) ServerRCON(serverID string) (RCONEndpoint, error) {
+	container, err := c.FindServerContainer(serverID)
+	if err != nil {
+		return RCONEndpoint{}, err
+	}
+
+	portLabel, exists := container.Labels[LabelRCONPort]
+	if !exists || portLabel == "" {
+		return RCONEndpoint{}, ErrRCONNotConfigured
+	}
+	port, err := strconv.Atoi(portLabel)
+	if err != nil {
+		return RCONEndpoint{}, fmt.Errorf("invalid %s label %q: %w", LabelRCONPort, portLabel, err)
+	}
+
+	if container.State != "running" {
+		return RCONEndpoint{}, ErrContainerNotRunning
+	}
+
+	// Containers on the host network have no address of their own
+	host := "127.0.0.1"
+	if container.NetworkSettings != nil {
+		names := make([]string, 0, len(container.NetworkSettings.Networks))
+		for name := range container.NetworkSettings.Networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if network := container.NetworkSettings.Networks[name]; network != nil && network.IPAddress != "" {
+				host = network.IPAddress
+				break
+			}
+		}
+	}
+
+	return RCONEndpoint{
+		Protocol: container.Labels[LabelRCONProtocol],
+		Host:     host,
+		Port:     port,
+	}, nil
+}
+
+// RemoveServer force-removes a server's container. A container that no
+// longer exists is not an error, so deletion can be retried safely.
+func (c *Client) RemoveServer(serverID string) error {
+	err := c.cli.ContainerRemove(c.ctx, serverID, container.RemoveOptions{
+		Force:         true,
+		RemoveVolumes: true,
+	})
+	if err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+	return nil
+}
+
+// PullImage pulls an image, falling back to a local copy if the pull fails
+func (c *Client) PullImage(image string) error {
+	reader, err := c.cli.ImagePull(c.ctx, image, types.ImagePullOptions{})
+	if err != nil {
+		if _, _, inspectErr := c.cli.ImageInspectWithRaw(c.ctx, image); inspectErr == nil {
+			return nil
+		}
+		return fmt.Errorf("failed to pull image %s: %w", image, err)
+	}
+	defer reader.Close()
+
+	// The pull only completes once the progress stream has been drained
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", image, err)
+	}
+
+	return nil
+}
+
+// buildEnv converts the environment map into Docker's KEY=value form
+func buildEnv(cfg ServerConfig) []string {
+	env := make([]string, 0, len(cfg.Environment)+1)
+	for key, value := range cfg.Environment {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(env)
+
+	if cfg.StartupCommand != "" {
+		env = append(env, fmt.Sprintf("STARTUP=%s", cfg.StartupCommand))
+	}
+
+	return env
+}
+
+// buildPortMap converts port mappings into exposed ports and host bindings
+func buildPortMap(ports []PortMapping) (nat.PortSet, nat.PortMap) {
+	exposed := nat.PortSet{}
+	bindings := nat.PortMap{}
+
+	for _, mapping := range ports {
+		port := nat.Port(fmt.Sprintf("%d/%s", mapping.Container, mapping.Protocol))
+		exposed[port] = struct{}{}
+		bindings[port] = append(bindings[port], nat.PortBinding{
+			HostPort: strconv.Itoa(mapping.Host),
+		})
+	}
+
+	return exposed, bindings
+}
+
+// startupPlaceholder matches a blueprint {{VARIABLE}} placeholder
+var startupPlaceholder = regexp.MustCompile(`\{\{([^{}]+)\}\}`)
+
+// shellName matches variable names the shell can expand
+var shellName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// expandStartup substitutes blueprint {{VARIABLE}} placeholders in the startup
+// command, which runs through /bin/sh -c. Values never become shell syntax:
+// placeholders turn into quoted references to the container's environment,
+// or into the quoted value for names the shell cannot expand. Placeholders
+// are replaced in a single pass, so a value containing one is left as it is.
+func expandStartup(command string, env map[string]string) string {
+	return startupPlaceholder.ReplaceAllStringFunc(command, func(placeholder string) string {
+		key := placeholder[2 : len(placeholder)-2]
+		value, exists := env[key]
+		if !exists {
+			return placeholder
+		}
+		if shellName.MatchString(key) {
+			return `"${` + key + `}"`
+		}
+		return shellQuote(value)
+	})
+}
+
+// shellQuote quotes a value as a single shell word
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,164 @@
+package docker
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServerConfigValidate(t *testing.T) {
+	zero := 0
+
+	for _, tt := range []struct {
+		name   string
+		modify func(cfg *ServerConfig)
+		err    string // Part of the expected error, "" for a valid config
+	}{
+		{name: "valid", modify: func(cfg *ServerConfig) {}},
+		{name: "missing server ID", modify: func(cfg *ServerConfig) { cfg.ServerID = "" }, err: "serverId is required"},
+		{name: "server ID with a path", modify: func(cfg *ServerConfig) { cfg.ServerID = "../srv" }, err: "invalid serverId"},
+		{name: "server ID starting with a dot", modify: func(cfg *ServerConfig) { cfg.ServerID = ".srv" }, err: "invalid serverId"},
+		{name: "missing image", modify: func(cfg *ServerConfig) { cfg.Image = "" }, err: "image is required"},
+		{name: "negative memory", modify: func(cfg *ServerConfig) { cfg.Memory = -1 }, err: "must not be negative"},
+		{
+			name:   "host port out of range",
+			modify: func(cfg *ServerConfig) { cfg.Ports[0].Host = 65536 },
+			err:    "invalid port mapping",
+		},
+		{
+			name:   "container port missing",
+			modify: func(cfg *ServerConfig) { cfg.Ports[0].Container = 0 },
+			err:    "invalid port mapping",
+		},
+		{
+			name:   "unknown port protocol",
+			modify: func(cfg *ServerConfig) { cfg.Ports[0].Protocol = "sctp" },
+			err:    "invalid port protocol",
+		},
+		{name: "numeric user", modify: func(cfg *ServerConfig) { cfg.User = "1000:1000" }},
+		{name: "named user", modify: func(cfg *ServerConfig) { cfg.User = "container" }, err: "invalid user"},
+		{name: "negative uid", modify: func(cfg *ServerConfig) { cfg.User = "-1:1000" }, err: "invalid user"},
+		{
+			name:   "rcon",
+			modify: func(cfg *ServerConfig) { cfg.RCON = &RCONConfig{Port: 25575, Password: "secret"} },
+		},
+		{
+			name:   "rcon port out of range",
+			modify: func(cfg *ServerConfig) { cfg.RCON = &RCONConfig{Port: 0, Password: "secret"} },
+			err:    "invalid rcon port",
+		},
+		{
+			name:   "rcon without password",
+			modify: func(cfg *ServerConfig) { cfg.RCON = &RCONConfig{Port: 25575} },
+			err:    "rcon password is required",
+		},
+		{
+			name:   "crash policy",
+			modify: func(cfg *ServerConfig) { cfg.CrashPolicy = &CrashPolicyConfig{CooldownSeconds: &zero} },
+		},
+		{
+			name:   "invalid crash policy",
+			modify: func(cfg *ServerConfig) { cfg.CrashPolicy = &CrashPolicyConfig{MaxAttempts: &zero} },
+			err:    "invalid crash policy",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := ServerConfig{
+				ServerID: "srv-1",
+				Image:    "ghcr.io/mambapanel/minecraft:latest",
+				Memory:   1024,
+				Ports:    []PortMapping{{Host: 25565, Container: 25565, Protocol: "tcp"}},
+			}
+			tt.modify(&cfg)
+
+			err := cfg.Validate()
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Validate error = %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestExpandStartup(t *testing.T) {
+	for _, tt := range []struct {
+		command string
+		env     map[string]string
+		want    string
+	}{
+		{
+			command: "java -Xmx{{SERVER_MEMORY}}M -jar {{SERVER_JAR}}",
+			env:     map[string]string{"SERVER_MEMORY": "1024", "SERVER_JAR": "server.jar"},
+			want:    `java -Xmx"${SERVER_MEMORY}"M -jar "${SERVER_JAR}"`,
+		},
+		{
+			command: "./srcds_run -port {{PORT}} +hostport {{PORT}}",
+			env:     map[string]string{"PORT": "27015"},
+			want:    `./srcds_run -port "${PORT}" +hostport "${PORT}"`,
+		},
+		{
+			// Names the shell cannot expand get their value, quoted
+			command: "./start.sh --name {{server-name}}",
+			env:     map[string]string{"server-name": "it's; rm -rf /mnt/server"},
+			want:    `./start.sh --name 'it'\''s; rm -rf /mnt/server'`,
+		},
+		{
+			// Unknown placeholders are left for the server to report
+			command: "./start.sh {{MISSING}}",
+			env:     map[string]string{"OTHER": "value"},
+			want:    "./start.sh {{MISSING}}",
+		},
+		{
+			// Only the braced form is a placeholder
+			command: "echo $PORT {PORT} {{PORT}}",
+			env:     map[string]string{"PORT": "25565"},
+			want:    `echo $PORT {PORT} "${PORT}"`,
+		},
+		{
+			command: "./start.sh",
+			env:     nil,
+			want:    "./start.sh",
+		},
+	} {
+		if got := expandStartup(tt.command, tt.env); got != tt.want {
+			t.Fatalf("expandStartup(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestExpandStartupInjection(t *testing.T) {
+	env := map[string]string{
+		"NAME":        "x; touch pwned",
+		"server-name": "'; touch pwned; '",
+		"MOTD":        "$(touch pwned) {{NAME}}",
+	}
+	command := expandStartup("printf '%s|' {{NAME}} {{server-name}} {{MOTD}}", env)
+
+	// Run the command the way the container does, with the server's environment
+	dir := t.TempDir()
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Dir = dir
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("running %q: %v", command, err)
+	}
+
+	// Every value arrives as exactly one argument, with nothing run or expanded
+	want := "x; touch pwned|'; touch pwned; '|$(touch pwned) {{NAME}}|"
+	if string(output) != want {
+		t.Fatalf("output = %q, want %q", output, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Fatalf("a value ran as a shell command")
+	}
+}
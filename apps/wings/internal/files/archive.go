@@ -0,0 +1,858 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"go.uber.org/zap"
+)
+
+// ArchiveFormat identifies a supported archive container and compression
+type ArchiveFormat string
+
+const (
+	FormatZip    ArchiveFormat = "zip"
+	FormatTar    ArchiveFormat = "tar"
+	FormatTarGz  ArchiveFormat = "tar.gz"
+	FormatTarXz  ArchiveFormat = "tar.xz"
+	FormatTarZst ArchiveFormat = "tar.zst"
+)
+
+// progressInterval is the least time between two progress reports of one job
+const progressInterval = time.Second
+
+// Errors returned by archive operations
+var (
+	ErrUnsupportedArchive = errors.New("unsupported archive format")
+	ErrArchiveTooLarge    = errors.New("archive exceeds extraction limits")
+)
+
+// ArchiveLimits bound what extracting a single archive may write, so a small
+// crafted archive cannot fill the disk. Zero disables a limit.
+type ArchiveLimits struct {
+	MaxSize    int64 // Total bytes written across all entries
+	MaxEntries int   // Number of entries in the archive
+	MaxRatio   int64 // Bytes written per byte of archive
+}
+
+// DefaultArchiveLimits are generous enough for large worlds and modpacks
+var DefaultArchiveLimits = ArchiveLimits{
+	MaxSize:    50 << 30,
+	MaxEntries: 200000,
+	MaxRatio:   1000,
+}
+
+// ArchiveProgress reports how far a compress or extract job has got. Done and
+// Total are source bytes when compressing and archive bytes when extracting.
+type ArchiveProgress struct {
+	Entries  int    `json:"entries"`
+	Done     int64  `json:"done"`
+	Total    int64  `json:"total"`
+	Current  string `json:"current,omitempty"`
+	Finished bool   `json:"finished"`
+}
+
+// Percent returns the job's progress from 0 to 100
+func (p ArchiveProgress) Percent() int {
+	if p.Finished {
+		return 100
+	}
+	if p.Total <= 0 {
+		return 0
+	}
+	return int(min(p.Done*100/p.Total, 99))
+}
+
+// ProgressFunc receives progress reports while an archive job runs
+type ProgressFunc func(ArchiveProgress)
+
+type progressKey struct{}
+
+// WithProgress attaches a progress callback to the context of a compress or
+// extract call. Reports are throttled, with a final one once the job finishes.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// ArchiveFormatFromName picks the format for a new archive from its file
+// name, falling back to tar.gz for names without a known extension
+func ArchiveFormatFromName(name string) ArchiveFormat {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return FormatZip
+	case strings.HasSuffix(lower, ".tar.xz"), strings.HasSuffix(lower, ".txz"):
+		return FormatTarXz
+	case strings.HasSuffix(lower, ".tar.zst"), strings.HasSuffix(lower, ".tzst"):
+		return FormatTarZst
+	case strings.HasSuffix(lower, ".tar"):
+		return FormatTar
+	default:
+		return FormatTarGz
+	}
+}
+
+// Magic numbers used to detect archive formats
+var (
+	magicZip      = []byte("PK\x03\x04")
+	magicZipEmpty = []byte("PK\x05\x06")
+	magicGzip     = []byte{0x1f, 0x8b}
+	magicXz       = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	magicZstd     = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magic7z       = []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}
+	magicTar      = []byte("ustar")
+)
+
+// tarMagicOffset is where the ustar magic sits in a tar header block
+const tarMagicOffset = 257
+
+// DetectArchiveFormat identifies an archive from its leading bytes, ignoring
+// its name. At least 262 bytes are needed to recognise an uncompressed tar.
+func DetectArchiveFormat(header []byte) (ArchiveFormat, error) {
+	switch {
+	case bytes.HasPrefix(header, magicZip), bytes.HasPrefix(header, magicZipEmpty):
+		return FormatZip, nil
+	case bytes.HasPrefix(header, magicGzip):
+		return FormatTarGz, nil
+	case bytes.HasPrefix(header, magicXz):
+		return FormatTarXz, nil
+	case bytes.HasPrefix(header, magicZstd):
+		return FormatTarZst, nil
+	case bytes.HasPrefix(header, magic7z):
+		return "", fmt.Errorf("%w: 7z archives are not supported", ErrUnsupportedArchive)
+	case len(header) >= tarMagicOffset+len(magicTar) && bytes.Equal(header[tarMagicOffset:tarMagicOffset+len(magicTar)], magicTar):
+		return FormatTar, nil
+	}
+	return "", ErrUnsupportedArchive
+}
+
+// compressArchive archives paths into outputPath, in the format its name
+// implies. The archive is built under a temporary name and renamed into place
+// once complete, so a failed job never leaves a truncated archive behind.
+// quota is the disk quota left in bytes, or negative when unlimited; the
+// returned growth is how much the archive added to the server's data. The
+// archive and any directories created for it are given to owner.
+func compressArchive(ctx context.Context, sandbox *Sandbox, owner fileOwner, paths []string, outputPath string, quota int64) (int64, error) {
+	archivePath, err := sandbox.Resolve(outputPath)
+	if err != nil {
+		return 0, err
+	}
+
+	// An archive being replaced frees its space once the new one is in place
+	var existing int64
+	if info, err := os.Stat(archivePath); err == nil {
+		if !info.Mode().IsRegular() {
+			return 0, ErrNotRegularFile
+		}
+		existing = info.Size()
+	}
+
+	sources := make([]string, 0, len(paths))
+	for _, p := range paths {
+		source, err := sandbox.Resolve(p)
+		if err != nil {
+			return 0, err
+		}
+		sources = append(sources, source)
+	}
+
+	total, err := treeSize(ctx, sources)
+	if err != nil {
+		return 0, err
+	}
+	progress := newProgressTracker(ctx, total)
+
+	if err := owner.mkdirAll(filepath.Dir(archivePath), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create parent directory: %w", err)
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(archivePath), "."+filepath.Base(archivePath)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	var w io.Writer = out
+	if quota >= 0 {
+		// The temporary file already counts against the quota while it is written
+		allowed := quota + existing
+		w = &cappedWriter{w: out, remaining: &allowed, err: ErrQuotaExceeded}
+	}
+
+	skip := map[string]bool{archivePath: true, out.Name(): true}
+
+	var writeErr error
+	switch format := ArchiveFormatFromName(archivePath); format {
+	case FormatZip:
+		writeErr = writeZip(ctx, w, sandbox.Root(), sources, skip, progress)
+	default:
+		writeErr = writeCompressedTar(ctx, w, format, sandbox.Root(), sources, skip, progress)
+	}
+	if writeErr != nil {
+		return 0, writeErr
+	}
+
+	info, err := out.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat archive: %w", err)
+	}
+	if err := out.Chmod(0644); err != nil {
+		return 0, fmt.Errorf("failed to set archive permissions: %w", err)
+	}
+	if err := owner.chown(out.Name()); err != nil {
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := os.Rename(out.Name(), archivePath); err != nil {
+		return 0, fmt.Errorf("failed to move archive into place: %w", err)
+	}
+
+	progress.finish()
+	return info.Size() - existing, nil
+}
+
+// writeCompressedTar writes sources as a tar stream through the format's compressor
+func writeCompressedTar(ctx context.Context, out io.Writer, format ArchiveFormat, root string, sources []string, skip map[string]bool, progress *progressTracker) error {
+	var compressor io.WriteCloser
+	switch format {
+	case FormatTar:
+		compressor = nopWriteCloser{out}
+	case FormatTarGz:
+		compressor = gzip.NewWriter(out)
+	case FormatTarXz:
+		w, err := xz.NewWriter(out)
+		if err != nil {
+			return fmt.Errorf("failed to create xz writer: %w", err)
+		}
+		compressor = w
+	case FormatTarZst:
+		w, err := zstd.NewWriter(out)
+		if err != nil {
+			return fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+		compressor = w
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedArchive, format)
+	}
+
+	tarWriter := tar.NewWriter(compressor)
+	for _, source := range sources {
+		if err := addToTar(ctx, tarWriter, root, source, skip, progress); err != nil {
+			compressor.Close()
+			return err
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		compressor.Close()
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	if err := compressor.Close(); err != nil {
+		return fmt.Errorf("failed to finish %s stream: %w", format, err)
+	}
+
+	return nil
+}
+
+// writeZip writes sources to a deflated zip archive
+func writeZip(ctx context.Context, out io.Writer, root string, sources []string, skip map[string]bool, progress *progressTracker) error {
+	zipWriter := zip.NewWriter(out)
+
+	for _, source := range sources {
+		err := walkArchiveSources(ctx, root, source, skip, func(p, name string, info fs.FileInfo) error {
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			header.Name = name
+			if info.IsDir() {
+				header.Name += "/"
+			} else {
+				header.Method = zip.Deflate
+			}
+
+			w, err := zipWriter.CreateHeader(header)
+			if err != nil {
+				return fmt.Errorf("failed to write zip header: %w", err)
+			}
+
+			progress.entry(name)
+			if info.IsDir() {
+				return nil
+			}
+			return copyFileTo(w, p, progress)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		return fmt.Errorf("failed to close zip writer: %w", err)
+	}
+
+	return nil
+}
+
+// addToTar writes a file or directory tree to a tar archive with names relative to root
+func addToTar(ctx context.Context, tarWriter *tar.Writer, root, source string, skip map[string]bool, progress *progressTracker) error {
+	return walkArchiveSources(ctx, root, source, skip, func(p, name string, info fs.FileInfo) error {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write tar header: %w", err)
+		}
+
+		progress.entry(name)
+		if info.IsDir() {
+			return nil
+		}
+		return copyFileTo(tarWriter, p, progress)
+	})
+}
+
+// walkArchiveSources calls fn for every directory and regular file under
+// source, with its name relative to root. Symlinks and special files are
+// left out, as are the paths in skip.
+func walkArchiveSources(ctx context.Context, root, source string, skip map[string]bool, fn func(p, name string, info fs.FileInfo) error) error {
+	return filepath.WalkDir(source, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Never include the archive being written
+		if skip[p] {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		name, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		return fn(p, filepath.ToSlash(name), info)
+	})
+}
+
+// copyFileTo streams a file's contents into w, counting them as progress
+func copyFileTo(w io.Writer, p string, progress *progressTracker) error {
+	file, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(w, progress.reader(file)); err != nil {
+		return fmt.Errorf("failed to write archive content: %w", err)
+	}
+	return nil
+}
+
+// treeSize sums the sizes of the regular files under sources without following symlinks
+func treeSize(ctx context.Context, sources []string) (int64, error) {
+	var total int64
+	for _, source := range sources {
+		err := filepath.WalkDir(source, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				// Files removed mid-walk are simply not counted
+				if errors.Is(err, fs.ErrNotExist) && p != source {
+					return nil
+				}
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if entry.Type().IsRegular() {
+				if info, err := entry.Info(); err == nil {
+					total += info.Size()
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// extractArchive extracts an archive into targetPath after detecting its
+// format from its contents. Entries are confined to the target directory,
+// links and special files are skipped, and limits cap what may be written.
+// quota is the disk quota left in bytes, or negative when unlimited. What is
+// extracted is given to owner.
+func extractArchive(ctx context.Context, sandbox *Sandbox, owner fileOwner, archivePath, targetPath string, limits ArchiveLimits, quota int64, logger *zap.Logger) error {
+	source, err := sandbox.Resolve(archivePath)
+	if err != nil {
+		return err
+	}
+	target, err := sandbox.Resolve(targetPath)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat archive: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return ErrNotRegularFile
+	}
+
+	header := make([]byte, tarMagicOffset+len(magicTar))
+	n, err := io.ReadFull(in, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	format, err := DetectArchiveFormat(header[:n])
+	if err != nil {
+		return err
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	x := &extractor{
+		ctx:      ctx,
+		sandbox:  sandbox,
+		target:   target,
+		owner:    owner,
+		budget:   newExtractBudget(limits, info.Size(), quota),
+		progress: newProgressTracker(ctx, info.Size()),
+		logger:   logger,
+	}
+
+	if format == FormatZip {
+		err = x.zip(in, info.Size())
+	} else {
+		err = x.tar(in, format)
+	}
+	if err != nil {
+		return err
+	}
+
+	x.progress.finish()
+	return nil
+}
+
+// extractor writes archive entries below a target directory
+type extractor struct {
+	ctx      context.Context
+	sandbox  *Sandbox
+	target   string
+	owner    fileOwner
+	budget   *extractBudget
+	progress *progressTracker
+	logger   *zap.Logger
+}
+
+// tar extracts a tar stream, decompressing it first as the format requires
+func (x *extractor) tar(in io.Reader, format ArchiveFormat) error {
+	// Progress follows the compressed bytes consumed, which the archive size bounds
+	counted := x.progress.reader(in)
+
+	var stream io.Reader
+	switch format {
+	case FormatTar:
+		stream = counted
+	case FormatTarGz:
+		r, err := gzip.NewReader(counted)
+		if err != nil {
+			return fmt.Errorf("failed to read gzip: %w", err)
+		}
+		defer r.Close()
+		stream = r
+	case FormatTarXz:
+		r, err := xz.NewReader(counted)
+		if err != nil {
+			return fmt.Errorf("failed to read xz: %w", err)
+		}
+		stream = r
+	case FormatTarZst:
+		r, err := zstd.NewReader(counted)
+		if err != nil {
+			return fmt.Errorf("failed to read zstd: %w", err)
+		}
+		defer r.Close()
+		stream = r
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedArchive, format)
+	}
+
+	tarReader := tar.NewReader(stream)
+	for {
+		if err := x.ctx.Err(); err != nil {
+			return err
+		}
+
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar: %w", err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = x.directory(header.Name)
+		case tar.TypeReg:
+			err = x.file(header.Name, tarReader, os.FileMode(header.Mode).Perm())
+		default:
+			err = x.skip(header.Name, fmt.Sprintf("tar type %q", header.Typeflag))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// zip extracts a zip archive. Progress follows the compressed size of each entry.
+func (x *extractor) zip(in io.ReaderAt, size int64) error {
+	zipReader, err := zip.NewReader(in, size)
+	if err != nil {
+		return fmt.Errorf("failed to read zip: %w", err)
+	}
+
+	for _, entry := range zipReader.File {
+		if err := x.ctx.Err(); err != nil {
+			return err
+		}
+
+		mode := entry.Mode()
+		switch {
+		case mode.IsDir():
+			err = x.directory(entry.Name)
+		case mode.IsRegular():
+			err = x.zipFile(entry)
+		default:
+			err = x.skip(entry.Name, mode.Type().String())
+		}
+		if err != nil {
+			return err
+		}
+
+		x.progress.add(int64(entry.CompressedSize64))
+	}
+
+	return nil
+}
+
+// zipFile extracts a single regular file from a zip archive
+func (x *extractor) zipFile(entry *zip.File) error {
+	r, err := entry.Open()
+	if err != nil {
+		return fmt.Errorf("failed to read zip entry %s: %w", entry.Name, err)
+	}
+	defer r.Close()
+
+	return x.file(entry.Name, r, entry.Mode().Perm())
+}
+
+// directory creates a directory entry
+func (x *extractor) directory(name string) error {
+	target, err := x.resolve(name)
+	if err != nil || target == "" {
+		return err
+	}
+	if err := x.budget.entry(); err != nil {
+		return err
+	}
+
+	x.progress.entry(name)
+	if err := x.owner.mkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return nil
+}
+
+// file writes a regular file entry, counting what it writes against the budget
+func (x *extractor) file(name string, r io.Reader, mode os.FileMode) error {
+	target, err := x.resolve(name)
+	if err != nil || target == "" {
+		return err
+	}
+	if err := x.budget.entry(); err != nil {
+		return err
+	}
+
+	x.progress.entry(name)
+	if _, err := writeFromReader(target, x.budget.reader(r), mode, x.owner); err != nil {
+		// The partial file from a rejected entry is not worth keeping
+		if errors.Is(err, ErrArchiveTooLarge) || errors.Is(err, ErrQuotaExceeded) {
+			os.Remove(target)
+		}
+		return err
+	}
+	return nil
+}
+
+// skip logs an entry that is not extracted
+func (x *extractor) skip(name, kind string) error {
+	if err := x.budget.entry(); err != nil {
+		return err
+	}
+
+	// Links and special files are skipped as they could point outside the server root
+	x.logger.Debug("Skipping archive entry",
+		zap.String("name", name),
+		zap.String("type", kind))
+	return nil
+}
+
+// resolve maps an entry name onto the host below the target directory. Names
+// that climb out of the target are refused outright rather than clamped; an
+// empty result means the entry names the target itself.
+func (x *extractor) resolve(name string) (string, error) {
+	if strings.ContainsRune(name, 0) {
+		return "", fmt.Errorf("%w: archive entry contains a null byte", ErrInvalidPath)
+	}
+
+	// Archives made on Windows use backslashes; leading slashes are dropped like tar does
+	rel := path.Clean(strings.TrimLeft(strings.ReplaceAll(name, "\\", "/"), "/"))
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%w: archive entry %s", ErrPathEscape, name)
+	}
+	if rel == "." {
+		return "", nil
+	}
+
+	// Through the sandbox as well, so symlinks already on disk cannot redirect entries
+	target, err := x.sandbox.Resolve(x.sandbox.Rel(x.target) + "/" + rel)
+	if err != nil {
+		return "", err
+	}
+	if target != x.target && !strings.HasPrefix(target, x.target+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: archive entry %s", ErrPathEscape, name)
+	}
+
+	return target, nil
+}
+
+// extractBudget tracks how much of an archive's limits and the server's disk
+// quota have been used
+type extractBudget struct {
+	remaining  int64 // Bytes the archive limits still allow, negative when unlimited
+	quota      int64 // Bytes the disk quota still allows, negative when unlimited
+	entries    int
+	maxEntries int
+}
+
+// newExtractBudget applies limits to an archive of the given size
+func newExtractBudget(limits ArchiveLimits, archiveSize, quota int64) *extractBudget {
+	remaining := int64(-1)
+	if limits.MaxSize > 0 {
+		remaining = limits.MaxSize
+	}
+	if limits.MaxRatio > 0 {
+		// Guard the multiplication against overflow for huge limits
+		if byRatio := max(archiveSize, 1); byRatio <= (1<<62)/limits.MaxRatio {
+			byRatio *= limits.MaxRatio
+			if remaining < 0 || byRatio < remaining {
+				remaining = byRatio
+			}
+		}
+	}
+
+	return &extractBudget{remaining: remaining, quota: quota, maxEntries: limits.MaxEntries}
+}
+
+// entry counts an archive entry against the entry limit
+func (b *extractBudget) entry() error {
+	b.entries++
+	if b.maxEntries > 0 && b.entries > b.maxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrArchiveTooLarge, b.maxEntries)
+	}
+	return nil
+}
+
+// reader counts what is read from r against the size limit and quota. The
+// declared entry sizes are never trusted, only the bytes that actually come out.
+func (b *extractBudget) reader(r io.Reader) io.Reader {
+	if b.remaining >= 0 {
+		r = &cappedReader{r: r, remaining: &b.remaining, err: fmt.Errorf("%w: uncompressed size limit reached", ErrArchiveTooLarge)}
+	}
+	if b.quota >= 0 {
+		r = &cappedReader{r: r, remaining: &b.quota, err: ErrQuotaExceeded}
+	}
+	return r
+}
+
+// cappedReader fails with err once more than *remaining bytes have been read.
+// The count is shared so one cap can span several streams.
+type cappedReader struct {
+	r         io.Reader
+	remaining *int64
+	err       error
+}
+
+func (r *cappedReader) Read(p []byte) (int, error) {
+	// Read one byte past the cap to tell an exact fit from an overflow
+	if int64(len(p)) > *r.remaining+1 {
+		p = p[:*r.remaining+1]
+	}
+
+	n, err := r.r.Read(p)
+	if int64(n) > *r.remaining {
+		*r.remaining = 0
+		return 0, r.err
+	}
+	*r.remaining -= int64(n)
+	return n, err
+}
+
+// progressTracker accumulates a job's progress and reports it at most once
+// per progressInterval. It is a no-op when the context carries no callback.
+type progressTracker struct {
+	ctx        context.Context
+	fn         ProgressFunc
+	lock       sync.Mutex
+	state      ArchiveProgress
+	lastReport time.Time
+}
+
+// newProgressTracker creates a tracker for a job of total bytes
+func newProgressTracker(ctx context.Context, total int64) *progressTracker {
+	fn, _ := ctx.Value(progressKey{}).(ProgressFunc)
+	return &progressTracker{
+		ctx:   ctx,
+		fn:    fn,
+		state: ArchiveProgress{Total: total},
+	}
+}
+
+// entry records the start of an archive entry
+func (t *progressTracker) entry(name string) {
+	if t.fn == nil {
+		return
+	}
+	t.lock.Lock()
+	t.state.Entries++
+	t.state.Current = name
+	t.lock.Unlock()
+	t.report(false)
+}
+
+// add records n more bytes done
+func (t *progressTracker) add(n int64) {
+	if t.fn == nil {
+		return
+	}
+	t.lock.Lock()
+	t.state.Done += n
+	t.lock.Unlock()
+	t.report(false)
+}
+
+// finish sends the final report
+func (t *progressTracker) finish() {
+	if t.fn == nil {
+		return
+	}
+	t.lock.Lock()
+	t.state.Done = t.state.Total
+	t.state.Current = ""
+	t.state.Finished = true
+	t.lock.Unlock()
+	t.report(true)
+}
+
+// report calls the callback if enough time has passed since the last report
+func (t *progressTracker) report(force bool) {
+	t.lock.Lock()
+	if !force && time.Since(t.lastReport) < progressInterval {
+		t.lock.Unlock()
+		return
+	}
+	t.lastReport = time.Now()
+	state := t.state
+	t.lock.Unlock()
+
+	t.fn(state)
+}
+
+// reader counts bytes read from r as progress and stops on cancellation
+func (t *progressTracker) reader(r io.Reader) io.Reader {
+	return &progressReader{r: r, tracker: t}
+}
+
+// progressReader feeds bytes read into a progress tracker
+type progressReader struct {
+	r       io.Reader
+	tracker *progressTracker
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	if err := r.tracker.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	r.tracker.add(int64(n))
+	return n, err
+}
+
+// cappedWriter fails with err instead of writing past *remaining bytes
+type cappedWriter struct {
+	w         io.Writer
+	remaining *int64
+	err       error
+}
+
+func (w *cappedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > *w.remaining {
+		return 0, w.err
+	}
+	n, err := w.w.Write(p)
+	*w.remaining -= int64(n)
+	return n, err
+}
+
+// nopWriteCloser adds a no-op Close to an uncompressed stream
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,208 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// newTestArchiveRoot builds a small server tree to archive
+func newTestArchiveRoot(t *testing.T) *Sandbox {
+	t.Helper()
+
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"server.properties":       "motd=test",
+		"world/level.dat":         "level",
+		"world/region/r.0.0.mca":  string(bytes.Repeat([]byte("region"), 1000)),
+		"plugins/config/app.yml":  "enabled: true",
+		"plugins/config/empty.md": "",
+	} {
+		target := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(target, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return NewSandbox(root)
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	for _, output := range []string{"backup.zip", "backup.tar", "backup.tar.gz", "backup.tar.xz", "backup.tar.zst", "backup"} {
+		t.Run(output, func(t *testing.T) {
+			sandbox := newTestArchiveRoot(t)
+
+			var reports []ArchiveProgress
+			ctx := WithProgress(context.Background(), func(p ArchiveProgress) {
+				reports = append(reports, p)
+			})
+
+			if _, err := compressArchive(ctx, sandbox, fileOwner{}, []string{"/server.properties", "/world", "/plugins"}, output, -1); err != nil {
+				t.Fatalf("compressArchive: %v", err)
+			}
+			if len(reports) == 0 || !reports[len(reports)-1].Finished {
+				t.Fatalf("compress did not report completion: %+v", reports)
+			}
+
+			if err := extractArchive(context.Background(), sandbox, fileOwner{}, output, "/restored", DefaultArchiveLimits, -1, zap.NewNop()); err != nil {
+				t.Fatalf("extractArchive: %v", err)
+			}
+
+			for _, name := range []string{"server.properties", "world/level.dat", "world/region/r.0.0.mca", "plugins/config/app.yml", "plugins/config/empty.md"} {
+				want, _ := os.ReadFile(filepath.Join(sandbox.Root(), filepath.FromSlash(name)))
+				got, err := os.ReadFile(filepath.Join(sandbox.Root(), "restored", filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatalf("restored %s: %v", name, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("restored %s differs", name)
+				}
+			}
+
+			// The archive must not contain itself
+			if _, err := os.Stat(filepath.Join(sandbox.Root(), "restored", output)); err == nil {
+				t.Fatalf("archive contains itself")
+			}
+		})
+	}
+}
+
+func TestDetectArchiveFormat(t *testing.T) {
+	tarHeader := make([]byte, 512)
+	copy(tarHeader[tarMagicOffset:], "ustar\x0000")
+
+	tests := []struct {
+		name    string
+		header  []byte
+		want    ArchiveFormat
+		wantErr error
+	}{
+		{"zip", []byte("PK\x03\x04rest"), FormatZip, nil},
+		{"empty zip", []byte("PK\x05\x06"), FormatZip, nil},
+		{"gzip", []byte{0x1f, 0x8b, 0x08}, FormatTarGz, nil},
+		{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, FormatTarXz, nil},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, FormatTarZst, nil},
+		{"tar", tarHeader, FormatTar, nil},
+		{"7z", []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}, "", ErrUnsupportedArchive},
+		{"text", []byte("motd=test"), "", ErrUnsupportedArchive},
+		{"empty", nil, "", ErrUnsupportedArchive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DetectArchiveFormat(tt.header)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DetectArchiveFormat error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("DetectArchiveFormat = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractArchiveZipSlip(t *testing.T) {
+	for _, name := range []string{"../evil.txt", "world/../../evil.txt", `..\evil.txt`, "escape/evil.txt"} {
+		t.Run(name, func(t *testing.T) {
+			sandbox := newTestArchiveRoot(t)
+
+			// A symlink already on disk must not redirect entries out of the target
+			if err := os.MkdirAll(filepath.Join(sandbox.Root(), "target"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink("../world", filepath.Join(sandbox.Root(), "target", "escape")); err != nil {
+				t.Fatal(err)
+			}
+
+			writeTestZip(t, filepath.Join(sandbox.Root(), "evil.zip"), map[string]string{name: "pwned"})
+
+			err := extractArchive(context.Background(), sandbox, fileOwner{}, "/evil.zip", "/target", DefaultArchiveLimits, -1, zap.NewNop())
+			if !errors.Is(err, ErrPathEscape) {
+				t.Fatalf("extractArchive error = %v, want %v", err, ErrPathEscape)
+			}
+			for _, leaked := range []string{"evil.txt", "world/evil.txt"} {
+				if _, err := os.Stat(filepath.Join(sandbox.Root(), filepath.FromSlash(leaked))); err == nil {
+					t.Fatalf("entry %q was written to %s", name, leaked)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractArchiveSkipsLinks(t *testing.T) {
+	sandbox := newTestArchiveRoot(t)
+
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	tarWriter.WriteHeader(&tar.Header{Name: "passwd", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"})
+	tarWriter.WriteHeader(&tar.Header{Name: "shadow", Typeflag: tar.TypeLink, Linkname: "/etc/shadow"})
+	tarWriter.Close()
+	if err := os.WriteFile(filepath.Join(sandbox.Root(), "links.tar"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := extractArchive(context.Background(), sandbox, fileOwner{}, "/links.tar", "/", DefaultArchiveLimits, -1, zap.NewNop()); err != nil {
+		t.Fatalf("extractArchive: %v", err)
+	}
+	for _, name := range []string{"passwd", "shadow"} {
+		if _, err := os.Lstat(filepath.Join(sandbox.Root(), name)); err == nil {
+			t.Fatalf("link %s was extracted", name)
+		}
+	}
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		limits ArchiveLimits
+	}{
+		{"size", map[string]string{"big.bin": string(make([]byte, 4096))}, ArchiveLimits{MaxSize: 1024}},
+		{"ratio", map[string]string{"bomb.bin": string(make([]byte, 1<<20))}, ArchiveLimits{MaxRatio: 10}},
+		{"entries", map[string]string{"a": "a", "b": "b", "c": "c"}, ArchiveLimits{MaxEntries: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sandbox := newTestArchiveRoot(t)
+			writeTestZip(t, filepath.Join(sandbox.Root(), "bomb.zip"), tt.files)
+
+			err := extractArchive(context.Background(), sandbox, fileOwner{}, "/bomb.zip", "/out", tt.limits, -1, zap.NewNop())
+			if !errors.Is(err, ErrArchiveTooLarge) {
+				t.Fatalf("extractArchive error = %v, want %v", err, ErrArchiveTooLarge)
+			}
+		})
+	}
+}
+
+// writeTestZip writes a zip archive with raw entry names, as a hostile client could
+func writeTestZip(t *testing.T, target string, entries map[string]string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+	for name, content := range entries {
+		w, err := zipWriter.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(target, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
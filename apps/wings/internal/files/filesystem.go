@@ -0,0 +1,122 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/mambapanel/wings/internal/docker"
+)
+
+// Errors shared by Filesystem implementations
+var (
+	ErrNotRegularFile  = errors.New("path is not a regular file")
+	ErrFileExists      = errors.New("destination already exists")
+	ErrOwnerNotAllowed = errors.New("files may only be owned by the server's container user")
+)
+
+// ConflictPolicy decides what Copy does when the destination already exists
+type ConflictPolicy string
+
+const (
+	ConflictFail      ConflictPolicy = "fail"      // Refuse the copy
+	ConflictOverwrite ConflictPolicy = "overwrite" // Merge directories, replacing existing files
+	ConflictSkip      ConflictPolicy = "skip"      // Merge directories, keeping existing files
+	ConflictRename    ConflictPolicy = "rename"    // Copy alongside under a new name, e.g. "config copy.yml"
+)
+
+// Validate checks the policy is known, treating empty as ConflictFail
+func (p ConflictPolicy) Validate() error {
+	switch p {
+	case "", ConflictFail, ConflictOverwrite, ConflictSkip, ConflictRename:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown conflict policy %q", ErrInvalidPath, p)
+}
+
+// Filesystem is the set of file operations available on a server's files.
+// Manager runs them inside the server's container while HostManager works on
+// the server's bind-mounted data directory, so it does not depend on the
+// container running or on the tools its image ships with.
+//
+// The id passed to each method identifies the server; since containers are
+// named after their server, Manager accepts it as a container reference.
+type Filesystem interface {
+	ListFiles(ctx context.Context, id, path string) ([]FileInfo, error)
+	ReadFile(ctx context.Context, id, filePath string) ([]byte, error)
+	WriteFile(ctx context.Context, id, filePath string, content []byte) error
+	// ReadFileStream opens a file for reading along with its size; the caller must close it
+	ReadFileStream(ctx context.Context, id, filePath string) (io.ReadCloser, int64, error)
+	// WriteFileStream writes size bytes from r to a file, or until EOF when size is negative
+	WriteFileStream(ctx context.Context, id, filePath string, r io.Reader, size int64) error
+	// WriteFileIfMatch replaces a file like WriteFileStream, but fails with
+	// ErrFileChanged unless the file is still at version, and returns the
+	// version it was written as. An empty version writes unconditionally.
+	WriteFileIfMatch(ctx context.Context, id, filePath string, r io.Reader, size int64, version string) (string, error)
+	// FileVersion returns an opaque version of a file's contents, for use as an ETag
+	FileVersion(ctx context.Context, id, filePath string) (string, error)
+	DeleteFile(ctx context.Context, id, path string) error
+	CreateDirectory(ctx context.Context, id, path string) error
+	CompressFiles(ctx context.Context, id string, paths []string, outputPath string) error
+	ExtractArchive(ctx context.Context, id, archivePath, targetPath string) error
+	GetFileSize(ctx context.Context, id, filePath string) (int64, error)
+	// Rename gives a file or directory a new name in the same directory
+	Rename(ctx context.Context, id, filePath, newName string) error
+	// Move moves a file or directory to a new path, which must not exist
+	Move(ctx context.Context, id, from, to string) error
+	// Copy recursively copies a file or directory, resolving conflicts by policy
+	Copy(ctx context.Context, id, from, to string, policy ConflictPolicy) error
+	// Chmod sets a path's permission bits; other mode bits are ignored
+	Chmod(ctx context.Context, id, filePath string, mode os.FileMode) error
+	// Chown changes the owner of a path and, for a directory, everything in
+	// it. Only the server's container user may be given its files.
+	Chown(ctx context.Context, id, filePath string, uid, gid int) error
+	// Search walks a directory tree, passing each match to emit as it is found
+	Search(ctx context.Context, id string, opts SearchOptions, emit func(SearchResult) error) error
+}
+
+var (
+	_ Filesystem = (*Manager)(nil)
+	_ Filesystem = (*HostManager)(nil)
+)
+
+// checkOwner allows uid and gid as a new owner only if they are the server's
+// container user, as returned by a lookup that failed with lookupErr
+func checkOwner(uid, gid, userUID, userGID int, lookupErr error) error {
+	if errors.Is(lookupErr, docker.ErrUserNotConfigured) {
+		return fmt.Errorf("%w: %v", ErrOwnerNotAllowed, lookupErr)
+	}
+	if lookupErr != nil {
+		return fmt.Errorf("failed to look up container user: %w", lookupErr)
+	}
+	if uid != userUID || gid != userGID {
+		return fmt.Errorf("%w: %d:%d is not %d:%d", ErrOwnerNotAllowed, uid, gid, userUID, userGID)
+	}
+	return nil
+}
+
+// renameTarget validates a new name and returns the path it gives filePath
+func renameTarget(filePath, newName string) (string, error) {
+	if newName == "" || newName == "." || newName == ".." || strings.ContainsAny(newName, "/\\\x00") {
+		return "", fmt.Errorf("%w: invalid name %q", ErrInvalidPath, newName)
+	}
+	return path.Join(path.Dir(cleanPath(filePath)), newName), nil
+}
+
+// copyName returns the n-th alternative name used by ConflictRename
+func copyName(name string, n int) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if base == "" {
+		// Dotfiles such as ".env" have no extension to keep
+		base, ext = name, ""
+	}
+	if n == 1 {
+		return base + " copy" + ext
+	}
+	return fmt.Sprintf("%s copy %d%s", base, n, ext)
+}
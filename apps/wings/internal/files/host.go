@@ -0,0 +1,720 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mambapanel/wings/internal/docker"
+	"go.uber.org/zap"
+)
+
+// HostManager handles file operations directly on a server's data directory
+// on the host. Paths are relative to the server's root, with "/" being the
+// root of the data directory. Writes that grow a server's data are checked
+// against its disk quota first.
+type HostManager struct {
+	dataDir       string
+	archiveLimits ArchiveLimits
+	quota         *DiskQuota // nil to skip quota enforcement
+	owner         OwnerFunc  // nil to refuse ownership changes
+	writeLocks    pathLocks
+	logger        *zap.Logger
+}
+
+// OwnerFunc looks up the uid and gid a server's container runs as
+type OwnerFunc func(serverID string) (uid, gid int, err error)
+
+// NewHostManager creates a new host file manager rooted at the servers' data directory
+func NewHostManager(dataDir string, quota *DiskQuota, owner OwnerFunc, logger *zap.Logger) *HostManager {
+	return &HostManager{
+		dataDir:       dataDir,
+		archiveLimits: DefaultArchiveLimits,
+		quota:         quota,
+		owner:         owner,
+		logger:        logger,
+	}
+}
+
+// ListFiles lists files in a server directory
+func (m *HostManager) ListFiles(ctx context.Context, serverID, dirPath string) ([]FileInfo, error) {
+	m.logger.Debug("Listing files",
+		zap.String("serverID", serverID),
+		zap.String("path", dirPath))
+
+	fullPath, err := m.resolve(serverID, dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	files := make([]FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// The entry was removed while listing
+			continue
+		}
+
+		files = append(files, newFileInfo(info, path.Join(cleanPath(dirPath), entry.Name())))
+	}
+
+	return files, nil
+}
+
+// ReadFile reads the contents of a file
+func (m *HostManager) ReadFile(ctx context.Context, serverID, filePath string) ([]byte, error) {
+	m.logger.Debug("Reading file",
+		zap.String("serverID", serverID),
+		zap.String("path", filePath))
+
+	fullPath, err := m.resolve(serverID, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, ErrNotRegularFile
+	}
+
+	return os.ReadFile(fullPath)
+}
+
+// ReadFileStream opens a file for streaming
+func (m *HostManager) ReadFileStream(ctx context.Context, serverID, filePath string) (io.ReadCloser, int64, error) {
+	m.logger.Debug("Streaming file",
+		zap.String("serverID", serverID),
+		zap.String("path", filePath))
+
+	fullPath, err := m.resolve(serverID, filePath)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, 0, ErrNotRegularFile
+	}
+
+	return file, info.Size(), nil
+}
+
+// WriteFile writes content to a file, replacing it atomically
+func (m *HostManager) WriteFile(ctx context.Context, serverID, filePath string, content []byte) error {
+	return m.WriteFileStream(ctx, serverID, filePath, bytes.NewReader(content), int64(len(content)))
+}
+
+// WriteFileStream streams content into a file, creating parent directories as
+// needed. The file is only replaced once the whole stream has been written.
+func (m *HostManager) WriteFileStream(ctx context.Context, serverID, filePath string, r io.Reader, size int64) error {
+	_, err := m.WriteFileIfMatch(ctx, serverID, filePath, r, size, "")
+	return err
+}
+
+// WriteFileIfMatch streams content into a file like WriteFileStream, but only
+// if the file is still at version, and returns the version it now has. An
+// empty version writes unconditionally.
+func (m *HostManager) WriteFileIfMatch(ctx context.Context, serverID, filePath string, r io.Reader, size int64, version string) (string, error) {
+	m.logger.Debug("Writing file",
+		zap.String("serverID", serverID),
+		zap.String("path", filePath),
+		zap.Int64("size", size),
+		zap.Bool("conditional", version != ""))
+
+	fullPath, err := m.resolve(serverID, filePath)
+	if err != nil {
+		return "", err
+	}
+
+	unlock := m.writeLocks.lock(fullPath)
+	defer unlock()
+
+	if info, err := os.Stat(fullPath); err == nil && !info.Mode().IsRegular() {
+		return "", ErrNotRegularFile
+	}
+	if err := checkVersion(fullPath, version); err != nil {
+		return "", err
+	}
+	owner, err := m.fileOwner(serverID)
+	if err != nil {
+		return "", err
+	}
+
+	// The file being replaced frees its space, so it does not count against the write
+	existing := fileSize(fullPath)
+	if size >= 0 {
+		release, err := m.quota.Reserve(serverID, size-existing)
+		if err != nil {
+			return "", err
+		}
+		defer release()
+		r = io.LimitReader(r, size)
+	} else if remaining := m.quota.Remaining(serverID); remaining >= 0 {
+		// Streams of unknown size are cut off once they reach the quota
+		r = limitToQuota(r, remaining+existing)
+	}
+
+	written, err := writeAtomic(fullPath, r, size, owner)
+	if err != nil {
+		return "", err
+	}
+	m.quota.Add(serverID, written-existing)
+
+	return fileVersion(fullPath)
+}
+
+// FileVersion returns a file's current version, for use as an ETag
+func (m *HostManager) FileVersion(ctx context.Context, serverID, filePath string) (string, error) {
+	fullPath, err := m.resolve(serverID, filePath)
+	if err != nil {
+		return "", err
+	}
+	return fileVersion(fullPath)
+}
+
+// DeleteFile deletes a file or directory
+func (m *HostManager) DeleteFile(ctx context.Context, serverID, filePath string) error {
+	m.logger.Info("Deleting file",
+		zap.String("serverID", serverID),
+		zap.String("path", filePath))
+
+	sandbox, err := m.sandbox(serverID)
+	if err != nil {
+		return err
+	}
+
+	// A symlink is deleted itself rather than whatever it points to
+	fullPath, err := sandbox.ResolveNoFollow(filePath)
+	if err != nil {
+		return err
+	}
+	if fullPath == sandbox.Root() {
+		return fmt.Errorf("%w: cannot delete the server root", ErrInvalidPath)
+	}
+
+	// Measured first so the freed space counts straight away. Whatever a
+	// failed delete removed is measured by walking the server again.
+	size, sizeErr := treeSize(ctx, []string{fullPath})
+
+	if err := os.RemoveAll(fullPath); err != nil {
+		m.quota.Invalidate(serverID)
+		return fmt.Errorf("failed to delete: %w", err)
+	}
+
+	if sizeErr != nil {
+		m.quota.Invalidate(serverID)
+	} else {
+		m.quota.Add(serverID, -size)
+	}
+	return nil
+}
+
+// CreateDirectory creates a directory and any missing parents
+func (m *HostManager) CreateDirectory(ctx context.Context, serverID, dirPath string) error {
+	m.logger.Info("Creating directory",
+		zap.String("serverID", serverID),
+		zap.String("path", dirPath))
+
+	fullPath, err := m.resolve(serverID, dirPath)
+	if err != nil {
+		return err
+	}
+	owner, err := m.fileOwner(serverID)
+	if err != nil {
+		return err
+	}
+
+	if err := owner.mkdirAll(fullPath, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	return nil
+}
+
+// CompressFiles archives files into outputPath. The format follows the
+// output's extension: zip, tar, tar.gz, tar.xz or tar.zst, defaulting to tar.gz.
+func (m *HostManager) CompressFiles(ctx context.Context, serverID string, paths []string, outputPath string) error {
+	m.logger.Info("Compressing files",
+		zap.String("serverID", serverID),
+		zap.Strings("paths", paths),
+		zap.String("output", outputPath))
+
+	sandbox, err := m.sandbox(serverID)
+	if err != nil {
+		return err
+	}
+
+	owner, err := m.fileOwner(serverID)
+	if err != nil {
+		return err
+	}
+
+	growth, err := compressArchive(ctx, sandbox, owner, paths, outputPath, m.quota.Remaining(serverID))
+	if err != nil {
+		return err
+	}
+
+	m.quota.Add(serverID, growth)
+	return nil
+}
+
+// ExtractArchive extracts a zip, tar, tar.gz, tar.xz or tar.zst archive into
+// a directory, detecting the format from the archive's contents
+func (m *HostManager) ExtractArchive(ctx context.Context, serverID, archivePath, targetPath string) error {
+	m.logger.Info("Extracting archive",
+		zap.String("serverID", serverID),
+		zap.String("archive", archivePath),
+		zap.String("target", targetPath))
+
+	sandbox, err := m.sandbox(serverID)
+	if err != nil {
+		return err
+	}
+
+	owner, err := m.fileOwner(serverID)
+	if err != nil {
+		return err
+	}
+
+	// Entries may replace existing files, so usage is measured again afterwards
+	defer m.quota.Invalidate(serverID)
+
+	return extractArchive(ctx, sandbox, owner, archivePath, targetPath, m.archiveLimits, m.quota.Remaining(serverID), m.logger)
+}
+
+// GetFileSize gets the size of a file
+func (m *HostManager) GetFileSize(ctx context.Context, serverID, filePath string) (int64, error) {
+	fullPath, err := m.resolve(serverID, filePath)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	return info.Size(), nil
+}
+
+// Rename gives a file or directory a new name in the same directory
+func (m *HostManager) Rename(ctx context.Context, serverID, filePath, newName string) error {
+	target, err := renameTarget(filePath, newName)
+	if err != nil {
+		return err
+	}
+	return m.Move(ctx, serverID, filePath, target)
+}
+
+// Move moves a file or directory to a new path, creating parent directories as needed
+func (m *HostManager) Move(ctx context.Context, serverID, from, to string) error {
+	m.logger.Info("Moving file",
+		zap.String("serverID", serverID),
+		zap.String("from", from),
+		zap.String("to", to))
+
+	sandbox, err := m.sandbox(serverID)
+	if err != nil {
+		return err
+	}
+
+	// Symlinks are moved themselves rather than their targets
+	source, target, err := transferPaths(sandbox, from, to)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("%w: %s", ErrFileExists, to)
+	}
+
+	owner, err := m.fileOwner(serverID)
+	if err != nil {
+		return err
+	}
+	if err := owner.mkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+
+	if err := os.Rename(source, target); err != nil {
+		return fmt.Errorf("failed to move: %w", err)
+	}
+
+	return nil
+}
+
+// Copy recursively copies a file or directory. Symlinks are copied as links,
+// refusing any that would point outside the root from their new place, and
+// special files are skipped.
+func (m *HostManager) Copy(ctx context.Context, serverID, from, to string, policy ConflictPolicy) error {
+	m.logger.Info("Copying file",
+		zap.String("serverID", serverID),
+		zap.String("from", from),
+		zap.String("to", to),
+		zap.String("conflict", string(policy)))
+
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	sandbox, err := m.sandbox(serverID)
+	if err != nil {
+		return err
+	}
+
+	source, target, err := transferPaths(sandbox, from, to)
+	if err != nil {
+		return err
+	}
+
+	if target, err = copyTarget(source, target, policy); err != nil {
+		return err
+	}
+	owner, err := m.fileOwner(serverID)
+	if err != nil {
+		return err
+	}
+
+	// Merging may replace or keep files, so the whole source is checked against the quota
+	size, err := treeSize(ctx, []string{source})
+	if err != nil {
+		return fmt.Errorf("failed to measure source: %w", err)
+	}
+	release, err := m.quota.Reserve(serverID, size)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// The files kept or replaced make the real growth smaller, so it is measured afterwards
+	defer m.quota.Invalidate(serverID)
+
+	if err := owner.mkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+
+	if err := copyTree(ctx, sandbox, owner, source, target, policy == ConflictOverwrite); err != nil {
+		return err
+	}
+	m.quota.Add(serverID, size)
+	return nil
+}
+
+// Chmod sets a path's permission bits
+func (m *HostManager) Chmod(ctx context.Context, serverID, filePath string, mode os.FileMode) error {
+	m.logger.Info("Changing file mode",
+		zap.String("serverID", serverID),
+		zap.String("path", filePath),
+		zap.String("mode", mode.Perm().String()))
+
+	fullPath, err := m.resolve(serverID, filePath)
+	if err != nil {
+		return err
+	}
+
+	// Only permission bits; setuid and friends have no place in server files
+	if err := os.Chmod(fullPath, mode.Perm()); err != nil {
+		return fmt.Errorf("failed to change mode: %w", err)
+	}
+
+	return nil
+}
+
+// Chown changes the owner of a path and everything under it. Symlinks have
+// their own ownership changed and are never followed.
+func (m *HostManager) Chown(ctx context.Context, serverID, filePath string, uid, gid int) error {
+	m.logger.Info("Changing file owner",
+		zap.String("serverID", serverID),
+		zap.String("path", filePath),
+		zap.Int("uid", uid),
+		zap.Int("gid", gid))
+
+	fullPath, err := m.resolve(serverID, filePath)
+	if err != nil {
+		return err
+	}
+
+	// Never root or another server's user, which would reach outside the container
+	userUID, userGID, lookupErr := 0, 0, docker.ErrUserNotConfigured
+	if m.owner != nil {
+		userUID, userGID, lookupErr = m.owner(serverID)
+	}
+	if err := checkOwner(uid, gid, userUID, userGID, lookupErr); err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(fullPath, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := os.Lchown(p, uid, gid); err != nil {
+			return fmt.Errorf("failed to change owner: %w", err)
+		}
+		return nil
+	})
+}
+
+// Search walks a server's files by name and optionally content, streaming
+// each result to emit. Symlinks are not followed.
+func (m *HostManager) Search(ctx context.Context, serverID string, opts SearchOptions, emit func(SearchResult) error) error {
+	m.logger.Debug("Searching files",
+		zap.String("serverID", serverID),
+		zap.String("path", opts.Path),
+		zap.String("name", opts.Name),
+		zap.Bool("content", opts.Content != ""))
+
+	sandbox, err := m.sandbox(serverID)
+	if err != nil {
+		return err
+	}
+
+	return searchTree(ctx, sandbox, opts, emit)
+}
+
+// transferPaths resolves both ends of a move or copy without following the
+// final components, refusing the server root and copies into themselves
+func transferPaths(sandbox *Sandbox, from, to string) (string, string, error) {
+	source, err := sandbox.ResolveNoFollow(from)
+	if err != nil {
+		return "", "", err
+	}
+	target, err := sandbox.ResolveNoFollow(to)
+	if err != nil {
+		return "", "", err
+	}
+
+	if source == sandbox.Root() || target == sandbox.Root() {
+		return "", "", fmt.Errorf("%w: cannot move or copy the server root", ErrInvalidPath)
+	}
+	if strings.HasPrefix(target, source+string(filepath.Separator)) {
+		return "", "", fmt.Errorf("%w: destination is inside the source", ErrInvalidPath)
+	}
+
+	return source, target, nil
+}
+
+// sandbox returns the path sandbox for a server's data directory
+func (m *HostManager) sandbox(serverID string) (*Sandbox, error) {
+	if !docker.ValidServerID(serverID) {
+		return nil, fmt.Errorf("%w: invalid server ID %s", ErrInvalidPath, serverID)
+	}
+	return NewSandbox(filepath.Join(m.dataDir, serverID)), nil
+}
+
+// resolve maps a server-relative path onto the host, confined to the server root
+func (m *HostManager) resolve(serverID, p string) (string, error) {
+	sandbox, err := m.sandbox(serverID)
+	if err != nil {
+		return "", err
+	}
+	return sandbox.Resolve(p)
+}
+
+// fileOwner returns who the files created for a server belong to
+func (m *HostManager) fileOwner(serverID string) (fileOwner, error) {
+	if m.owner == nil {
+		return fileOwner{}, nil
+	}
+	return newFileOwner(m.owner(serverID))
+}
+
+// cleanPath normalizes a client path to an absolute, slash-separated path
+func cleanPath(p string) string {
+	return path.Clean("/" + strings.ReplaceAll(p, "\\", "/"))
+}
+
+// newFileInfo builds a FileInfo from os file info
+func newFileInfo(info fs.FileInfo, relPath string) FileInfo {
+	fileInfo := FileInfo{
+		Name:    info.Name(),
+		Path:    relPath,
+		Size:    info.Size(),
+		IsDir:   info.IsDir(),
+		Mode:    info.Mode().String(),
+		ModTime: info.ModTime().UTC().Format(time.RFC3339),
+	}
+
+	if !info.IsDir() {
+		fileInfo.Extension = filepath.Ext(info.Name())
+	}
+
+	return fileInfo
+}
+
+// writeFromReader streams a reader into a file, creating parent directories.
+// What it creates is given to owner.
+func writeFromReader(target string, reader io.Reader, mode os.FileMode, owner fileOwner) (int64, error) {
+	if err := owner.mkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create parent directory: %w", err)
+	}
+
+	if mode == 0 {
+		mode = 0644
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
+	if err := owner.chown(target); err != nil {
+		file.Close()
+		return 0, err
+	}
+
+	written, err := io.Copy(file, reader)
+	if err != nil {
+		file.Close()
+		return written, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return written, file.Close()
+}
+
+// fileSize returns the size of an existing regular file, or 0
+func fileSize(p string) int64 {
+	if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() {
+		return info.Size()
+	}
+	return 0
+}
+
+// copyTarget applies a conflict policy to a copy's destination, returning where to copy to
+func copyTarget(source, target string, policy ConflictPolicy) (string, error) {
+	if _, err := os.Lstat(source); err != nil {
+		return "", fmt.Errorf("failed to stat source: %w", err)
+	}
+
+	if _, err := os.Lstat(target); err != nil {
+		return target, nil
+	}
+
+	switch policy {
+	case ConflictOverwrite, ConflictSkip:
+		// Merged by the copy, unless that would copy a path onto itself
+		if source == target {
+			return "", fmt.Errorf("%w: %s", ErrFileExists, filepath.Base(target))
+		}
+		return target, nil
+	case ConflictRename:
+		return freeName(target)
+	default:
+		return "", fmt.Errorf("%w: %s", ErrFileExists, filepath.Base(target))
+	}
+}
+
+// copyTree copies src to dst recursively, giving the copies to owner. Existing
+// files are replaced when overwrite is set and kept otherwise; existing
+// directories are merged into.
+func copyTree(ctx context.Context, sandbox *Sandbox, owner fileOwner, src, dst string, overwrite bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(src)
+	if err != nil {
+		return fmt.Errorf("failed to stat source: %w", err)
+	}
+
+	existing, err := os.Lstat(dst)
+	exists := err == nil
+
+	// Directories merge into directories; any other clash is settled by overwrite
+	if exists && !(info.IsDir() && existing.IsDir()) {
+		if !overwrite {
+			return nil
+		}
+		if err := os.RemoveAll(dst); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", dst, err)
+		}
+	}
+
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return fmt.Errorf("failed to read symlink: %w", err)
+		}
+		// A relative link means something else from its new place, so it
+		// is only copied if it still points inside the root
+		resolved := target
+		if !filepath.IsAbs(resolved) {
+			resolved = filepath.Join(filepath.Dir(dst), resolved)
+		}
+		if _, ok := sandbox.within(filepath.Clean(resolved)); !ok {
+			return fmt.Errorf("%w: %s -> %s", ErrSymlinkEscape, sandbox.Rel(src), target)
+		}
+		if err := os.Symlink(target, dst); err != nil {
+			return fmt.Errorf("failed to create symlink: %w", err)
+		}
+		if err := owner.chown(dst); err != nil {
+			return err
+		}
+	case info.IsDir():
+		if err := owner.mkdirAll(dst, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return fmt.Errorf("failed to read directory: %w", err)
+		}
+		for _, entry := range entries {
+			if err := copyTree(ctx, sandbox, owner, filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()), overwrite); err != nil {
+				return err
+			}
+		}
+	case info.Mode().IsRegular():
+		file, err := os.Open(src)
+		if err != nil {
+			return fmt.Errorf("failed to open source: %w", err)
+		}
+		defer file.Close()
+
+		if _, err := writeFromReader(dst, file, info.Mode().Perm(), owner); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// freeName finds the first ConflictRename alternative for a path that does not exist yet
+func freeName(target string) (string, error) {
+	dir, name := filepath.Split(target)
+	for n := 1; n < 1000; n++ {
+		candidate := filepath.Join(dir, copyName(name, n))
+		if _, err := os.Lstat(candidate); errors.Is(err, fs.ErrNotExist) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no free name for %s", ErrFileExists, name)
+}
@@ -0,0 +1,302 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/mambapanel/wings/internal/docker"
+	"go.uber.org/zap"
+)
+
+// writeTestFiles creates files with the given contents below root
+func writeTestFiles(t *testing.T, root string, contents map[string]string) {
+	t.Helper()
+
+	for name, content := range contents {
+		full := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTestFile returns a file's contents, or "" if it does not exist
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestHostManagerRenameAndMove(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestQuotaManager(t, 0)
+	root := filepath.Join(m.dataDir, "srv")
+	writeTestFiles(t, root, map[string]string{"a.txt": "a", "b.txt": "b"})
+
+	if err := m.Rename(ctx, "srv", "/a.txt", "renamed.txt"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if got := readTestFile(t, filepath.Join(root, "renamed.txt")); got != "a" {
+		t.Fatalf("renamed file = %q, want %q", got, "a")
+	}
+
+	// Names cannot smuggle in another directory
+	for _, name := range []string{"", "..", "sub/name.txt"} {
+		if err := m.Rename(ctx, "srv", "/renamed.txt", name); !errors.Is(err, ErrInvalidPath) {
+			t.Fatalf("Rename to %q error = %v, want %v", name, err, ErrInvalidPath)
+		}
+	}
+
+	// Moves create missing parents but never replace what is there
+	if err := m.Move(ctx, "srv", "/renamed.txt", "/nested/dir/a.txt"); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	if got := readTestFile(t, filepath.Join(root, "nested", "dir", "a.txt")); got != "a" {
+		t.Fatalf("moved file = %q, want %q", got, "a")
+	}
+	if err := m.Move(ctx, "srv", "/b.txt", "/nested/dir/a.txt"); !errors.Is(err, ErrFileExists) {
+		t.Fatalf("Move onto existing file error = %v, want %v", err, ErrFileExists)
+	}
+	if err := m.Move(ctx, "srv", "/b.txt", "/../escaped.txt"); err == nil {
+		t.Fatalf("Move outside the root succeeded")
+	}
+	if err := m.Move(ctx, "srv", "/nested", "/nested/dir/inner"); err == nil {
+		t.Fatalf("Move of a directory into itself succeeded")
+	}
+}
+
+func TestHostManagerCopy(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		name   string
+		policy ConflictPolicy
+		want   map[string]string // Path below the root -> contents, "" for missing
+		err    error
+	}{
+		{
+			name:   "fail",
+			policy: ConflictFail,
+			want:   map[string]string{"dst/a.txt": "old", "dst/b.txt": ""},
+			err:    ErrFileExists,
+		},
+		{
+			name:   "overwrite",
+			policy: ConflictOverwrite,
+			want:   map[string]string{"dst/a.txt": "new", "dst/b.txt": "b", "dst/keep.txt": "keep"},
+		},
+		{
+			name:   "skip",
+			policy: ConflictSkip,
+			want:   map[string]string{"dst/a.txt": "old", "dst/b.txt": "b", "dst/keep.txt": "keep"},
+		},
+		{
+			name:   "rename",
+			policy: ConflictRename,
+			want:   map[string]string{"dst/a.txt": "old", "dst copy/a.txt": "new", "dst copy/keep.txt": ""},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestQuotaManager(t, 0)
+			root := filepath.Join(m.dataDir, "srv")
+			writeTestFiles(t, root, map[string]string{
+				"src/a.txt":    "new",
+				"src/b.txt":    "b",
+				"dst/a.txt":    "old",
+				"dst/keep.txt": "keep",
+			})
+
+			err := m.Copy(ctx, "srv", "/src", "/dst", tt.policy)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Copy error = %v, want %v", err, tt.err)
+			}
+			for name, want := range tt.want {
+				if got := readTestFile(t, filepath.Join(root, name)); got != want {
+					t.Fatalf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHostManagerCopySymlinks(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestQuotaManager(t, 0)
+	root := filepath.Join(m.dataDir, "srv")
+	writeTestFiles(t, root, map[string]string{"world/level.dat": "level", "config.yml": "config"})
+
+	for link, target := range map[string]string{
+		"world/config.yml": "../config.yml",
+		"world/parent":     "..",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// One level deeper, the links still point inside the root and are kept
+	if err := m.Copy(ctx, "srv", "/world", "/backups/world", ConflictFail); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if got := readTestFile(t, filepath.Join(root, "backups", "world", "config.yml")); got != "" {
+		t.Fatalf("copied link resolves to %q, want nothing at backups/config.yml", got)
+	}
+	if target, err := os.Readlink(filepath.Join(root, "backups", "world", "config.yml")); err != nil || target != "../config.yml" {
+		t.Fatalf("copied link = %q, %v, want ../config.yml", target, err)
+	}
+
+	// At the top of the root, ".." would point at the data directory
+	if err := m.Copy(ctx, "srv", "/world/parent", "/parent", ConflictFail); !errors.Is(err, ErrSymlinkEscape) {
+		t.Fatalf("Copy of escaping link error = %v, want %v", err, ErrSymlinkEscape)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "parent")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("escaping link was created")
+	}
+
+	// Absolute links are kept only when they point inside the root
+	if err := os.Symlink("/etc/passwd", filepath.Join(root, "passwd")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Copy(ctx, "srv", "/passwd", "/passwd-copy", ConflictFail); !errors.Is(err, ErrSymlinkEscape) {
+		t.Fatalf("Copy of absolute link error = %v, want %v", err, ErrSymlinkEscape)
+	}
+}
+
+func TestHostManagerChmod(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestQuotaManager(t, 0)
+	root := filepath.Join(m.dataDir, "srv")
+	writeTestFiles(t, root, map[string]string{"start.sh": "#!/bin/sh"})
+
+	// Only permission bits are applied
+	if err := m.Chmod(ctx, "srv", "/start.sh", os.ModeSetuid|0750); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(root, "start.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0750 {
+		t.Fatalf("mode = %s, want %s", info.Mode(), os.FileMode(0750))
+	}
+
+	if err := m.Chmod(ctx, "srv", "/../srv", 0777); err == nil {
+		t.Fatalf("Chmod outside the root succeeded")
+	}
+}
+
+func TestHostManagerChown(t *testing.T) {
+	ctx := context.Background()
+	uid, gid := os.Getuid(), os.Getgid()
+
+	dataDir := t.TempDir()
+	root := filepath.Join(dataDir, "srv")
+	writeTestFiles(t, root, map[string]string{"world/level.dat": "level"})
+
+	m := NewHostManager(dataDir, nil, func(serverID string) (int, int, error) {
+		if serverID != "srv" {
+			return 0, 0, docker.ErrUserNotConfigured
+		}
+		return uid, gid, nil
+	}, zap.NewNop())
+
+	if err := m.Chown(ctx, "srv", "/world", uid, gid); err != nil {
+		t.Fatalf("Chown to the container user: %v", err)
+	}
+
+	// Root and any other user are refused
+	refused := [][2]int{{uid + 1, gid}, {uid, gid + 1}}
+	if uid != 0 {
+		refused = append(refused, [2]int{0, 0})
+	}
+	for _, owner := range refused {
+		if err := m.Chown(ctx, "srv", "/world", owner[0], owner[1]); !errors.Is(err, ErrOwnerNotAllowed) {
+			t.Fatalf("Chown to %d:%d error = %v, want %v", owner[0], owner[1], err, ErrOwnerNotAllowed)
+		}
+	}
+
+	// Without a configured user, ownership cannot change at all
+	if err := os.MkdirAll(filepath.Join(dataDir, "other"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Chown(ctx, "other", "/", uid, gid); !errors.Is(err, ErrOwnerNotAllowed) {
+		t.Fatalf("Chown without a container user error = %v, want %v", err, ErrOwnerNotAllowed)
+	}
+	unconfigured := NewHostManager(dataDir, nil, nil, zap.NewNop())
+	if err := unconfigured.Chown(ctx, "srv", "/world", uid, gid); !errors.Is(err, ErrOwnerNotAllowed) {
+		t.Fatalf("Chown without an owner lookup error = %v, want %v", err, ErrOwnerNotAllowed)
+	}
+}
+
+func TestHostManagerCreatesFilesAsContainerUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("giving files to another user needs root")
+	}
+	ctx := context.Background()
+	const uid, gid = 1234, 5678
+
+	dataDir := t.TempDir()
+	root := filepath.Join(dataDir, "srv")
+	writeTestFiles(t, root, map[string]string{"world/level.dat": "level"})
+
+	m := NewHostManager(dataDir, nil, func(serverID string) (int, int, error) {
+		return uid, gid, nil
+	}, zap.NewNop())
+
+	if err := m.WriteFile(ctx, "srv", "/config/new/server.properties", []byte("motd=hi")); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := m.CreateDirectory(ctx, "srv", "/plugins/data"); err != nil {
+		t.Fatalf("CreateDirectory: %v", err)
+	}
+	if err := m.Copy(ctx, "srv", "/world", "/backups/world", ConflictFail); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if err := m.CompressFiles(ctx, "srv", []string{"/world"}, "/archives/world.tar.gz"); err != nil {
+		t.Fatalf("CompressFiles: %v", err)
+	}
+	if err := m.ExtractArchive(ctx, "srv", "/archives/world.tar.gz", "/restored"); err != nil {
+		t.Fatalf("ExtractArchive: %v", err)
+	}
+
+	// Every file and directory created belongs to the container user
+	for _, name := range []string{
+		"config", "config/new", "config/new/server.properties",
+		"plugins", "plugins/data",
+		"backups", "backups/world", "backups/world/level.dat",
+		"archives", "archives/world.tar.gz",
+		"restored", "restored/world", "restored/world/level.dat",
+	} {
+		info, err := os.Lstat(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		if stat.Uid != uid || stat.Gid != gid {
+			t.Fatalf("%s owned by %d:%d, want %d:%d", name, stat.Uid, stat.Gid, uid, gid)
+		}
+	}
+
+	// What was already there keeps its owner
+	info, err := os.Stat(filepath.Join(root, "world", "level.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat := info.Sys().(*syscall.Stat_t); stat.Uid == uid {
+		t.Fatalf("existing file was given to the container user")
+	}
+}
@@ -7,12 +7,15 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/mambapanel/wings/internal/docker"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"go.uber.org/zap"
 )
 
@@ -27,16 +30,21 @@ type FileInfo struct {
 	Extension string `json:"extension,omitempty"`
 }
 
-// Manager handles file operations for containers
+// Manager handles file operations for containers. Paths are relative to the
+// server's data directory; they are resolved against its host bind mount so
+// symlinks are checked before anything runs inside the container.
 type Manager struct {
 	dockerClient *client.Client
+	dataDir      string
+	writeLocks   pathLocks
 	logger       *zap.Logger
 }
 
 // NewManager creates a new file manager
-func NewManager(dockerClient *client.Client, logger *zap.Logger) *Manager {
+func NewManager(dockerClient *client.Client, dataDir string, logger *zap.Logger) *Manager {
 	return &Manager{
 		dockerClient: dockerClient,
+		dataDir:      dataDir,
 		logger:       logger,
 	}
 }
@@ -44,235 +52,267 @@ func NewManager(dockerClient *client.Client, logger *zap.Logger) *Manager {
 // ListFiles lists files in a container directory
 func (m *Manager) ListFiles(ctx context.Context, containerID, path string) ([]FileInfo, error) {
 	m.logger.Debug("Listing files",
-		zap.String("containerID", containerID[:12]),
+		zap.String("containerID", containerID),
 		zap.String("path", path))
 
-	// Normalize path
-	if path == "" {
-		path = "/"
+	target, err := m.containerPath(containerID, path, true)
+	if err != nil {
+		return nil, err
 	}
 
 	// Execute ls command in container
-	execConfig := types.ExecConfig{
-		AttachStdout: true,
-		AttachStderr: true,
-		Cmd:          []string{"/bin/sh", "-c", fmt.Sprintf("ls -la %s", path)},
-	}
-
-	execID, err := m.dockerClient.ContainerExecCreate(ctx, containerID, execConfig)
+	output, err := m.exec(ctx, containerID, "ls", "-la", "--", target)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create exec: %w", err)
+		return nil, err
 	}
 
-	attachResp, err := m.dockerClient.ContainerExecAttach(ctx, execID.ID, types.ExecStartCheck{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to attach to exec: %w", err)
-	}
-	defer attachResp.Close()
+	// Parse ls output
+	files := parseLSOutput(string(output), cleanPath(path))
 
-	// Start exec
-	if err := m.dockerClient.ContainerExecStart(ctx, execID.ID, types.ExecStartCheck{}); err != nil {
-		return nil, fmt.Errorf("failed to start exec: %w", err)
-	}
+	return files, nil
+}
 
-	// Read output
-	output, err := io.ReadAll(attachResp.Reader)
+// ReadFile reads the contents of a file from a container
+func (m *Manager) ReadFile(ctx context.Context, containerID, filePath string) ([]byte, error) {
+	reader, _, err := m.ReadFileStream(ctx, containerID, filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read output: %w", err)
+		return nil, err
 	}
+	defer reader.Close()
 
-	// Parse ls output
-	files := parseL
-
-SOutput(string(output), path)
+	// Read file contents
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file contents: %w", err)
+	}
 
-	return files, nil
+	return contents, nil
 }
 
-// ReadFile reads the contents of a file from a container
-func (m *Manager) ReadFile(ctx context.Context, containerID, filePath string) ([]byte, error) {
+// ReadFileStream opens a file in a container for streaming
+func (m *Manager) ReadFileStream(ctx context.Context, containerID, filePath string) (io.ReadCloser, int64, error) {
 	m.logger.Debug("Reading file",
-		zap.String("containerID", containerID[:12]),
+		zap.String("containerID", containerID),
 		zap.String("path", filePath))
 
+	target, err := m.containerPath(containerID, filePath, true)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	// Copy file from container as tar archive
-	reader, _, err := m.dockerClient.CopyFromContainer(ctx, containerID, filePath)
+	reader, _, err := m.dockerClient.CopyFromContainer(ctx, containerID, target)
 	if err != nil {
-		return nil, fmt.Errorf("failed to copy from container: %w", err)
+		return nil, 0, fmt.Errorf("failed to copy from container: %w", err)
 	}
-	defer reader.Close()
 
 	// Extract file from tar
 	tarReader := tar.NewReader(reader)
 	header, err := tarReader.Next()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read tar: %w", err)
+		reader.Close()
+		return nil, 0, fmt.Errorf("failed to read tar: %w", err)
 	}
 
 	if header.Typeflag != tar.TypeReg {
-		return nil, fmt.Errorf("path is not a regular file")
+		reader.Close()
+		return nil, 0, ErrNotRegularFile
 	}
 
-	// Read file contents
-	contents, err := io.ReadAll(tarReader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file contents: %w", err)
-	}
-
-	return contents, nil
+	return tarEntryReader{Reader: tarReader, Closer: reader}, header.Size, nil
 }
 
 // WriteFile writes content to a file in a container
 func (m *Manager) WriteFile(ctx context.Context, containerID, filePath string, content []byte) error {
-	m.logger.Debug("Writing file",
-		zap.String("containerID", containerID[:12]),
-		zap.String("path", filePath),
-		zap.Int("size", len(content)))
+	return m.WriteFileStream(ctx, containerID, filePath, bytes.NewReader(content), int64(len(content)))
+}
 
-	// Create tar archive with file
-	var buf bytes.Buffer
-	tarWriter := tar.NewWriter(&buf)
+// WriteFileStream streams content into a file in a container. The size must
+// be known up front since it goes in the tar header Docker copies from.
+func (m *Manager) WriteFileStream(ctx context.Context, containerID, filePath string, r io.Reader, size int64) error {
+	_, err := m.WriteFileIfMatch(ctx, containerID, filePath, r, size, "")
+	return err
+}
 
-	// Get file name and directory
-	fileName := filepath.Base(filePath)
-	dirPath := filepath.Dir(filePath)
+// WriteFileIfMatch streams content into a file in a container if the file is
+// still at version. The content is copied in under a temporary name and moved
+// over the file, so a failed copy never leaves it truncated.
+func (m *Manager) WriteFileIfMatch(ctx context.Context, containerID, filePath string, r io.Reader, size int64, version string) (string, error) {
+	m.logger.Debug("Writing file",
+		zap.String("containerID", containerID),
+		zap.String("path", filePath),
+		zap.Int64("size", size),
+		zap.Bool("conditional", version != ""))
 
-	// Write file to tar
-	header := &tar.Header{
-		Name:    fileName,
-		Mode:    0644,
-		Size:    int64(len(content)),
-		ModTime: time.Now(),
+	if size < 0 {
+		return "", fmt.Errorf("%w: size is required to write into a container", ErrInvalidPath)
 	}
 
-	if err := tarWriter.WriteHeader(header); err != nil {
-		return fmt.Errorf("failed to write tar header: %w", err)
+	sandbox, err := m.sandbox(containerID)
+	if err != nil {
+		return "", err
 	}
-
-	if _, err := tarWriter.Write(content); err != nil {
-		return fmt.Errorf("failed to write tar content: %w", err)
+	hostPath, err := sandbox.Resolve(filePath)
+	if err != nil {
+		return "", err
 	}
 
-	if err := tarWriter.Close(); err != nil {
-		return fmt.Errorf("failed to close tar writer: %w", err)
+	unlock := m.writeLocks.lock(hostPath)
+	defer unlock()
+
+	// The data directory is bind-mounted, so the version is read from the host
+	if err := checkVersion(hostPath, version); err != nil {
+		return "", err
 	}
 
+	// Get file name and directory
+	target := toContainerPath(sandbox, hostPath)
+	dirPath := path.Dir(target)
+	tmpName := fmt.Sprintf(".%s.%d.tmp", path.Base(target), time.Now().UnixNano())
+
+	// Build the tar archive on the fly so the content is never held in memory
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		tarWriter := tar.NewWriter(pipeWriter)
+
+		// Write file to tar
+		header := &tar.Header{
+			Name:    tmpName,
+			Mode:    0644,
+			Size:    size,
+			ModTime: time.Now(),
+		}
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			pipeWriter.CloseWithError(fmt.Errorf("failed to write tar header: %w", err))
+			return
+		}
+
+		if written, err := io.CopyN(tarWriter, r, size); err != nil {
+			pipeWriter.CloseWithError(fmt.Errorf("incomplete write: received %d of %d bytes: %w", written, size, err))
+			return
+		}
+
+		pipeWriter.CloseWithError(tarWriter.Close())
+	}()
+
 	// Copy tar to container
-	err := m.dockerClient.CopyToContainer(ctx, containerID, dirPath, &buf, types.CopyToContainerOptions{
+	err = m.dockerClient.CopyToContainer(ctx, containerID, dirPath, pipeReader, types.CopyToContainerOptions{
 		AllowOverwriteDirWithFile: true,
 	})
+	pipeReader.CloseWithError(err)
 	if err != nil {
-		return fmt.Errorf("failed to copy to container: %w", err)
+		// Docker may have extracted part of the file before the stream failed
+		m.exec(context.Background(), containerID, "rm", "-f", "--", path.Join(dirPath, tmpName))
+		return "", fmt.Errorf("failed to copy to container: %w", err)
+	}
+
+	if _, err := m.exec(ctx, containerID, "mv", "-f", "-T", "--", path.Join(dirPath, tmpName), target); err != nil {
+		m.exec(context.Background(), containerID, "rm", "-f", "--", path.Join(dirPath, tmpName))
+		return "", err
 	}
 
-	return nil
+	return fileVersion(hostPath)
+}
+
+// FileVersion returns a file's current version, read through the host bind mount
+func (m *Manager) FileVersion(ctx context.Context, containerID, filePath string) (string, error) {
+	sandbox, err := m.sandbox(containerID)
+	if err != nil {
+		return "", err
+	}
+	hostPath, err := sandbox.Resolve(filePath)
+	if err != nil {
+		return "", err
+	}
+	return fileVersion(hostPath)
+}
+
+// tarEntryReader reads a single tar entry and closes the underlying archive stream
+type tarEntryReader struct {
+	io.Reader
+	io.Closer
 }
 
 // DeleteFile deletes a file or directory from a container
 func (m *Manager) DeleteFile(ctx context.Context, containerID, path string) error {
 	m.logger.Info("Deleting file",
-		zap.String("containerID", containerID[:12]),
+		zap.String("containerID", containerID),
 		zap.String("path", path))
 
-	// Execute rm command in container
-	execConfig := types.ExecConfig{
-		AttachStdout: true,
-		AttachStderr: true,
-		Cmd:          []string{"/bin/sh", "-c", fmt.Sprintf("rm -rf %s", path)},
-	}
-
-	execID, err := m.dockerClient.ContainerExecCreate(ctx, containerID, execConfig)
+	// A symlink is deleted itself rather than whatever it points to
+	target, err := m.containerPath(containerID, path, false)
 	if err != nil {
-		return fmt.Errorf("failed to create exec: %w", err)
+		return err
 	}
-
-	if err := m.dockerClient.ContainerExecStart(ctx, execID.ID, types.ExecStartCheck{}); err != nil {
-		return fmt.Errorf("failed to start exec: %w", err)
+	if target == docker.ContainerDataPath {
+		return fmt.Errorf("%w: cannot delete the server root", ErrInvalidPath)
 	}
 
-	return nil
+	// Execute rm command in container
+	_, err = m.exec(ctx, containerID, "rm", "-rf", "--", target)
+	return err
 }
 
 // CreateDirectory creates a directory in a container
 func (m *Manager) CreateDirectory(ctx context.Context, containerID, path string) error {
 	m.logger.Info("Creating directory",
-		zap.String("containerID", containerID[:12]),
+		zap.String("containerID", containerID),
 		zap.String("path", path))
 
-	// Execute mkdir command in container
-	execConfig := types.ExecConfig{
-		AttachStdout: true,
-		AttachStderr: true,
-		Cmd:          []string{"/bin/sh", "-c", fmt.Sprintf("mkdir -p %s", path)},
-	}
-
-	execID, err := m.dockerClient.ContainerExecCreate(ctx, containerID, execConfig)
+	target, err := m.containerPath(containerID, path, true)
 	if err != nil {
-		return fmt.Errorf("failed to create exec: %w", err)
+		return err
 	}
 
-	if err := m.dockerClient.ContainerExecStart(ctx, execID.ID, types.ExecStartCheck{}); err != nil {
-		return fmt.Errorf("failed to start exec: %w", err)
-	}
-
-	return nil
+	// Execute mkdir command in container
+	_, err = m.exec(ctx, containerID, "mkdir", "-p", "--", target)
+	return err
 }
 
-// CompressFiles creates a compressed archive of files
+// CompressFiles archives files into outputPath, choosing the format from its
+// extension. The archive is written through the host bind mount in Go, so it
+// does not depend on the tools the server's image ships with.
 func (m *Manager) CompressFiles(ctx context.Context, containerID string, paths []string, outputPath string) error {
 	m.logger.Info("Compressing files",
-		zap.String("containerID", containerID[:12]),
+		zap.String("containerID", containerID),
 		zap.Strings("paths", paths),
 		zap.String("output", outputPath))
 
-	// Execute tar command in container
-	pathsStr := strings.Join(paths, " ")
-	cmd := fmt.Sprintf("tar -czf %s %s", outputPath, pathsStr)
-
-	execConfig := types.ExecConfig{
-		AttachStdout: true,
-		AttachStderr: true,
-		Cmd:          []string{"/bin/sh", "-c", cmd},
-	}
-
-	execID, err := m.dockerClient.ContainerExecCreate(ctx, containerID, execConfig)
+	sandbox, err := m.sandbox(containerID)
 	if err != nil {
-		return fmt.Errorf("failed to create exec: %w", err)
+		return err
 	}
 
-	if err := m.dockerClient.ContainerExecStart(ctx, execID.ID, types.ExecStartCheck{}); err != nil {
-		return fmt.Errorf("failed to start exec: %w", err)
+	owner, err := newFileOwner(m.containerUser(ctx, containerID))
+	if err != nil {
+		return err
 	}
 
-	return nil
+	// Disk quotas are enforced by HostManager, which serves the API
+	_, err = compressArchive(ctx, sandbox, owner, paths, outputPath, -1)
+	return err
 }
 
-// ExtractArchive extracts a compressed archive
+// ExtractArchive extracts an archive through the host bind mount, detecting
+// its format from its contents
 func (m *Manager) ExtractArchive(ctx context.Context, containerID, archivePath, targetPath string) error {
 	m.logger.Info("Extracting archive",
-		zap.String("containerID", containerID[:12]),
+		zap.String("containerID", containerID),
 		zap.String("archive", archivePath),
 		zap.String("target", targetPath))
 
-	// Execute tar extract command in container
-	cmd := fmt.Sprintf("tar -xzf %s -C %s", archivePath, targetPath)
-
-	execConfig := types.ExecConfig{
-		AttachStdout: true,
-		AttachStderr: true,
-		Cmd:          []string{"/bin/sh", "-c", cmd},
-	}
-
-	execID, err := m.dockerClient.ContainerExecCreate(ctx, containerID, execConfig)
+	sandbox, err := m.sandbox(containerID)
 	if err != nil {
-		return fmt.Errorf("failed to create exec: %w", err)
+		return err
 	}
 
-	if err := m.dockerClient.ContainerExecStart(ctx, execID.ID, types.ExecStartCheck{}); err != nil {
-		return fmt.Errorf("failed to start exec: %w", err)
+	owner, err := newFileOwner(m.containerUser(ctx, containerID))
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return extractArchive(ctx, sandbox, owner, archivePath, targetPath, DefaultArchiveLimits, -1, m.logger)
 }
 
 // parseLSOutput parses ls -la output into FileInfo structs
@@ -311,7 +351,7 @@ func parseLSOutput(output, basePath string) []FileInfo {
 		// Build file info
 		fileInfo := FileInfo{
 			Name:  name,
-			Path:  filepath.Join(basePath, name),
+			Path:  path.Join(basePath, name),
 			Size:  sizeInt,
 			IsDir: isDir,
 			Mode:  mode,
@@ -329,35 +369,245 @@ func parseLSOutput(output, basePath string) []FileInfo {
 
 // GetFileSize gets the size of a file
 func (m *Manager) GetFileSize(ctx context.Context, containerID, filePath string) (int64, error) {
+	target, err := m.containerPath(containerID, filePath, true)
+	if err != nil {
+		return 0, err
+	}
+
 	// Execute stat command
+	output, err := m.exec(ctx, containerID, "stat", "-c", "%s", "--", target)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	fmt.Sscanf(string(output), "%d", &size)
+
+	return size, nil
+}
+
+// Rename gives a file or directory a new name in the same directory
+func (m *Manager) Rename(ctx context.Context, containerID, filePath, newName string) error {
+	target, err := renameTarget(filePath, newName)
+	if err != nil {
+		return err
+	}
+	return m.Move(ctx, containerID, filePath, target)
+}
+
+// Move moves a file or directory to a new path in a container
+func (m *Manager) Move(ctx context.Context, containerID, from, to string) error {
+	m.logger.Info("Moving file",
+		zap.String("containerID", containerID),
+		zap.String("from", from),
+		zap.String("to", to))
+
+	sandbox, err := m.sandbox(containerID)
+	if err != nil {
+		return err
+	}
+
+	// The data directory is bind-mounted, so the host sees what the container does
+	source, target, err := transferPaths(sandbox, from, to)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("%w: %s", ErrFileExists, to)
+	}
+
+	dst := toContainerPath(sandbox, target)
+	if _, err := m.exec(ctx, containerID, "mkdir", "-p", "--", path.Dir(dst)); err != nil {
+		return err
+	}
+
+	_, err = m.exec(ctx, containerID, "mv", "--", toContainerPath(sandbox, source), dst)
+	return err
+}
+
+// Copy recursively copies a file or directory in a container
+func (m *Manager) Copy(ctx context.Context, containerID, from, to string, policy ConflictPolicy) error {
+	m.logger.Info("Copying file",
+		zap.String("containerID", containerID),
+		zap.String("from", from),
+		zap.String("to", to),
+		zap.String("conflict", string(policy)))
+
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	sandbox, err := m.sandbox(containerID)
+	if err != nil {
+		return err
+	}
+
+	source, target, err := transferPaths(sandbox, from, to)
+	if err != nil {
+		return err
+	}
+	if target, err = copyTarget(source, target, policy); err != nil {
+		return err
+	}
+
+	dst := toContainerPath(sandbox, target)
+	if _, err := m.exec(ctx, containerID, "mkdir", "-p", "--", path.Dir(dst)); err != nil {
+		return err
+	}
+
+	// -T copies onto dst rather than into it when dst is an existing directory
+	cmd := []string{"cp", "-a", "-T"}
+	switch policy {
+	case ConflictOverwrite:
+		cmd = append(cmd, "-f")
+	case ConflictSkip:
+		cmd = append(cmd, "-n")
+	}
+
+	_, err = m.exec(ctx, containerID, append(cmd, "--", toContainerPath(sandbox, source), dst)...)
+	return err
+}
+
+// Chmod sets a path's permission bits in a container
+func (m *Manager) Chmod(ctx context.Context, containerID, filePath string, mode os.FileMode) error {
+	m.logger.Info("Changing file mode",
+		zap.String("containerID", containerID),
+		zap.String("path", filePath),
+		zap.String("mode", mode.Perm().String()))
+
+	target, err := m.containerPath(containerID, filePath, true)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.exec(ctx, containerID, "chmod", fmt.Sprintf("%o", mode.Perm()), "--", target)
+	return err
+}
+
+// Chown changes the owner of a path and everything under it in a container
+func (m *Manager) Chown(ctx context.Context, containerID, filePath string, uid, gid int) error {
+	m.logger.Info("Changing file owner",
+		zap.String("containerID", containerID),
+		zap.String("path", filePath),
+		zap.Int("uid", uid),
+		zap.Int("gid", gid))
+
+	target, err := m.containerPath(containerID, filePath, true)
+	if err != nil {
+		return err
+	}
+
+	// Never root or another server's user, which would reach outside the container
+	userUID, userGID, lookupErr := m.containerUser(ctx, containerID)
+	if err := checkOwner(uid, gid, userUID, userGID, lookupErr); err != nil {
+		return err
+	}
+
+	_, err = m.exec(ctx, containerID, "chown", "-R", "-h", fmt.Sprintf("%d:%d", uid, gid), "--", target)
+	return err
+}
+
+// Search walks a server's files through the host bind mount, streaming each
+// result to emit, rather than running find and grep in the container
+func (m *Manager) Search(ctx context.Context, containerID string, opts SearchOptions, emit func(SearchResult) error) error {
+	m.logger.Debug("Searching files",
+		zap.String("containerID", containerID),
+		zap.String("path", opts.Path),
+		zap.String("name", opts.Name),
+		zap.Bool("content", opts.Content != ""))
+
+	sandbox, err := m.sandbox(containerID)
+	if err != nil {
+		return err
+	}
+
+	return searchTree(ctx, sandbox, opts, emit)
+}
+
+// containerUser returns the uid and gid a container runs as
+func (m *Manager) containerUser(ctx context.Context, containerID string) (int, int, error) {
+	info, err := m.dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if info.Config == nil {
+		return 0, 0, docker.ErrUserNotConfigured
+	}
+
+	uid, gid, ok := docker.ParseUser(info.Config.User)
+	if !ok {
+		return 0, 0, docker.ErrUserNotConfigured
+	}
+	return uid, gid, nil
+}
+
+// sandbox returns the path sandbox for a server's bind-mounted data directory
+func (m *Manager) sandbox(serverID string) (*Sandbox, error) {
+	if !docker.ValidServerID(serverID) {
+		return nil, fmt.Errorf("%w: invalid server ID %s", ErrInvalidPath, serverID)
+	}
+	return NewSandbox(filepath.Join(m.dataDir, serverID)), nil
+}
+
+// containerPath resolves a server-relative path through the sandbox on the
+// host and returns where that file lives inside the container
+func (m *Manager) containerPath(serverID, p string, followFinal bool) (string, error) {
+	sandbox, err := m.sandbox(serverID)
+	if err != nil {
+		return "", err
+	}
+
+	resolve := sandbox.Resolve
+	if !followFinal {
+		resolve = sandbox.ResolveNoFollow
+	}
+
+	hostPath, err := resolve(p)
+	if err != nil {
+		return "", err
+	}
+
+	return toContainerPath(sandbox, hostPath), nil
+}
+
+// toContainerPath maps a resolved host path to the same file inside the container
+func toContainerPath(sandbox *Sandbox, hostPath string) string {
+	return path.Join(docker.ContainerDataPath, sandbox.Rel(hostPath))
+}
+
+// exec runs a command in the container without a shell, so paths are never
+// interpreted, and waits for it to finish
+func (m *Manager) exec(ctx context.Context, containerID string, cmd ...string) ([]byte, error) {
 	execConfig := types.ExecConfig{
 		AttachStdout: true,
 		AttachStderr: true,
-		Cmd:          []string{"/bin/sh", "-c", fmt.Sprintf("stat -c %%s %s", filePath)},
+		Cmd:          cmd,
 	}
 
 	execID, err := m.dockerClient.ContainerExecCreate(ctx, containerID, execConfig)
 	if err != nil {
-		return 0, fmt.Errorf("failed to create exec: %w", err)
+		return nil, fmt.Errorf("failed to create exec: %w", err)
 	}
 
+	// Attaching starts the exec
 	attachResp, err := m.dockerClient.ContainerExecAttach(ctx, execID.ID, types.ExecStartCheck{})
 	if err != nil {
-		return 0, fmt.Errorf("failed to attach to exec: %w", err)
+		return nil, fmt.Errorf("failed to attach to exec: %w", err)
 	}
 	defer attachResp.Close()
 
-	if err := m.dockerClient.ContainerExecStart(ctx, execID.ID, types.ExecStartCheck{}); err != nil {
-		return 0, fmt.Errorf("failed to start exec: %w", err)
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, attachResp.Reader); err != nil {
+		return nil, fmt.Errorf("failed to read output: %w", err)
 	}
 
-	output, err := io.ReadAll(attachResp.Reader)
+	inspect, err := m.dockerClient.ContainerExecInspect(ctx, execID.ID)
 	if err != nil {
-		return 0, fmt.Errorf("failed to read output: %w", err)
+		return nil, fmt.Errorf("failed to inspect exec: %w", err)
+	}
+	if inspect.ExitCode != 0 {
+		return nil, fmt.Errorf("%s exited with code %d: %s", cmd[0], inspect.ExitCode, strings.TrimSpace(stderr.String()))
 	}
 
-	var size int64
-	fmt.Sscanf(string(output), "%d", &size)
-
-	return size, nil
+	return stdout.Bytes(), nil
 }
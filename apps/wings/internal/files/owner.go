@@ -0,0 +1,74 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mambapanel/wings/internal/docker"
+)
+
+// fileOwner is who the files the daemon creates for a server belong to, so
+// the server can still change them from inside its container. The zero value
+// leaves new files owned by the daemon.
+type fileOwner struct {
+	uid, gid int
+	set      bool
+}
+
+// newFileOwner builds a fileOwner from a container user lookup. A server
+// without a configured user or without a container keeps the daemon's
+// ownership; any other failure is returned.
+func newFileOwner(uid, gid int, lookupErr error) (fileOwner, error) {
+	if errors.Is(lookupErr, docker.ErrUserNotConfigured) || errors.Is(lookupErr, docker.ErrServerNotFound) {
+		return fileOwner{}, nil
+	}
+	if lookupErr != nil {
+		return fileOwner{}, fmt.Errorf("failed to look up container user: %w", lookupErr)
+	}
+	return fileOwner{uid: uid, gid: gid, set: true}, nil
+}
+
+// chown gives a newly created path to the owner, without following symlinks
+func (o fileOwner) chown(p string) error {
+	if !o.set {
+		return nil
+	}
+	if err := os.Lchown(p, o.uid, o.gid); err != nil {
+		return fmt.Errorf("failed to set owner of %s: %w", filepath.Base(p), err)
+	}
+	return nil
+}
+
+// mkdirAll creates a directory and any missing parents like os.MkdirAll,
+// giving every directory it creates to the owner
+func (o fileOwner) mkdirAll(p string, perm os.FileMode) error {
+	if !o.set {
+		return os.MkdirAll(p, perm)
+	}
+
+	var missing []string
+	for dir := filepath.Clean(p); ; {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		missing = append(missing, dir)
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	if err := os.MkdirAll(p, perm); err != nil {
+		return err
+	}
+	for _, dir := range missing {
+		if err := o.chown(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,453 @@
+package files
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mambapanel/wings/internal/docker"
+	"github.com/mambapanel/wings/internal/mtls"
+	"go.uber.org/zap"
+)
+
+const (
+	// diskScanInterval is how often every server's data directory is walked
+	diskScanInterval = 5 * time.Minute
+
+	// diskLimitTTL is how long a looked-up disk limit is trusted
+	diskLimitTTL = time.Minute
+
+	// Quota thresholds, in percent, that trigger an event to the panel
+	quotaWarningPercent  = 90
+	quotaExceededPercent = 100
+)
+
+// ErrQuotaExceeded is returned when a write would take a server past its disk limit
+var ErrQuotaExceeded = errors.New("server disk quota exceeded")
+
+// LimitFunc looks up a server's disk limit in bytes, with 0 meaning unlimited
+type LimitFunc func(serverID string) (int64, error)
+
+// serverDisk is the cached disk state of one server
+type serverDisk struct {
+	usage     int64
+	reserved  int64 // Set aside for writes and uploads still in progress
+	limit     int64
+	limitAt   time.Time
+	notified  int // Highest threshold already reported, 0 for none
+	scannedAt time.Time
+	scanning  bool // A background walk is running
+	stale     bool // Usage changed during the running walk, so it walks again
+}
+
+// DiskQuota tracks how much disk each server's data directory uses and
+// enforces its limit. Usage comes from periodically walking the directories,
+// since Docker reports nothing for bind mounts, and is adjusted in between by
+// the writes that go through the file manager. Writes of a known size
+// reserve it first, so concurrent writes cannot each claim the same space.
+//
+// A nil DiskQuota enforces nothing, so the file managers work without one.
+type DiskQuota struct {
+	dataDir   string
+	limitFunc LimitFunc
+	apiClient *mtls.APIClient // nil when the mTLS API client is unavailable
+	nodeID    string
+	logger    *zap.Logger
+
+	servers     map[string]*serverDisk // serverID -> disk state
+	serversLock sync.Mutex
+
+	// Control
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewDiskQuota creates a disk quota tracker for the servers under dataDir
+func NewDiskQuota(dataDir string, limitFunc LimitFunc, apiClient *mtls.APIClient, nodeID string, logger *zap.Logger) *DiskQuota {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &DiskQuota{
+		dataDir:   dataDir,
+		limitFunc: limitFunc,
+		apiClient: apiClient,
+		nodeID:    nodeID,
+		logger:    logger,
+		servers:   make(map[string]*serverDisk),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Start runs the scan loop, walking every server's data directory on an interval
+func (q *DiskQuota) Start() {
+	q.scanAll()
+
+	ticker := time.NewTicker(diskScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			q.scanAll()
+		case <-q.ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop stops the scan loop
+func (q *DiskQuota) Stop() {
+	q.logger.Info("Stopping disk quota tracker")
+	q.cancel()
+}
+
+// Usage returns a server's last known disk usage in bytes
+func (q *DiskQuota) Usage(serverID string) (int64, bool) {
+	if q == nil {
+		return 0, false
+	}
+
+	q.serversLock.Lock()
+	defer q.serversLock.Unlock()
+
+	disk, exists := q.servers[serverID]
+	if !exists || disk.scannedAt.IsZero() {
+		return 0, false
+	}
+	return disk.usage, true
+}
+
+// Reserve sets grow bytes of a server's quota aside for a write about to
+// happen, returning ErrQuotaExceeded if they are not available. The returned
+// release hands them back and is called once the write is done and its real
+// size added. Shrinking is always allowed, so a server over its quota can
+// still delete and truncate its way back under.
+func (q *DiskQuota) Reserve(serverID string, grow int64) (release func(), err error) {
+	return q.reserve(serverID, grow, false)
+}
+
+// reserve sets bytes aside, past the limit too when force is set
+func (q *DiskQuota) reserve(serverID string, grow int64, force bool) (func(), error) {
+	if q == nil || grow <= 0 {
+		return func() {}, nil
+	}
+
+	q.refresh(serverID)
+
+	q.serversLock.Lock()
+	defer q.serversLock.Unlock()
+
+	disk, exists := q.servers[serverID]
+	if !exists {
+		return func() {}, nil
+	}
+	if remaining := disk.remaining(); !force && remaining >= 0 && grow > remaining {
+		return nil, fmt.Errorf("%w: %d bytes needed, %d available", ErrQuotaExceeded, grow, remaining)
+	}
+	disk.reserved += grow
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.serversLock.Lock()
+			defer q.serversLock.Unlock()
+
+			// A forgotten server's reservations went with it
+			if q.servers[serverID] == disk {
+				disk.reserved = max(disk.reserved-grow, 0)
+			}
+		})
+	}, nil
+}
+
+// Remaining returns how many bytes a server may still write, or -1 when it
+// has no limit
+func (q *DiskQuota) Remaining(serverID string) int64 {
+	if q == nil {
+		return -1
+	}
+
+	disk := q.refresh(serverID)
+	return disk.remaining()
+}
+
+// remaining returns the bytes left once usage and reservations are taken, or
+// -1 when there is no limit
+func (d *serverDisk) remaining() int64 {
+	if d.limit <= 0 {
+		return -1
+	}
+	return max(d.limit-d.usage-d.reserved, 0)
+}
+
+// Add adjusts a server's cached usage after a write the file manager made
+func (q *DiskQuota) Add(serverID string, delta int64) {
+	if q == nil || delta == 0 {
+		return
+	}
+
+	q.serversLock.Lock()
+	disk, exists := q.servers[serverID]
+	if exists {
+		disk.usage = max(disk.usage+delta, 0)
+	}
+	q.serversLock.Unlock()
+
+	if exists {
+		q.checkThresholds(serverID)
+	}
+}
+
+// Invalidate walks a server's data directory again in the background, for
+// changes whose size is not known such as extracts. Until the walk finishes
+// the cached usage is used, so requests never wait on it.
+func (q *DiskQuota) Invalidate(serverID string) {
+	if q == nil {
+		return
+	}
+
+	q.serversLock.Lock()
+	start := false
+	// A server never walked is walked by its next check anyway
+	if disk, exists := q.servers[serverID]; exists && !disk.scannedAt.IsZero() {
+		if disk.scanning {
+			disk.stale = true
+		} else {
+			disk.scanning = true
+			start = true
+		}
+	}
+	q.serversLock.Unlock()
+
+	if start {
+		go q.rescan(serverID)
+	}
+}
+
+// rescan walks a server's data directory until no change arrives mid-walk
+func (q *DiskQuota) rescan(serverID string) {
+	for {
+		q.scan(serverID)
+
+		q.serversLock.Lock()
+		disk, exists := q.servers[serverID]
+		if !exists || !disk.stale || q.ctx.Err() != nil {
+			if exists {
+				disk.scanning = false
+				disk.stale = false
+			}
+			q.serversLock.Unlock()
+			return
+		}
+		disk.stale = false
+		q.serversLock.Unlock()
+	}
+}
+
+// Forget drops a server's cached state, used when it is deleted
+func (q *DiskQuota) Forget(serverID string) {
+	if q == nil {
+		return
+	}
+
+	q.serversLock.Lock()
+	delete(q.servers, serverID)
+	q.serversLock.Unlock()
+}
+
+// refresh returns a server's disk state, looking up its limit when stale and
+// walking its directory if it has never been walked
+func (q *DiskQuota) refresh(serverID string) serverDisk {
+	q.serversLock.Lock()
+	disk, exists := q.servers[serverID]
+	if !exists {
+		disk = &serverDisk{}
+		q.servers[serverID] = disk
+	}
+	needScan := disk.scannedAt.IsZero()
+	needLimit := time.Since(disk.limitAt) > diskLimitTTL
+	q.serversLock.Unlock()
+
+	if needLimit {
+		q.lookupLimit(serverID)
+	}
+	if needScan {
+		q.scan(serverID)
+	}
+
+	q.serversLock.Lock()
+	defer q.serversLock.Unlock()
+	if disk, exists := q.servers[serverID]; exists {
+		return *disk
+	}
+	return serverDisk{}
+}
+
+// lookupLimit refreshes a server's limit. On failure the previous limit is
+// kept, so a Docker hiccup neither blocks writes nor lifts a known quota.
+func (q *DiskQuota) lookupLimit(serverID string) {
+	limit, err := q.limitFunc(serverID)
+	if err != nil {
+		q.logger.Warn("Failed to look up disk limit",
+			zap.String("serverID", serverID),
+			zap.Error(err))
+	}
+
+	q.serversLock.Lock()
+	defer q.serversLock.Unlock()
+
+	if disk, exists := q.servers[serverID]; exists {
+		if err == nil {
+			disk.limit = limit
+		}
+		disk.limitAt = time.Now()
+	}
+}
+
+// scan walks a server's data directory and records its usage
+func (q *DiskQuota) scan(serverID string) {
+	usage, err := treeSize(q.ctx, []string{filepath.Join(q.dataDir, serverID)})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		q.logger.Warn("Failed to measure disk usage",
+			zap.String("serverID", serverID),
+			zap.Error(err))
+		return
+	}
+
+	q.serversLock.Lock()
+	disk, exists := q.servers[serverID]
+	if exists {
+		disk.usage = usage
+		disk.scannedAt = time.Now()
+	}
+	q.serversLock.Unlock()
+
+	if exists {
+		q.checkThresholds(serverID)
+	}
+}
+
+// scanAll refreshes every server with a data directory and drops servers whose directory is gone
+func (q *DiskQuota) scanAll() {
+	entries, err := os.ReadDir(q.dataDir)
+	if err != nil {
+		q.logger.Warn("Failed to list server data directories", zap.Error(err))
+		return
+	}
+
+	present := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		// Skips the uploads directory along with anything else that is not a server
+		if !entry.IsDir() || !docker.ValidServerID(entry.Name()) {
+			continue
+		}
+		present[entry.Name()] = true
+
+		if q.ctx.Err() != nil {
+			return
+		}
+
+		q.refresh(entry.Name())
+		q.scan(entry.Name())
+	}
+
+	q.serversLock.Lock()
+	for serverID := range q.servers {
+		if !present[serverID] {
+			delete(q.servers, serverID)
+		}
+	}
+	q.serversLock.Unlock()
+}
+
+// checkThresholds notifies the panel when a server's usage rises past a threshold
+func (q *DiskQuota) checkThresholds(serverID string) {
+	q.serversLock.Lock()
+	disk, exists := q.servers[serverID]
+	if !exists || disk.limit <= 0 {
+		q.serversLock.Unlock()
+		return
+	}
+
+	percent := int(disk.usage * 100 / disk.limit)
+	level := 0
+	switch {
+	case percent >= quotaExceededPercent:
+		level = quotaExceededPercent
+	case percent >= quotaWarningPercent:
+		level = quotaWarningPercent
+	}
+
+	// Each threshold is reported once per crossing; dropping below re-arms it
+	notify := level > disk.notified
+	disk.notified = level
+	usage, limit := disk.usage, disk.limit
+	q.serversLock.Unlock()
+
+	if notify {
+		q.notifyQuotaEvent(serverID, level, usage, limit)
+	}
+}
+
+// notifyQuotaEvent notifies the API that a server crossed a quota threshold
+func (q *DiskQuota) notifyQuotaEvent(serverID string, threshold int, usage, limit int64) {
+	eventType := "disk_quota_warning"
+	if threshold >= quotaExceededPercent {
+		eventType = "disk_quota_exceeded"
+	}
+
+	q.logger.Warn("Server disk usage crossed quota threshold",
+		zap.String("serverID", serverID),
+		zap.Int("threshold", threshold),
+		zap.Int64("usageBytes", usage),
+		zap.Int64("limitBytes", limit))
+
+	if q.apiClient == nil {
+		return
+	}
+
+	payload := map[string]interface{}{
+		"nodeId":    q.nodeID,
+		"serverId":  serverID,
+		"eventType": eventType,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"metadata": map[string]interface{}{
+			"thresholdPercent": threshold,
+			"usageBytes":       usage,
+			"limitBytes":       limit,
+		},
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		q.logger.Error("Failed to marshal disk quota event", zap.Error(err))
+		return
+	}
+
+	endpoint := fmt.Sprintf("/nodes/%s/events", q.nodeID)
+	resp, err := q.apiClient.Post(endpoint, data)
+	if err != nil {
+		q.logger.Error("Failed to send disk quota event to API", zap.Error(err))
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		q.logger.Error("API returned error for disk quota event", zap.Int("status", resp.StatusCode))
+	}
+}
+
+// limitToQuota caps a stream at a quota's remaining bytes, or returns it as is when unlimited
+func limitToQuota(r io.Reader, remaining int64) io.Reader {
+	if remaining < 0 {
+		return r
+	}
+	return &cappedReader{r: r, remaining: &remaining, err: ErrQuotaExceeded}
+}
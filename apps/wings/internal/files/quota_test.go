@@ -0,0 +1,172 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestQuotaManager returns a host manager for one server with a disk limit of limit bytes
+func newTestQuotaManager(t *testing.T, limit int64) (*HostManager, *DiskQuota) {
+	t.Helper()
+
+	dataDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dataDir, "srv"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	quota := NewDiskQuota(dataDir, func(serverID string) (int64, error) {
+		return limit, nil
+	}, nil, "", zap.NewNop())
+
+	return NewHostManager(dataDir, quota, nil, zap.NewNop()), quota
+}
+
+func TestHostManagerQuota(t *testing.T) {
+	ctx := context.Background()
+	m, quota := newTestQuotaManager(t, 1000)
+
+	if err := m.WriteFile(ctx, "srv", "/a.txt", make([]byte, 600)); err != nil {
+		t.Fatalf("WriteFile within quota: %v", err)
+	}
+	if usage, _ := quota.Usage("srv"); usage != 600 {
+		t.Fatalf("usage = %d, want 600", usage)
+	}
+
+	if err := m.WriteFile(ctx, "srv", "/b.txt", make([]byte, 500)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("WriteFile over quota error = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	// Replacing a file only counts the difference
+	if err := m.WriteFile(ctx, "srv", "/a.txt", make([]byte, 900)); err != nil {
+		t.Fatalf("WriteFile replacing within quota: %v", err)
+	}
+
+	// Streams of unknown size are cut off and leave nothing behind
+	err := m.WriteFileStream(ctx, "srv", "/c.txt", bytes.NewReader(make([]byte, 200)), -1)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("WriteFileStream over quota error = %v, want %v", err, ErrQuotaExceeded)
+	}
+	if _, err := os.Stat(filepath.Join(m.dataDir, "srv", "c.txt")); err == nil {
+		t.Fatalf("partial stream was kept")
+	}
+
+	if err := m.Copy(ctx, "srv", "/a.txt", "/a-copy.txt", ConflictFail); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Copy over quota error = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	// Deleting frees space straight away
+	if err := m.DeleteFile(ctx, "srv", "/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.WriteFile(ctx, "srv", "/b.txt", make([]byte, 500)); err != nil {
+		t.Fatalf("WriteFile after delete: %v", err)
+	}
+}
+
+func TestHostManagerQuotaArchives(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestQuotaManager(t, 4096)
+
+	if err := m.WriteFile(ctx, "srv", "/data.txt", []byte(strings.Repeat("a", 3000))); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CompressFiles(ctx, "srv", []string{"/data.txt"}, "/data.zip"); err != nil {
+		t.Fatalf("CompressFiles within quota: %v", err)
+	}
+
+	// Uncompressed, the copy would take the server past its quota
+	if err := m.CompressFiles(ctx, "srv", []string{"/data.txt"}, "/data.tar"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("CompressFiles over quota error = %v, want %v", err, ErrQuotaExceeded)
+	}
+	if err := m.ExtractArchive(ctx, "srv", "/data.zip", "/restored"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("ExtractArchive over quota error = %v, want %v", err, ErrQuotaExceeded)
+	}
+}
+
+func TestDiskQuotaThresholds(t *testing.T) {
+	_, quota := newTestQuotaManager(t, 1000)
+	notified := func() int {
+		quota.serversLock.Lock()
+		defer quota.serversLock.Unlock()
+		return quota.servers["srv"].notified
+	}
+
+	quota.Remaining("srv")
+	for _, step := range []struct {
+		delta int64
+		want  int
+	}{
+		{500, 0},
+		{400, quotaWarningPercent},
+		{200, quotaExceededPercent},
+		{-150, quotaWarningPercent},
+		{-800, 0},
+	} {
+		quota.Add("srv", step.delta)
+		if got := notified(); got != step.want {
+			t.Fatalf("after adding %d, notified threshold = %d, want %d", step.delta, got, step.want)
+		}
+	}
+
+	// A nil quota enforces nothing
+	var unlimited *DiskQuota
+	release, err := unlimited.Reserve("srv", 1<<40)
+	if err != nil {
+		t.Fatalf("nil quota Reserve error = %v", err)
+	}
+	release()
+}
+
+func TestDiskQuotaReserve(t *testing.T) {
+	_, quota := newTestQuotaManager(t, 1000)
+
+	release, err := quota.Reserve("srv", 700)
+	if err != nil {
+		t.Fatalf("Reserve within quota: %v", err)
+	}
+
+	// Reserved bytes are not available to a second write
+	if _, err := quota.Reserve("srv", 400); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Reserve past reservation error = %v, want %v", err, ErrQuotaExceeded)
+	}
+	if remaining := quota.Remaining("srv"); remaining != 300 {
+		t.Fatalf("remaining = %d, want 300", remaining)
+	}
+
+	// Releasing twice hands the bytes back once
+	release()
+	release()
+	if remaining := quota.Remaining("srv"); remaining != 1000 {
+		t.Fatalf("remaining after release = %d, want 1000", remaining)
+	}
+}
+
+func TestDiskQuotaInvalidateRescansInBackground(t *testing.T) {
+	m, quota := newTestQuotaManager(t, 1000)
+	quota.Remaining("srv")
+
+	// A change the quota did not see is picked up once the walk finishes
+	if err := os.WriteFile(filepath.Join(m.dataDir, "srv", "outside.txt"), make([]byte, 250), 0644); err != nil {
+		t.Fatal(err)
+	}
+	quota.Invalidate("srv")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if usage, _ := quota.Usage("srv"); usage == 250 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("usage was not rescanned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,213 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxSymlinkHops bounds how many symlinks a single path may traverse, matching Linux's MAXSYMLINKS
+const maxSymlinkHops = 40
+
+// Errors returned when a path cannot be used. ErrInvalidPath is the caller's
+// mistake (400); the others mean the path points somewhere it may not (403).
+var (
+	ErrInvalidPath   = errors.New("invalid path")
+	ErrPathEscape    = errors.New("path escapes the server root")
+	ErrSymlinkEscape = errors.New("symlink points outside the server root")
+	ErrSpecialFile   = errors.New("path is a device or special file")
+)
+
+// Sandbox confines paths to a server's data directory on the host. Paths are
+// interpreted relative to the root whether or not they start with "/", and
+// symlinks are followed only as long as they stay inside the root.
+//
+// Resolution happens before the operation, so it cannot protect against a
+// symlink swapped in between; it does stop every path a client can send.
+type Sandbox struct {
+	root string
+}
+
+// NewSandbox creates a sandbox rooted at a server's data directory
+func NewSandbox(root string) *Sandbox {
+	return &Sandbox{root: filepath.Clean(root)}
+}
+
+// Root returns the host path of the sandbox root
+func (s *Sandbox) Root() string {
+	return s.root
+}
+
+// Resolve maps a client path onto the host, following symlinks in every
+// component. Components that do not exist yet are kept as given so the
+// result can be used to create files.
+func (s *Sandbox) Resolve(p string) (string, error) {
+	rel, err := sanitizePath(p)
+	if err != nil {
+		return "", err
+	}
+
+	resolved, err := s.follow(s.root, splitPath(rel))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, p)
+	}
+
+	if err := checkSpecial(resolved); err != nil {
+		return "", fmt.Errorf("%w: %s", err, p)
+	}
+
+	return resolved, nil
+}
+
+// ResolveNoFollow resolves a path like Resolve but leaves the final component
+// unresolved, so operations such as delete act on a symlink rather than its target
+func (s *Sandbox) ResolveNoFollow(p string) (string, error) {
+	rel, err := sanitizePath(p)
+	if err != nil {
+		return "", err
+	}
+	if rel == "" {
+		return s.root, nil
+	}
+
+	parent, err := s.follow(s.root, splitPath(path.Dir(rel)))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, p)
+	}
+
+	resolved := filepath.Join(parent, path.Base(rel))
+	if err := checkSpecial(resolved); err != nil {
+		return "", fmt.Errorf("%w: %s", err, p)
+	}
+
+	return resolved, nil
+}
+
+// Rel converts a resolved host path back into a "/"-rooted client path
+func (s *Sandbox) Rel(hostPath string) string {
+	rel, err := filepath.Rel(s.root, hostPath)
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// follow walks components from dir, expanding symlinks and rejecting any step
+// that would leave the root
+func (s *Sandbox) follow(dir string, pending []string) (string, error) {
+	current := dir
+	hops := 0
+
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			// Only symlink targets can contain "..", client paths are cleaned first
+			if current == s.root {
+				return "", ErrSymlinkEscape
+			}
+			current = filepath.Dir(current)
+			continue
+		}
+
+		next := filepath.Join(current, name)
+		info, err := os.Lstat(next)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// Missing components cannot be symlinks, keep them as given
+				current = next
+				continue
+			}
+			return "", fmt.Errorf("failed to resolve path: %w", err)
+		}
+
+		if info.Mode()&fs.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return "", fmt.Errorf("%w: too many levels of symbolic links", ErrInvalidPath)
+		}
+
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", fmt.Errorf("failed to read symlink: %w", err)
+		}
+
+		if filepath.IsAbs(target) {
+			rel, ok := s.within(filepath.Clean(target))
+			if !ok {
+				return "", ErrSymlinkEscape
+			}
+			current = s.root
+			pending = append(splitPath(rel), pending...)
+			continue
+		}
+
+		// Relative targets are resolved from the directory holding the link
+		pending = append(splitPath(filepath.ToSlash(target)), pending...)
+	}
+
+	return current, nil
+}
+
+// within reports whether a host path is inside the root, returning it relative to the root
+func (s *Sandbox) within(hostPath string) (string, bool) {
+	rel, err := filepath.Rel(s.root, hostPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	if rel == "." {
+		return "", true
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// sanitizePath validates a client path and returns it cleaned and relative to the root
+func sanitizePath(p string) (string, error) {
+	if strings.ContainsRune(p, 0) {
+		return "", fmt.Errorf("%w: contains a null byte", ErrInvalidPath)
+	}
+
+	cleaned := path.Clean(strings.TrimLeft(strings.ReplaceAll(p, "\\", "/"), "/"))
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%w: %s", ErrPathEscape, p)
+	}
+	if cleaned == "." {
+		return "", nil
+	}
+
+	return cleaned, nil
+}
+
+// splitPath splits a slash-separated path into its components
+func splitPath(p string) []string {
+	if p == "" || p == "." {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// checkSpecial refuses devices, pipes and sockets, which reading or writing could block on or escape through
+func checkSpecial(hostPath string) error {
+	info, err := os.Lstat(hostPath)
+	if err != nil {
+		return nil
+	}
+
+	if info.Mode()&(fs.ModeDevice|fs.ModeCharDevice|fs.ModeNamedPipe|fs.ModeSocket|fs.ModeIrregular) != 0 {
+		return ErrSpecialFile
+	}
+
+	return nil
+}
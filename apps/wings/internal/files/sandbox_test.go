@@ -0,0 +1,175 @@
+package files
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// newTestSandbox builds a server root with a mix of safe and escaping symlinks
+func newTestSandbox(t testing.TB) (*Sandbox, string) {
+	t.Helper()
+
+	base := t.TempDir()
+	root := filepath.Join(base, "server")
+	outside := filepath.Join(base, "outside")
+
+	for _, dir := range []string{
+		filepath.Join(root, "plugins", "config"),
+		filepath.Join(root, "world"),
+		outside,
+	} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "server.properties"), []byte("motd=test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	links := map[string]string{
+		"plugins/up":         "..",
+		"plugins/config/cfg": "../../server.properties",
+		"current":            "world",
+		"absolute-inside":    filepath.Join(root, "world"),
+		"escape-relative":    "../outside",
+		"escape-absolute":    outside,
+		"escape-chain":       "plugins/up/escape-relative",
+		"loop-a":             "loop-b",
+		"loop-b":             "loop-a",
+		"dangling":           "missing/file",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := syscall.Mkfifo(filepath.Join(root, "pipe"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSandbox(root), outside
+}
+
+func TestSandboxResolve(t *testing.T) {
+	sandbox, _ := newTestSandbox(t)
+	root := sandbox.Root()
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr error
+	}{
+		{"root", "/", root, nil},
+		{"empty", "", root, nil},
+		{"file", "/server.properties", filepath.Join(root, "server.properties"), nil},
+		{"relative", "plugins/config", filepath.Join(root, "plugins", "config"), nil},
+		{"inner dots", "/plugins/../world", filepath.Join(root, "world"), nil},
+		{"backslashes", `plugins\config`, filepath.Join(root, "plugins", "config"), nil},
+		{"new file", "/world/region/r.0.0.mca", filepath.Join(root, "world", "region", "r.0.0.mca"), nil},
+		{"symlink inside", "/current", filepath.Join(root, "world"), nil},
+		{"symlink to parent", "/plugins/up/server.properties", filepath.Join(root, "server.properties"), nil},
+		{"symlink file", "/plugins/config/cfg", filepath.Join(root, "server.properties"), nil},
+		{"absolute symlink inside", "/absolute-inside", filepath.Join(root, "world"), nil},
+		{"dangling symlink", "/dangling", filepath.Join(root, "missing", "file"), nil},
+		{"shell metacharacters", "; rm -rf /", filepath.Join(root, "; rm -rf "), nil},
+		{"parent traversal", "../../etc/passwd", "", ErrPathEscape},
+		{"rooted traversal", "/../etc/passwd", "", ErrPathEscape},
+		{"backslash traversal", `..\..\etc\passwd`, "", ErrPathEscape},
+		{"null byte", "/server.properties\x00.txt", "", ErrInvalidPath},
+		{"relative symlink escape", "/escape-relative/secret", "", ErrSymlinkEscape},
+		{"absolute symlink escape", "/escape-absolute", "", ErrSymlinkEscape},
+		{"chained symlink escape", "/escape-chain", "", ErrSymlinkEscape},
+		{"dots cleaned before symlinks", "/plugins/up/..", filepath.Join(root, "plugins"), nil},
+		{"symlink loop", "/loop-a", "", ErrInvalidPath},
+		{"fifo", "/pipe", "", ErrSpecialFile},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sandbox.Resolve(tt.path)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Resolve(%q) error = %v, want %v", tt.path, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve(%q) unexpected error: %v", tt.path, err)
+			}
+			if tt.want != "" && got != tt.want {
+				t.Fatalf("Resolve(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSandboxResolveNoFollow(t *testing.T) {
+	sandbox, _ := newTestSandbox(t)
+
+	got, err := sandbox.ResolveNoFollow("/escape-absolute")
+	if err != nil {
+		t.Fatalf("ResolveNoFollow unexpected error: %v", err)
+	}
+	if want := filepath.Join(sandbox.Root(), "escape-absolute"); got != want {
+		t.Fatalf("ResolveNoFollow = %q, want %q", got, want)
+	}
+
+	if _, err := sandbox.ResolveNoFollow("/escape-relative/secret"); !errors.Is(err, ErrSymlinkEscape) {
+		t.Fatalf("ResolveNoFollow through escaping parent error = %v, want %v", err, ErrSymlinkEscape)
+	}
+}
+
+func FuzzSandboxResolve(f *testing.F) {
+	for _, seed := range []string{
+		"", "/", ".", "..", "/server.properties", "plugins/config/cfg",
+		"../../etc/passwd", "/../../../etc/shadow", `..\..\windows`, "plugins/../../outside",
+		"/escape-relative/secret", "/escape-absolute", "/escape-chain", "/plugins/up/up",
+		"/plugins/up/escape-relative/../outside/secret", "/loop-a/x", "/dangling/../..",
+		"; rm -rf /", "$(reboot)", "`id`", "a\x00b", "/pipe", "//current//..//..",
+	} {
+		f.Add(seed)
+	}
+
+	sandbox, outside := newTestSandbox(f)
+	root := sandbox.Root()
+
+	f.Fuzz(func(t *testing.T, p string) {
+		for _, resolve := range []func(string) (string, error){sandbox.Resolve, sandbox.ResolveNoFollow} {
+			got, err := resolve(p)
+			if err != nil {
+				continue
+			}
+
+			if got != root && !strings.HasPrefix(got, root+string(filepath.Separator)) {
+				t.Fatalf("%q resolved outside the root: %q", p, got)
+			}
+
+			// Whatever exists of the resolved path must really be inside the root
+			// once the kernel follows it, not just lexically
+			existing := got
+			for {
+				if _, err := os.Lstat(existing); err == nil {
+					break
+				}
+				existing = filepath.Dir(existing)
+			}
+			real, err := filepath.EvalSymlinks(filepath.Dir(existing))
+			if err != nil {
+				continue
+			}
+			if strings.HasPrefix(real, outside) {
+				t.Fatalf("%q resolved through a symlink outside the root: %q", p, real)
+			}
+		}
+	})
+}
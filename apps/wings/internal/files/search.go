@@ -0,0 +1,278 @@
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Defaults applied to zero-valued SearchOptions
+	defaultSearchDepth    = 32
+	defaultSearchFileSize = 10 << 20
+	defaultSearchResults  = 1000
+
+	// maxSearchResults caps what a single search may return regardless of options
+	maxSearchResults = 10000
+
+	// maxMatchesPerFile keeps one huge log file from flooding the results
+	maxMatchesPerFile = 50
+
+	// maxMatchLineLength truncates matched lines sent back to the client
+	maxMatchLineLength = 512
+
+	// binarySniffSize is how much of a file is checked for NUL bytes
+	binarySniffSize = 8 << 10
+)
+
+// ErrSearchLimit is returned by a search that stopped at MaxResults
+var ErrSearchLimit = errors.New("search result limit reached")
+
+// SearchOptions describes a search through a server's files. Name is a glob
+// matched against each entry's name; when Content is set only regular text
+// files whose contents match are returned.
+type SearchOptions struct {
+	Path        string `json:"path"`        // Directory to search from, defaults to the root
+	Name        string `json:"name"`        // Glob such as "*.yml", defaults to "*"
+	Content     string `json:"content"`     // Text to look for inside files
+	Regex       bool   `json:"regex"`       // Treat Content as a regular expression
+	IgnoreCase  bool   `json:"ignoreCase"`  // Match Name and Content case-insensitively
+	MaxDepth    int    `json:"maxDepth"`    // Levels below Path to search, 1 being Path's own entries
+	MaxFileSize int64  `json:"maxFileSize"` // Larger files are not searched for Content
+	MaxResults  int    `json:"maxResults"`  // Stop after this many results
+}
+
+// SearchMatch is a line of a file that matched the content query
+type SearchMatch struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// SearchResult is a file or directory found by a search
+type SearchResult struct {
+	FileInfo
+	Matches []SearchMatch `json:"matches,omitempty"`
+}
+
+// searcher holds validated search options
+type searcher struct {
+	opts    SearchOptions
+	name    string
+	content *regexp.Regexp
+}
+
+// Validate checks the options can be searched with, so a bad pattern is
+// reported before any results are sent
+func (o SearchOptions) Validate() error {
+	_, err := o.compile()
+	return err
+}
+
+// compile applies defaults and builds the matchers for a search
+func (o SearchOptions) compile() (*searcher, error) {
+	s := &searcher{opts: o}
+
+	if s.opts.MaxDepth <= 0 {
+		s.opts.MaxDepth = defaultSearchDepth
+	}
+	if s.opts.MaxFileSize <= 0 {
+		s.opts.MaxFileSize = defaultSearchFileSize
+	}
+	if s.opts.MaxResults <= 0 {
+		s.opts.MaxResults = defaultSearchResults
+	}
+	s.opts.MaxResults = min(s.opts.MaxResults, maxSearchResults)
+
+	s.name = o.Name
+	if s.name == "" {
+		s.name = "*"
+	}
+	if o.IgnoreCase {
+		s.name = strings.ToLower(s.name)
+	}
+	if _, err := path.Match(s.name, ""); err != nil {
+		return nil, fmt.Errorf("%w: bad name pattern %q", ErrInvalidPath, o.Name)
+	}
+
+	if o.Content != "" {
+		expr := o.Content
+		if !o.Regex {
+			expr = regexp.QuoteMeta(expr)
+		}
+		if o.IgnoreCase {
+			expr = "(?i)" + expr
+		}
+
+		content, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad content pattern: %v", ErrInvalidPath, err)
+		}
+		s.content = content
+	}
+
+	return s, nil
+}
+
+// searchTree walks a server's files from opts.Path, passing each result to
+// emit as soon as it is found. Symlinks are never followed and special files
+// are skipped. Returns ErrSearchLimit if the search stopped at MaxResults.
+func searchTree(ctx context.Context, sandbox *Sandbox, opts SearchOptions, emit func(SearchResult) error) error {
+	s, err := opts.compile()
+	if err != nil {
+		return err
+	}
+
+	start, err := sandbox.Resolve(opts.Path)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(start)
+	if err != nil {
+		return fmt.Errorf("failed to stat search path: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%w: search path is not a directory", ErrInvalidPath)
+	}
+
+	found := 0
+	return filepath.WalkDir(start, func(p string, entry fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			// Unreadable directories are left out rather than ending the search
+			if entry != nil && entry.IsDir() && p != start {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if p == start {
+			return nil
+		}
+
+		rel, err := filepath.Rel(start, p)
+		if err != nil {
+			return err
+		}
+		depth := strings.Count(filepath.ToSlash(rel), "/") + 1
+
+		result, ok, err := s.match(p, entry)
+		if err != nil {
+			return err
+		}
+		if ok {
+			result.Path = sandbox.Rel(p)
+			if err := emit(result); err != nil {
+				return err
+			}
+			found++
+			if found >= s.opts.MaxResults {
+				return ErrSearchLimit
+			}
+		}
+
+		// Directories at the depth limit are still matched by name, just not entered
+		if entry.IsDir() && depth >= s.opts.MaxDepth {
+			return fs.SkipDir
+		}
+		return nil
+	})
+}
+
+// match checks a single entry against the search
+func (s *searcher) match(p string, entry fs.DirEntry) (SearchResult, bool, error) {
+	// Symlinks and special files are never reported or read
+	if !entry.IsDir() && !entry.Type().IsRegular() {
+		return SearchResult{}, false, nil
+	}
+
+	name := entry.Name()
+	if s.opts.IgnoreCase {
+		name = strings.ToLower(name)
+	}
+	if ok, _ := path.Match(s.name, name); !ok {
+		return SearchResult{}, false, nil
+	}
+
+	info, err := entry.Info()
+	if err != nil {
+		// Removed while searching
+		return SearchResult{}, false, nil
+	}
+	result := SearchResult{FileInfo: newFileInfo(info, "")}
+
+	if s.content == nil {
+		return result, true, nil
+	}
+	if entry.IsDir() || info.Size() > s.opts.MaxFileSize {
+		return SearchResult{}, false, nil
+	}
+
+	matches, err := s.grep(p)
+	if err != nil || len(matches) == 0 {
+		// Files that cannot be read are skipped like binary ones
+		return SearchResult{}, false, nil
+	}
+
+	result.Matches = matches
+	return result, true, nil
+}
+
+// grep returns the lines of a text file matching the content pattern, or
+// nothing for binary files
+func (s *searcher) grep(p string) ([]SearchMatch, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, binarySniffSize)
+	sniff, err := reader.Peek(binarySniffSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, err
+	}
+	if bytes.IndexByte(sniff, 0) >= 0 {
+		return nil, nil
+	}
+
+	var matches []SearchMatch
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if !s.content.Match(scanner.Bytes()) {
+			continue
+		}
+
+		matches = append(matches, SearchMatch{Line: line, Text: truncateLine(scanner.Text())})
+		if len(matches) >= maxMatchesPerFile {
+			break
+		}
+	}
+
+	// Lines too long to scan end the file's matches but keep what was found
+	return matches, nil
+}
+
+// truncateLine shortens a matched line without splitting a UTF-8 sequence
+func truncateLine(line string) string {
+	if len(line) <= maxMatchLineLength {
+		return line
+	}
+	cut := maxMatchLineLength
+	for cut > 0 && !utf8.RuneStart(line[cut]) {
+		cut--
+	}
+	return line[:cut] + "…"
+}
@@ -0,0 +1,135 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// newTestSearchRoot builds a server tree with config, binary and linked files
+func newTestSearchRoot(t *testing.T) *Sandbox {
+	t.Helper()
+
+	base := t.TempDir()
+	root := filepath.Join(base, "server")
+	for name, content := range map[string]string{
+		"server.properties":             "motd=A Server\nmax-players=20\n",
+		"plugins/Essentials/config.yml": "spawn: true\nmax-players: 50\n",
+		"plugins/LuckPerms/config.YML":  "storage: h2\n",
+		"plugins/plugin.jar":            "PK\x03\x04\x00max-players\x00",
+		"world/data/deep/level.yml":     "max-players: 1\n",
+	} {
+		target := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(target, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outside := filepath.Join(base, "outside.yml")
+	if err := os.WriteFile(outside, []byte("max-players: 99\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape.yml")); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSandbox(root)
+}
+
+// searchPaths runs a search and returns the sorted result paths
+func searchPaths(t *testing.T, sandbox *Sandbox, opts SearchOptions) ([]string, error) {
+	t.Helper()
+
+	var paths []string
+	err := searchTree(context.Background(), sandbox, opts, func(result SearchResult) error {
+		paths = append(paths, result.Path)
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+func TestSearchTree(t *testing.T) {
+	sandbox := newTestSearchRoot(t)
+
+	tests := []struct {
+		name string
+		opts SearchOptions
+		want []string
+	}{
+		{"name glob", SearchOptions{Name: "*.yml"}, []string{"/plugins/Essentials/config.yml", "/world/data/deep/level.yml"}},
+		{"ignore case", SearchOptions{Name: "config.yml", IgnoreCase: true}, []string{"/plugins/Essentials/config.yml", "/plugins/LuckPerms/config.YML"}},
+		{"directories", SearchOptions{Name: "Luck*"}, []string{"/plugins/LuckPerms"}},
+		{"subdirectory", SearchOptions{Path: "/world", Name: "*.yml"}, []string{"/world/data/deep/level.yml"}},
+		{"depth", SearchOptions{Name: "*.yml", MaxDepth: 3}, []string{"/plugins/Essentials/config.yml"}},
+		{"literal content skips binary", SearchOptions{Content: "max-players"}, []string{"/plugins/Essentials/config.yml", "/server.properties", "/world/data/deep/level.yml"}},
+		{"regex content", SearchOptions{Content: `max-players[:=] ?[0-9]{2}$`, Regex: true}, []string{"/plugins/Essentials/config.yml", "/server.properties"}},
+		{"literal is not regex", SearchOptions{Content: "max-players[:=]"}, nil},
+		{"file size", SearchOptions{Content: "max-players", MaxFileSize: 16}, []string{"/world/data/deep/level.yml"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := searchPaths(t, sandbox, tt.opts)
+			if err != nil {
+				t.Fatalf("searchTree unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("searchTree = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchTreeMatches(t *testing.T) {
+	sandbox := newTestSearchRoot(t)
+
+	var results []SearchResult
+	err := searchTree(context.Background(), sandbox, SearchOptions{Path: "/plugins/Essentials", Content: "MAX", IgnoreCase: true}, func(result SearchResult) error {
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("searchTree unexpected error: %v", err)
+	}
+
+	want := []SearchMatch{{Line: 2, Text: "max-players: 50"}}
+	if len(results) != 1 || !reflect.DeepEqual(results[0].Matches, want) {
+		t.Fatalf("searchTree results = %+v, want one result with %v", results, want)
+	}
+}
+
+func TestSearchTreeLimits(t *testing.T) {
+	sandbox := newTestSearchRoot(t)
+
+	got, err := searchPaths(t, sandbox, SearchOptions{Name: "*", MaxResults: 2})
+	if !errors.Is(err, ErrSearchLimit) {
+		t.Fatalf("searchTree error = %v, want %v", err, ErrSearchLimit)
+	}
+	if len(got) != 2 {
+		t.Fatalf("searchTree returned %d results, want 2", len(got))
+	}
+
+	for _, opts := range []SearchOptions{
+		{Name: "["},
+		{Content: "(", Regex: true},
+	} {
+		if err := opts.Validate(); !errors.Is(err, ErrInvalidPath) {
+			t.Fatalf("Validate(%+v) error = %v, want %v", opts, err, ErrInvalidPath)
+		}
+	}
+
+	if _, err := searchPaths(t, sandbox, SearchOptions{Path: "/escape.yml"}); !errors.Is(err, ErrSymlinkEscape) {
+		t.Fatalf("searchTree through escaping symlink error = %v, want %v", err, ErrSymlinkEscape)
+	}
+	if _, err := searchPaths(t, sandbox, SearchOptions{Path: "../.."}); !errors.Is(err, ErrPathEscape) {
+		t.Fatalf("searchTree outside the root error = %v, want %v", err, ErrPathEscape)
+	}
+}
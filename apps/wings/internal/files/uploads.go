@@ -0,0 +1,548 @@
+package files
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// uploadExpiry is how long an upload may sit idle before it is discarded
+	uploadExpiry = 24 * time.Hour
+
+	// uploadGCInterval is how often expired uploads are collected
+	uploadGCInterval = 10 * time.Minute
+
+	// uploadsDirName holds in-progress uploads inside the data directory, so
+	// completing one is a rename on the same filesystem. The leading dot
+	// keeps it from ever matching a server ID.
+	uploadsDirName = ".uploads"
+)
+
+// Errors returned by upload sessions
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("upload offset does not match")
+	ErrUploadIncomplete     = errors.New("upload is not complete")
+	ErrUploadTooLarge       = errors.New("chunk exceeds the declared upload size")
+	ErrUploadBusy           = errors.New("upload is already receiving a chunk")
+)
+
+// Upload is a resumable upload session. Chunks are appended at Offset until
+// it reaches Size, then the upload is completed into Path.
+type Upload struct {
+	ID        string    `json:"id"`
+	ServerID  string    `json:"serverId"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// uploadSession holds an upload's state. busy is held for the length of a
+// chunk so writers never interleave, while state only guards the fields so
+// progress can be read mid-chunk.
+type uploadSession struct {
+	upload Upload
+	state  sync.Mutex
+	busy   sync.Mutex
+
+	// The disk quota set aside for the upload until it completes or is discarded
+	reserved int64
+	release  func()
+}
+
+// snapshot returns a copy of the upload's current state
+func (s *uploadSession) snapshot() Upload {
+	s.state.Lock()
+	defer s.state.Unlock()
+	return s.upload
+}
+
+// UploadManager tracks resumable uploads, storing their data on disk so a
+// session survives both dropped connections and daemon restarts
+type UploadManager struct {
+	host   *HostManager
+	dir    string
+	logger *zap.Logger
+
+	sessions     map[string]*uploadSession // uploadID -> session
+	sessionsLock sync.Mutex
+
+	// Control
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewUploadManager creates an upload manager that completes uploads through the host file manager
+func NewUploadManager(host *HostManager, logger *zap.Logger) *UploadManager {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	m := &UploadManager{
+		host:     host,
+		dir:      filepath.Join(host.dataDir, uploadsDirName),
+		logger:   logger,
+		sessions: make(map[string]*uploadSession),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	m.load()
+
+	return m
+}
+
+// Start runs the garbage collection loop for expired uploads
+func (m *UploadManager) Start() {
+	ticker := time.NewTicker(uploadGCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.collectExpired()
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop stops the garbage collection loop
+func (m *UploadManager) Stop() {
+	m.logger.Info("Stopping upload manager")
+	m.cancel()
+}
+
+// Create starts an upload of size bytes to a path in the server's data directory
+func (m *UploadManager) Create(serverID, targetPath string, size int64) (*Upload, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("%w: upload size must not be negative", ErrInvalidPath)
+	}
+
+	// Validate the target now so the client learns before sending any data
+	target, err := m.host.resolve(serverID, targetPath)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(target); err == nil && !info.Mode().IsRegular() {
+		return nil, ErrNotRegularFile
+	}
+
+	// The declared size is held against the quota so the server cannot fill
+	// up while the upload runs
+	reserved := size - fileSize(target)
+	release, err := m.host.quota.Reserve(serverID, reserved)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newUploadID()
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	if err := os.MkdirAll(m.dir, 0700); err != nil {
+		release()
+		return nil, fmt.Errorf("failed to create uploads directory: %w", err)
+	}
+
+	file, err := os.OpenFile(m.dataPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("failed to create upload: %w", err)
+	}
+	file.Close()
+
+	now := time.Now().UTC()
+	session := &uploadSession{
+		upload: Upload{
+			ID:        id,
+			ServerID:  serverID,
+			Path:      cleanPath(targetPath),
+			Size:      size,
+			CreatedAt: now,
+			UpdatedAt: now,
+			ExpiresAt: now.Add(uploadExpiry),
+		},
+		reserved: reserved,
+		release:  release,
+	}
+
+	if err := m.persist(&session.upload); err != nil {
+		release()
+		os.Remove(m.dataPath(id))
+		return nil, err
+	}
+
+	m.sessionsLock.Lock()
+	m.sessions[id] = session
+	m.sessionsLock.Unlock()
+
+	m.logger.Info("Upload created",
+		zap.String("serverID", serverID),
+		zap.String("uploadID", id),
+		zap.String("path", session.upload.Path),
+		zap.Int64("size", size))
+
+	upload := session.upload
+	return &upload, nil
+}
+
+// Get returns the current state of an upload
+func (m *UploadManager) Get(serverID, uploadID string) (*Upload, error) {
+	session, err := m.session(serverID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	upload := session.snapshot()
+	return &upload, nil
+}
+
+// WriteChunk appends a chunk at offset, which must match the bytes received
+// so far. Whatever arrives before an interrupted chunk is kept, so the client
+// resumes from the returned offset.
+func (m *UploadManager) WriteChunk(serverID, uploadID string, offset int64, r io.Reader) (*Upload, error) {
+	session, err := m.session(serverID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	// One chunk at a time; a second writer would race on the offset
+	if !session.busy.TryLock() {
+		return nil, ErrUploadBusy
+	}
+	defer session.busy.Unlock()
+
+	upload := session.snapshot()
+	if offset != upload.Offset {
+		// The current state lets the client resume from the right offset
+		return &upload, fmt.Errorf("%w: expected %d, got %d", ErrUploadOffsetMismatch, upload.Offset, offset)
+	}
+
+	file, err := os.OpenFile(m.dataPath(uploadID), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload: %w", err)
+	}
+
+	// Read one byte past the remaining size to detect oversized chunks
+	remaining := upload.Size - upload.Offset
+	written, copyErr := io.Copy(file, io.LimitReader(r, remaining+1))
+	if written > remaining {
+		if err := file.Truncate(upload.Size); err != nil {
+			copyErr = err
+		}
+		written = remaining
+		if copyErr == nil {
+			copyErr = ErrUploadTooLarge
+		}
+	}
+	if err := file.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	upload.Offset += written
+	upload.UpdatedAt = time.Now().UTC()
+	upload.ExpiresAt = upload.UpdatedAt.Add(uploadExpiry)
+
+	session.state.Lock()
+	session.upload = upload
+	session.state.Unlock()
+
+	if err := m.persist(&upload); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	if copyErr != nil {
+		if errors.Is(copyErr, ErrUploadTooLarge) {
+			return &upload, copyErr
+		}
+		return &upload, fmt.Errorf("failed to write chunk: %w", copyErr)
+	}
+
+	return &upload, nil
+}
+
+// Complete moves a fully received upload into its target path. The target is
+// replaced in a single rename, so readers never see a partial file.
+func (m *UploadManager) Complete(serverID, uploadID string) (*Upload, error) {
+	session, err := m.session(serverID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !session.busy.TryLock() {
+		return nil, ErrUploadBusy
+	}
+	defer session.busy.Unlock()
+
+	upload := session.snapshot()
+	if upload.Offset != upload.Size {
+		return nil, fmt.Errorf("%w: received %d of %d bytes", ErrUploadIncomplete, upload.Offset, upload.Size)
+	}
+
+	// Resolve again, the tree may have changed since the upload was created
+	target, err := m.host.resolve(serverID, upload.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Moving the upload into place is a write like any other to the target
+	unlock := m.host.writeLocks.lock(target)
+	defer unlock()
+
+	if info, err := os.Stat(target); err == nil && !info.Mode().IsRegular() {
+		return nil, ErrNotRegularFile
+	}
+
+	// Only growth past the reservation, e.g. when the target was deleted
+	// while the upload ran, still needs room
+	existing := fileSize(target)
+	growth := upload.Size - existing
+	release, err := m.host.quota.Reserve(serverID, growth-session.reserved)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	owner, err := m.host.fileOwner(serverID)
+	if err != nil {
+		return nil, err
+	}
+	if err := owner.mkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create parent directory: %w", err)
+	}
+	if err := os.Chmod(m.dataPath(uploadID), 0644); err != nil {
+		return nil, fmt.Errorf("failed to set upload permissions: %w", err)
+	}
+	if err := owner.chown(m.dataPath(uploadID)); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(m.dataPath(uploadID), target); err != nil {
+		return nil, fmt.Errorf("failed to move upload into place: %w", err)
+	}
+
+	m.host.quota.Add(serverID, growth)
+	m.remove(uploadID)
+
+	m.logger.Info("Upload completed",
+		zap.String("serverID", serverID),
+		zap.String("uploadID", uploadID),
+		zap.String("path", upload.Path),
+		zap.Int64("size", upload.Size))
+
+	return &upload, nil
+}
+
+// Cancel discards an upload and its data
+func (m *UploadManager) Cancel(serverID, uploadID string) error {
+	session, err := m.session(serverID, uploadID)
+	if err != nil {
+		return err
+	}
+
+	if !session.busy.TryLock() {
+		return ErrUploadBusy
+	}
+	defer session.busy.Unlock()
+
+	m.remove(uploadID)
+	os.Remove(m.dataPath(uploadID))
+
+	m.logger.Info("Upload cancelled",
+		zap.String("serverID", serverID),
+		zap.String("uploadID", uploadID))
+
+	return nil
+}
+
+// ForgetServer discards every upload for a server, used when it is deleted
+func (m *UploadManager) ForgetServer(serverID string) {
+	m.sessionsLock.Lock()
+	var ids []string
+	for id, session := range m.sessions {
+		if session.upload.ServerID == serverID {
+			ids = append(ids, id)
+		}
+	}
+	m.sessionsLock.Unlock()
+
+	for _, id := range ids {
+		m.remove(id)
+		os.Remove(m.dataPath(id))
+	}
+}
+
+// session looks up an upload, hiding uploads that belong to other servers
+func (m *UploadManager) session(serverID, uploadID string) (*uploadSession, error) {
+	m.sessionsLock.Lock()
+	defer m.sessionsLock.Unlock()
+
+	session, exists := m.sessions[uploadID]
+	if !exists || session.upload.ServerID != serverID {
+		return nil, ErrUploadNotFound
+	}
+
+	return session, nil
+}
+
+// remove drops an upload's session, quota reservation and metadata; the
+// caller removes or moves the data
+func (m *UploadManager) remove(uploadID string) {
+	m.sessionsLock.Lock()
+	session, exists := m.sessions[uploadID]
+	delete(m.sessions, uploadID)
+	m.sessionsLock.Unlock()
+
+	if exists && session.release != nil {
+		session.release()
+	}
+	os.Remove(m.metaPath(uploadID))
+}
+
+// collectExpired discards uploads that have been idle past their expiry
+func (m *UploadManager) collectExpired() {
+	now := time.Now()
+
+	m.sessionsLock.Lock()
+	sessions := make([]*uploadSession, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		sessions = append(sessions, session)
+	}
+	m.sessionsLock.Unlock()
+
+	for _, session := range sessions {
+		// Skip uploads receiving a chunk right now, they are not idle
+		if !session.busy.TryLock() {
+			continue
+		}
+
+		if upload := session.snapshot(); now.After(upload.ExpiresAt) {
+			m.remove(upload.ID)
+			os.Remove(m.dataPath(upload.ID))
+
+			m.logger.Info("Upload expired",
+				zap.String("serverID", upload.ServerID),
+				zap.String("uploadID", upload.ID))
+		}
+
+		session.busy.Unlock()
+	}
+
+	m.collectOrphans()
+}
+
+// collectOrphans removes data files left without metadata, e.g. by a crash during Create
+func (m *UploadManager) collectOrphans() {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		id, isData := strings.CutSuffix(entry.Name(), ".part")
+		if !isData {
+			continue
+		}
+		if _, err := os.Stat(m.metaPath(id)); errors.Is(err, os.ErrNotExist) {
+			if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > uploadExpiry {
+				os.Remove(filepath.Join(m.dir, entry.Name()))
+			}
+		}
+	}
+}
+
+// load restores uploads persisted before a restart
+func (m *UploadManager) load() {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		id, isMeta := strings.CutSuffix(entry.Name(), ".json")
+		if !isMeta {
+			continue
+		}
+
+		data, err := os.ReadFile(m.metaPath(id))
+		if err != nil {
+			continue
+		}
+
+		var upload Upload
+		if err := json.Unmarshal(data, &upload); err != nil || upload.ID != id {
+			m.logger.Warn("Discarding unreadable upload", zap.String("uploadID", id), zap.Error(err))
+			os.Remove(m.metaPath(id))
+			os.Remove(m.dataPath(id))
+			continue
+		}
+
+		// The data file is the source of truth for how much arrived
+		info, err := os.Stat(m.dataPath(id))
+		if err != nil {
+			os.Remove(m.metaPath(id))
+			continue
+		}
+		upload.Offset = min(info.Size(), upload.Size)
+
+		// Reserved again even past the limit, the upload was accepted before the restart
+		session := &uploadSession{upload: upload}
+		if target, err := m.host.resolve(upload.ServerID, upload.Path); err == nil {
+			session.reserved = upload.Size - fileSize(target)
+			session.release, _ = m.host.quota.reserve(upload.ServerID, session.reserved, true)
+		}
+		m.sessions[id] = session
+	}
+
+	if len(m.sessions) > 0 {
+		m.logger.Info("Restored uploads", zap.Int("count", len(m.sessions)))
+	}
+}
+
+// persist writes an upload's metadata next to its data
+func (m *UploadManager) persist(upload *Upload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload: %w", err)
+	}
+
+	if err := os.WriteFile(m.metaPath(upload.ID), data, 0600); err != nil {
+		return fmt.Errorf("failed to persist upload: %w", err)
+	}
+
+	return nil
+}
+
+// dataPath returns where an upload's received bytes are stored
+func (m *UploadManager) dataPath(uploadID string) string {
+	return filepath.Join(m.dir, uploadID+".part")
+}
+
+// metaPath returns where an upload's metadata is stored
+func (m *UploadManager) metaPath(uploadID string) string {
+	return filepath.Join(m.dir, uploadID+".json")
+}
+
+// newUploadID returns a random, unguessable upload identifier
+func newUploadID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate upload ID: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,141 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestUploadResumeAndComplete(t *testing.T) {
+	host, quota := newTestQuotaManager(t, 1000)
+	uploads := NewUploadManager(host, zap.NewNop())
+	content := []byte(strings.Repeat("x", 600))
+
+	upload, err := uploads.Create("srv", "/world/level.dat", int64(len(content)))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if _, err := uploads.WriteChunk("srv", upload.ID, 0, bytes.NewReader(content[:250])); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+
+	// A chunk sent from the wrong offset reports where to resume
+	state, err := uploads.WriteChunk("srv", upload.ID, 0, bytes.NewReader(content))
+	if !errors.Is(err, ErrUploadOffsetMismatch) {
+		t.Fatalf("WriteChunk at stale offset error = %v, want %v", err, ErrUploadOffsetMismatch)
+	}
+	if state.Offset != 250 {
+		t.Fatalf("offset = %d, want 250", state.Offset)
+	}
+
+	if _, err := uploads.Complete("srv", upload.ID); !errors.Is(err, ErrUploadIncomplete) {
+		t.Fatalf("Complete before all data error = %v, want %v", err, ErrUploadIncomplete)
+	}
+
+	// A restarted daemon resumes from the data already on disk
+	quota = NewDiskQuota(host.dataDir, func(serverID string) (int64, error) {
+		return 1000, nil
+	}, nil, "", zap.NewNop())
+	host = NewHostManager(host.dataDir, quota, nil, zap.NewNop())
+	uploads = NewUploadManager(host, zap.NewNop())
+	state, err = uploads.Get("srv", upload.ID)
+	if err != nil {
+		t.Fatalf("Get after restart: %v", err)
+	}
+	if _, err := uploads.WriteChunk("srv", upload.ID, state.Offset, bytes.NewReader(content[state.Offset:])); err != nil {
+		t.Fatalf("WriteChunk after restart: %v", err)
+	}
+
+	if _, err := uploads.Complete("srv", upload.ID); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(host.dataDir, "srv", "world", "level.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("completed file has %d bytes, want %d", len(got), len(content))
+	}
+
+	// The completed file counts as usage and the reservation is gone
+	if usage, _ := quota.Usage("srv"); usage != 600 {
+		t.Fatalf("usage = %d, want 600", usage)
+	}
+	if remaining := quota.Remaining("srv"); remaining != 400 {
+		t.Fatalf("remaining = %d, want 400", remaining)
+	}
+	if _, err := uploads.Get("srv", upload.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("Get after complete error = %v, want %v", err, ErrUploadNotFound)
+	}
+}
+
+func TestUploadReservesQuota(t *testing.T) {
+	host, quota := newTestQuotaManager(t, 1000)
+	uploads := NewUploadManager(host, zap.NewNop())
+
+	first, err := uploads.Create("srv", "/a.bin", 600)
+	if err != nil {
+		t.Fatalf("Create within quota: %v", err)
+	}
+
+	// The first upload holds its size even though nothing has arrived yet
+	if _, err := uploads.Create("srv", "/b.bin", 600); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Create past reservation error = %v, want %v", err, ErrQuotaExceeded)
+	}
+	if err := host.WriteFile(context.Background(), "srv", "/c.bin", make([]byte, 600)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("WriteFile past reservation error = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	if err := uploads.Cancel("srv", first.ID); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	if remaining := quota.Remaining("srv"); remaining != 1000 {
+		t.Fatalf("remaining after cancel = %d, want 1000", remaining)
+	}
+	if _, err := uploads.Create("srv", "/b.bin", 600); err != nil {
+		t.Fatalf("Create after cancel: %v", err)
+	}
+}
+
+func TestUploadExpiry(t *testing.T) {
+	host, quota := newTestQuotaManager(t, 1000)
+	uploads := NewUploadManager(host, zap.NewNop())
+
+	upload, err := uploads.Create("srv", "/a.bin", 600)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// An upload still within its expiry survives collection
+	uploads.collectExpired()
+	if _, err := uploads.Get("srv", upload.ID); err != nil {
+		t.Fatalf("Get before expiry: %v", err)
+	}
+
+	session, err := uploads.session("srv", upload.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.state.Lock()
+	session.upload.ExpiresAt = time.Now().Add(-time.Minute)
+	session.state.Unlock()
+
+	uploads.collectExpired()
+	if _, err := uploads.Get("srv", upload.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("Get after expiry error = %v, want %v", err, ErrUploadNotFound)
+	}
+	if _, err := os.Stat(uploads.dataPath(upload.ID)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expired upload data was kept")
+	}
+	if remaining := quota.Remaining("srv"); remaining != 1000 {
+		t.Fatalf("remaining after expiry = %d, want 1000", remaining)
+	}
+}
@@ -0,0 +1,176 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// ErrFileChanged is returned by a conditional write when the file is no longer
+// at the version the client last read
+var ErrFileChanged = errors.New("file changed since it was read")
+
+// versionHashLimit is the largest file whose contents are hashed into its
+// version, enough for anything the in-browser editor opens
+const versionHashLimit = 8 << 20
+
+// fileVersion returns an opaque version of a file for use as an ETag, built
+// from its size, modification time and inode. Files up to versionHashLimit
+// also get a hash of their contents, so an edit that keeps the size and lands
+// within the filesystem's mtime granularity still changes the version; larger
+// files are only stat'ed, so a multi-gigabyte upload costs no more than a
+// config file.
+func fileVersion(p string) (string, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", ErrNotRegularFile
+	}
+
+	version := fmt.Sprintf("%x-%x", info.Size(), info.ModTime().UnixNano())
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		version += fmt.Sprintf("-%x", stat.Ino)
+	}
+	if info.Size() > versionHashLimit {
+		return version, nil
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.LimitReader(file, versionHashLimit+1)); err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	return version + "-" + hex.EncodeToString(hash.Sum(nil)[:8]), nil
+}
+
+// checkVersion returns ErrFileChanged unless the file at p is at version. An
+// empty version matches anything, and a file that is gone matches nothing.
+func checkVersion(p, version string) error {
+	if version == "" {
+		return nil
+	}
+
+	current, err := fileVersion(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: file no longer exists", ErrFileChanged)
+	}
+	if err != nil {
+		return err
+	}
+	if current != version {
+		return ErrFileChanged
+	}
+	return nil
+}
+
+// writeAtomic writes r to a temporary file next to target and renames it into
+// place, so a crash or failed write leaves the previous contents intact. When
+// size is not negative, a stream that ends early fails the write too. A
+// replaced file keeps its permissions and, where the daemon may set it, its
+// owner; a new file and its new parents are given to owner.
+func writeAtomic(target string, r io.Reader, size int64, owner fileOwner) (int64, error) {
+	dir := filepath.Dir(target)
+	if err := owner.mkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create parent directory: %w", err)
+	}
+
+	mode := os.FileMode(0644)
+	uid, gid := -1, -1
+	if info, err := os.Stat(target); err == nil {
+		if !info.Mode().IsRegular() {
+			return 0, ErrNotRegularFile
+		}
+		mode = info.Mode().Perm()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(stat.Uid), int(stat.Gid)
+		}
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	written, err := io.Copy(tmp, r)
+	if err != nil {
+		return written, fmt.Errorf("failed to write file: %w", err)
+	}
+	if size >= 0 && written != size {
+		return written, fmt.Errorf("incomplete write: received %d of %d bytes", written, size)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return written, fmt.Errorf("failed to set file permissions: %w", err)
+	}
+	if uid >= 0 {
+		// Only root may give files away; otherwise the file stays the daemon's
+		tmp.Chown(uid, gid)
+	} else if err := owner.chown(tmp.Name()); err != nil {
+		return written, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return written, fmt.Errorf("failed to flush file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return written, fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return written, fmt.Errorf("failed to move file into place: %w", err)
+	}
+
+	return written, nil
+}
+
+// pathLocks serializes writes to the same file, so a version check and the
+// write that follows it cannot interleave with another writer
+type pathLocks struct {
+	locks map[string]*pathLock
+	mu    sync.Mutex
+}
+
+// pathLock is the lock for one file, dropped once nobody holds or waits on it
+type pathLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks a path and returns the function that unlocks it
+func (l *pathLocks) lock(p string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*pathLock)
+	}
+	lock, exists := l.locks[p]
+	if !exists {
+		lock = &pathLock{}
+		l.locks[p] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		l.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, p)
+		}
+		l.mu.Unlock()
+	}
+}
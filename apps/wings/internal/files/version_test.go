@@ -0,0 +1,148 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHostManagerWriteFileIfMatch(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestQuotaManager(t, 0)
+
+	if err := m.WriteFile(ctx, "srv", "/server.properties", []byte("motd=one\n")); err != nil {
+		t.Fatal(err)
+	}
+	read, err := m.FileVersion(ctx, "srv", "/server.properties")
+	if err != nil {
+		t.Fatalf("FileVersion: %v", err)
+	}
+
+	// The first editor to save wins and gets the new version back
+	saved, err := m.WriteFileIfMatch(ctx, "srv", "/server.properties", strings.NewReader("motd=two\n"), 9, read)
+	if err != nil {
+		t.Fatalf("WriteFileIfMatch at current version: %v", err)
+	}
+	if saved == read {
+		t.Fatalf("version did not change after a write")
+	}
+
+	// The second, still holding the version it read, is refused
+	if _, err := m.WriteFileIfMatch(ctx, "srv", "/server.properties", strings.NewReader("motd=three\n"), 11, read); !errors.Is(err, ErrFileChanged) {
+		t.Fatalf("WriteFileIfMatch at stale version error = %v, want %v", err, ErrFileChanged)
+	}
+	if content, _ := m.ReadFile(ctx, "srv", "/server.properties"); string(content) != "motd=two\n" {
+		t.Fatalf("stale write changed the file to %q", content)
+	}
+
+	// A file deleted since it was read cannot match either
+	if err := m.DeleteFile(ctx, "srv", "/server.properties"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.WriteFileIfMatch(ctx, "srv", "/server.properties", strings.NewReader("motd=four\n"), 10, saved); !errors.Is(err, ErrFileChanged) {
+		t.Fatalf("WriteFileIfMatch on deleted file error = %v, want %v", err, ErrFileChanged)
+	}
+}
+
+func TestHostManagerWriteFileAtomic(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestQuotaManager(t, 0)
+	target := filepath.Join(m.dataDir, "srv", "server.properties")
+
+	if err := m.WriteFile(ctx, "srv", "/server.properties", []byte("motd=original\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(target, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A stream that fails part way leaves the previous contents in place
+	failing := io.MultiReader(strings.NewReader("motd=par"), &errReader{errors.New("connection reset")})
+	if err := m.WriteFileStream(ctx, "srv", "/server.properties", failing, 20); err == nil {
+		t.Fatalf("WriteFileStream with failing stream succeeded")
+	}
+	if err := m.WriteFileStream(ctx, "srv", "/server.properties", strings.NewReader("motd=short"), 20); err == nil {
+		t.Fatalf("WriteFileStream with short stream succeeded")
+	}
+	if content, _ := os.ReadFile(target); string(content) != "motd=original\n" {
+		t.Fatalf("failed write left %q", content)
+	}
+
+	// A successful replace keeps the file's permissions and cleans up after itself
+	if err := m.WriteFile(ctx, "srv", "/server.properties", []byte("motd=new\n")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("mode = %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+	entries, _ := os.ReadDir(filepath.Dir(target))
+	if len(entries) != 1 {
+		t.Fatalf("temporary files left behind: %v", entries)
+	}
+}
+
+// errReader fails every read with err
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func TestFileVersion(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "world.dat")
+	if err := os.WriteFile(p, []byte("chunk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := fileVersion(p)
+	if err != nil {
+		t.Fatalf("fileVersion: %v", err)
+	}
+	if again, _ := fileVersion(p); again != first {
+		t.Fatalf("version of unchanged file moved from %q to %q", first, again)
+	}
+
+	// An edit in place keeps the inode but not the size
+	file, err := os.OpenFile(p, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("s")
+	file.Close()
+	if edited, _ := fileVersion(p); edited == first {
+		t.Fatalf("version did not change after an edit in place")
+	}
+
+	// So does one that keeps the size and the mtime, as a fast save can
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := fileVersion(p)
+	file, err = os.OpenFile(p, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("C")
+	file.Close()
+	if err := os.Chtimes(p, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if edited, _ := fileVersion(p); edited == before {
+		t.Fatalf("version did not change after a same-size edit within the mtime")
+	}
+
+	if _, err := fileVersion(filepath.Dir(p)); !errors.Is(err, ErrNotRegularFile) {
+		t.Fatalf("fileVersion of directory error = %v, want %v", err, ErrNotRegularFile)
+	}
+}
@@ -0,0 +1,484 @@
+package installer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/mambapanel/wings/internal/console"
+	"github.com/mambapanel/wings/internal/docker"
+	"go.uber.org/zap"
+)
+
+// Status mirrors the install_status values tracked by the panel
+type Status string
+
+const (
+	StatusPending    Status = "pending"
+	StatusInProgress Status = "in_progress"
+	StatusCompleted  Status = "completed"
+	StatusFailed     Status = "failed"
+)
+
+// LabelInstallerFor marks installer containers with the server they belong to.
+// It deliberately differs from io.mamba.server_id so the crash guard and
+// metrics emitter ignore installer containers.
+const LabelInstallerFor = "io.mamba.installer_for"
+
+const (
+	// installTimeout bounds how long a single install script may run
+	installTimeout = time.Hour
+
+	// Mount points inside the installer container
+	installerDataPath   = "/mnt/server"
+	installerScriptPath = "/mnt/install"
+)
+
+// ErrInstallInProgress is returned when an install is already running for a server
+var ErrInstallInProgress = errors.New("install already in progress")
+
+// Script describes the install step for a server
+type Script struct {
+	Image       string            `json:"image"`      // Installer container image
+	Entrypoint  string            `json:"entrypoint"` // Interpreter for the script, defaults to "bash"
+	Script      string            `json:"script"`     // Script contents
+	Environment map[string]string `json:"environment"`
+}
+
+// Validate checks that the install script can be run
+func (s *Script) Validate() error {
+	if s.Image == "" {
+		return fmt.Errorf("image is required")
+	}
+	if s.Script == "" {
+		return fmt.Errorf("script is required")
+	}
+	return nil
+}
+
+// Result records the outcome of the most recent install for a server
+type Result struct {
+	ServerID   string `json:"serverId"`
+	Status     Status `json:"status"`
+	ExitCode   int64  `json:"exitCode"`
+	Reinstall  bool   `json:"reinstall"`
+	Error      string `json:"error,omitempty"`
+	StartedAt  string `json:"startedAt"`
+	FinishedAt string `json:"finishedAt,omitempty"`
+}
+
+// Manager runs install scripts in ephemeral containers
+type Manager struct {
+	dockerClient *docker.Client
+	console      *console.Manager
+	logger       *zap.Logger
+	dataDir      string
+	stateDir     string
+
+	// Latest result per server
+	results     map[string]*Result // serverID -> Result
+	resultsLock sync.RWMutex
+
+	// Control
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewManager creates a new installer manager
+func NewManager(dockerClient *docker.Client, consoleManager *console.Manager, dataDir, stateDir string, logger *zap.Logger) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Manager{
+		dockerClient: dockerClient,
+		console:      consoleManager,
+		logger:       logger,
+		dataDir:      dataDir,
+		stateDir:     filepath.Join(stateDir, "install"),
+		results:      make(map[string]*Result),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// Stop cancels any running installs
+func (m *Manager) Stop() {
+	m.logger.Info("Stopping installer")
+	m.cancel()
+}
+
+// Install runs the install script for a server in the background
+func (m *Manager) Install(serverID string, script Script) error {
+	return m.begin(serverID, script, false, false)
+}
+
+// Reinstall re-runs the install script, optionally wiping the server's data first.
+// The server container is stopped before the installer starts.
+func (m *Manager) Reinstall(serverID string, script Script, wipe bool) error {
+	return m.begin(serverID, script, true, wipe)
+}
+
+// GetResult returns the latest install result for a server
+func (m *Manager) GetResult(serverID string) (*Result, bool) {
+	m.resultsLock.RLock()
+	if result, exists := m.results[serverID]; exists {
+		copied := *result
+		m.resultsLock.RUnlock()
+		return &copied, true
+	}
+	m.resultsLock.RUnlock()
+
+	// Fall back to the persisted result from a previous run of Wings
+	data, err := os.ReadFile(m.resultPath(serverID))
+	if err != nil {
+		return nil, false
+	}
+
+	var persisted Result
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		m.logger.Warn("Failed to parse install result", zap.String("serverID", serverID), zap.Error(err))
+		return nil, false
+	}
+
+	m.resultsLock.Lock()
+	defer m.resultsLock.Unlock()
+
+	// An install started meanwhile is newer than what was on disk
+	if result, exists := m.results[serverID]; exists {
+		copied := *result
+		return &copied, true
+	}
+
+	// Installs only run while Wings does, so one still in progress was cut
+	// short when the previous run stopped and will never finish
+	if persisted.Status == StatusInProgress {
+		persisted.Status = StatusFailed
+		persisted.Error = "install was interrupted by a restart of Wings"
+		persisted.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+
+		m.logger.Warn("Marking interrupted install as failed", zap.String("serverID", serverID))
+		m.persist(&persisted)
+	}
+
+	stored := persisted
+	m.results[serverID] = &stored
+
+	return &persisted, true
+}
+
+// IsInstalled reports whether a server may be started. Servers that were
+// never installed through Wings are treated as installed.
+func (m *Manager) IsInstalled(serverID string) bool {
+	result, exists := m.GetResult(serverID)
+	if !exists {
+		return true
+	}
+	return result.Status == StatusCompleted
+}
+
+// Forget removes the install record and any leftover installer container for a server
+func (m *Manager) Forget(serverID string) error {
+	m.resultsLock.Lock()
+	delete(m.results, serverID)
+	m.resultsLock.Unlock()
+
+	m.removeInstallerContainer(serverID)
+
+	if err := os.RemoveAll(m.scriptDir(serverID)); err != nil {
+		return err
+	}
+	if err := os.Remove(m.resultPath(serverID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// begin records the install as in progress and starts it in the background
+func (m *Manager) begin(serverID string, script Script, reinstall, wipe bool) error {
+	if !docker.ValidServerID(serverID) {
+		return fmt.Errorf("invalid server ID: %s", serverID)
+	}
+	if err := script.Validate(); err != nil {
+		return err
+	}
+
+	m.resultsLock.Lock()
+	if existing, exists := m.results[serverID]; exists && existing.Status == StatusInProgress {
+		m.resultsLock.Unlock()
+		return ErrInstallInProgress
+	}
+
+	result := &Result{
+		ServerID:  serverID,
+		Status:    StatusInProgress,
+		ExitCode:  -1,
+		Reinstall: reinstall,
+		StartedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	m.results[serverID] = result
+	m.resultsLock.Unlock()
+
+	m.persist(result)
+
+	go m.run(serverID, script, reinstall, wipe)
+
+	return nil
+}
+
+// run executes the install and records the result
+func (m *Manager) run(serverID string, script Script, reinstall, wipe bool) {
+	m.logger.Info("Starting server install",
+		zap.String("serverID", serverID),
+		zap.Bool("reinstall", reinstall),
+		zap.Bool("wipe", wipe))
+
+	m.publish(serverID, fmt.Sprintf("Starting install using %s", script.Image))
+
+	exitCode, err := m.execute(serverID, script, reinstall, wipe)
+
+	m.resultsLock.Lock()
+	result, exists := m.results[serverID]
+	if !exists {
+		// The server was deleted while installing
+		m.resultsLock.Unlock()
+		m.logger.Info("Discarding install result for removed server", zap.String("serverID", serverID))
+		return
+	}
+	result.ExitCode = exitCode
+	result.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	switch {
+	case err != nil:
+		result.Status = StatusFailed
+		result.Error = err.Error()
+	case exitCode != 0:
+		result.Status = StatusFailed
+		result.Error = fmt.Sprintf("install script exited with code %d", exitCode)
+	default:
+		result.Status = StatusCompleted
+	}
+	snapshot := *result
+	m.resultsLock.Unlock()
+
+	m.persist(&snapshot)
+
+	if snapshot.Status == StatusCompleted {
+		m.logger.Info("Server install completed", zap.String("serverID", serverID))
+		m.publish(serverID, "Install completed successfully")
+	} else {
+		m.logger.Error("Server install failed",
+			zap.String("serverID", serverID),
+			zap.Int64("exitCode", exitCode),
+			zap.String("error", snapshot.Error))
+		m.publish(serverID, fmt.Sprintf("Install failed: %s", snapshot.Error))
+	}
+}
+
+// execute prepares the data directory and runs the installer container to completion
+func (m *Manager) execute(serverID string, script Script, reinstall, wipe bool) (int64, error) {
+	ctx, cancel := context.WithTimeout(m.ctx, installTimeout)
+	defer cancel()
+
+	cli := m.dockerClient.GetClient()
+	dataPath := filepath.Join(m.dataDir, serverID)
+
+	if reinstall {
+		// The game server must not run while its files are being replaced
+		timeout := 30
+		if err := cli.ContainerStop(ctx, serverID, container.StopOptions{Timeout: &timeout}); err != nil {
+			m.logger.Debug("Server container not stopped before reinstall", zap.String("serverID", serverID), zap.Error(err))
+		}
+
+		if wipe {
+			m.publish(serverID, "Wiping server data")
+			if err := wipeDirectory(dataPath); err != nil {
+				return -1, fmt.Errorf("failed to wipe data directory: %w", err)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(dataPath, 0755); err != nil {
+		return -1, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	scriptDir := m.scriptDir(serverID)
+	if err := os.MkdirAll(scriptDir, 0700); err != nil {
+		return -1, fmt.Errorf("failed to create script directory: %w", err)
+	}
+	defer os.RemoveAll(scriptDir)
+
+	if err := os.WriteFile(filepath.Join(scriptDir, "install.sh"), []byte(script.Script), 0755); err != nil {
+		return -1, fmt.Errorf("failed to write install script: %w", err)
+	}
+
+	if err := m.dockerClient.PullImage(script.Image); err != nil {
+		return -1, err
+	}
+
+	// Clear out an installer left behind by a previous crash of Wings
+	m.removeInstallerContainer(serverID)
+
+	entrypoint := script.Entrypoint
+	if entrypoint == "" {
+		entrypoint = "bash"
+	}
+
+	containerConfig := &container.Config{
+		Image:        script.Image,
+		Cmd:          []string{entrypoint, installerScriptPath + "/install.sh"},
+		Env:          buildEnv(script.Environment),
+		WorkingDir:   installerDataPath,
+		Tty:          true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Labels: map[string]string{
+			LabelInstallerFor: serverID,
+		},
+	}
+
+	hostConfig := &container.HostConfig{
+		Mounts: []mount.Mount{
+			{Type: mount.TypeBind, Source: dataPath, Target: installerDataPath},
+			{Type: mount.TypeBind, Source: scriptDir, Target: installerScriptPath, ReadOnly: true},
+		},
+	}
+
+	resp, err := cli.ContainerCreate(ctx, containerConfig, hostConfig, nil, nil, installerName(serverID))
+	if err != nil {
+		return -1, fmt.Errorf("failed to create installer container: %w", err)
+	}
+	defer m.removeInstallerContainer(serverID)
+
+	// Register the wait before starting so a fast exit is not missed
+	waitChan, waitErrChan := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNextExit)
+
+	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return -1, fmt.Errorf("failed to start installer container: %w", err)
+	}
+
+	logs, err := cli.ContainerLogs(ctx, resp.ID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+	})
+	if err != nil {
+		return -1, fmt.Errorf("failed to attach to installer logs: %w", err)
+	}
+	m.streamOutput(serverID, logs)
+
+	select {
+	case status := <-waitChan:
+		if status.Error != nil {
+			return status.StatusCode, fmt.Errorf("installer container error: %s", status.Error.Message)
+		}
+		return status.StatusCode, nil
+	case err := <-waitErrChan:
+		return -1, fmt.Errorf("failed waiting for installer container: %w", err)
+	}
+}
+
+// streamOutput forwards installer output to the server's console until the container exits
+func (m *Manager) streamOutput(serverID string, logs io.ReadCloser) {
+	defer logs.Close()
+
+	scanner := bufio.NewScanner(logs)
+	for scanner.Scan() {
+		m.console.Publish(serverID, console.LogEntry{
+			Type: "install",
+			Line: scanner.Text(),
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		m.logger.Warn("Error reading installer output", zap.String("serverID", serverID), zap.Error(err))
+	}
+}
+
+// publish writes a status line to the server's console
+func (m *Manager) publish(serverID, line string) {
+	m.console.Publish(serverID, console.LogEntry{
+		Type: "install",
+		Line: "[Wings] " + line,
+	})
+}
+
+// removeInstallerContainer force-removes the installer container for a server if present
+func (m *Manager) removeInstallerContainer(serverID string) {
+	err := m.dockerClient.GetClient().ContainerRemove(context.Background(), installerName(serverID), container.RemoveOptions{
+		Force: true,
+	})
+	if err != nil {
+		m.logger.Debug("Installer container not removed", zap.String("serverID", serverID), zap.Error(err))
+	}
+}
+
+// persist writes an install result to disk so it survives restarts of Wings
+func (m *Manager) persist(result *Result) {
+	if err := os.MkdirAll(m.stateDir, 0700); err != nil {
+		m.logger.Error("Failed to create install state directory", zap.Error(err))
+		return
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		m.logger.Error("Failed to marshal install result", zap.Error(err))
+		return
+	}
+
+	if err := os.WriteFile(m.resultPath(result.ServerID), data, 0600); err != nil {
+		m.logger.Error("Failed to persist install result",
+			zap.String("serverID", result.ServerID),
+			zap.Error(err))
+	}
+}
+
+// resultPath returns where the install result for a server is stored
+func (m *Manager) resultPath(serverID string) string {
+	return filepath.Join(m.stateDir, serverID+".json")
+}
+
+// scriptDir returns the host directory mounted into the installer with the script
+func (m *Manager) scriptDir(serverID string) string {
+	return filepath.Join(m.stateDir, serverID)
+}
+
+// installerName returns the container name used for a server's installer
+func installerName(serverID string) string {
+	return serverID + "_installer"
+}
+
+// buildEnv converts the environment map into Docker's KEY=value form
+func buildEnv(environment map[string]string) []string {
+	env := make([]string, 0, len(environment))
+	for key, value := range environment {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(env)
+	return env
+}
+
+// wipeDirectory removes everything inside a directory but keeps the directory itself
+func wipeDirectory(path string) error {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(path, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
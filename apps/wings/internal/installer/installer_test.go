@@ -0,0 +1,185 @@
+package installer
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/mambapanel/wings/internal/docker"
+	"go.uber.org/zap"
+)
+
+// newTestManager returns a manager whose Docker client has no daemon to talk to
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+
+	t.Setenv("DOCKER_HOST", "unix://"+filepath.Join(t.TempDir(), "docker.sock"))
+	dockerClient, err := docker.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dockerClient.Close() })
+
+	return NewManager(dockerClient, nil, t.TempDir(), t.TempDir(), zap.NewNop())
+}
+
+// writeResult persists a result as a previous run of Wings would have left it
+func writeResult(t *testing.T, m *Manager, result Result) {
+	t.Helper()
+
+	if err := os.MkdirAll(m.stateDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(m.resultPath(result.ServerID), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScriptValidate(t *testing.T) {
+	for _, tt := range []struct {
+		script Script
+		valid  bool
+	}{
+		{Script{Image: "alpine", Script: "echo hi"}, true},
+		{Script{Script: "echo hi"}, false},
+		{Script{Image: "alpine"}, false},
+	} {
+		if err := tt.script.Validate(); (err == nil) != tt.valid {
+			t.Fatalf("Validate(%+v) error = %v, want valid %v", tt.script, err, tt.valid)
+		}
+	}
+}
+
+func TestManagerPersistedResults(t *testing.T) {
+	m := newTestManager(t)
+
+	// Servers never installed through Wings may start
+	if _, exists := m.GetResult("fresh"); exists {
+		t.Fatalf("result found for a server that was never installed")
+	}
+	if !m.IsInstalled("fresh") {
+		t.Fatalf("server that was never installed is not startable")
+	}
+
+	writeResult(t, m, Result{ServerID: "done", Status: StatusCompleted, StartedAt: "2026-01-01T00:00:00Z"})
+	if !m.IsInstalled("done") {
+		t.Fatalf("completed install is not startable")
+	}
+
+	writeResult(t, m, Result{ServerID: "broken", Status: StatusFailed, Error: "exit 1"})
+	if m.IsInstalled("broken") {
+		t.Fatalf("failed install is startable")
+	}
+}
+
+func TestManagerInterruptedInstall(t *testing.T) {
+	m := newTestManager(t)
+	writeResult(t, m, Result{ServerID: "srv", Status: StatusInProgress, ExitCode: -1, StartedAt: "2026-01-01T00:00:00Z"})
+
+	// The install died with the previous run of Wings, so it is reported failed
+	result, exists := m.GetResult("srv")
+	if !exists {
+		t.Fatalf("interrupted install result not found")
+	}
+	if result.Status != StatusFailed || result.Error == "" || result.FinishedAt == "" {
+		t.Fatalf("interrupted install result = %+v, want failed", result)
+	}
+	if m.IsInstalled("srv") {
+		t.Fatalf("interrupted install is startable")
+	}
+
+	// The failure is saved, so it survives another restart
+	restarted := NewManager(m.dockerClient, nil, m.dataDir, filepath.Dir(m.stateDir), zap.NewNop())
+	if result, _ := restarted.GetResult("srv"); result == nil || result.Status != StatusFailed {
+		t.Fatalf("result after restart = %+v, want failed", result)
+	}
+}
+
+func TestManagerBeginValidates(t *testing.T) {
+	m := newTestManager(t)
+
+	if err := m.Install("../srv", Script{Image: "alpine", Script: "true"}); err == nil {
+		t.Fatalf("Install with an invalid server ID succeeded")
+	}
+	if err := m.Install("srv", Script{Image: "alpine"}); err == nil {
+		t.Fatalf("Install without a script succeeded")
+	}
+	if _, exists := m.GetResult("srv"); exists {
+		t.Fatalf("rejected install left a result")
+	}
+
+	// Only one install runs per server at a time
+	m.results["srv"] = &Result{ServerID: "srv", Status: StatusInProgress}
+	if err := m.Reinstall("srv", Script{Image: "alpine", Script: "true"}, true); !errors.Is(err, ErrInstallInProgress) {
+		t.Fatalf("Reinstall during install error = %v, want %v", err, ErrInstallInProgress)
+	}
+}
+
+func TestManagerForget(t *testing.T) {
+	m := newTestManager(t)
+	writeResult(t, m, Result{ServerID: "srv", Status: StatusFailed})
+	if err := os.MkdirAll(m.scriptDir("srv"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	m.GetResult("srv")
+
+	if err := m.Forget("srv"); err != nil {
+		t.Fatalf("Forget: %v", err)
+	}
+	if _, exists := m.GetResult("srv"); exists {
+		t.Fatalf("result kept after Forget")
+	}
+	for _, path := range []string{m.resultPath("srv"), m.scriptDir("srv")} {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%s kept after Forget", path)
+		}
+	}
+
+	// Forgetting a server with nothing recorded is not an error
+	if err := m.Forget("other"); err != nil {
+		t.Fatalf("Forget of unknown server: %v", err)
+	}
+}
+
+func TestBuildEnv(t *testing.T) {
+	got := buildEnv(map[string]string{"VERSION": "1.21", "EULA": "true", "EMPTY": ""})
+	want := []string{"EMPTY=", "EULA=true", "VERSION=1.21"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("buildEnv = %q, want %q", got, want)
+	}
+}
+
+func TestWipeDirectory(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"world/level.dat", "server.properties"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := wipeDirectory(dir); err != nil {
+		t.Fatalf("wipeDirectory: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("directory itself was removed: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("%d entries left after wipe", len(entries))
+	}
+
+	if err := wipeDirectory(filepath.Join(dir, "missing")); err != nil {
+		t.Fatalf("wipeDirectory of a missing directory: %v", err)
+	}
+}
@@ -9,10 +9,15 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"github.com/mambapanel/wings/internal/files"
 	"github.com/mambapanel/wings/internal/mtls"
+	"github.com/mambapanel/wings/internal/stats"
 	"go.uber.org/zap"
 )
 
+// Interval is how often samples are collected and sent to the API
+const Interval = 30 * time.Second
+
 // Sample represents a single metrics sample for a server
 type Sample struct {
 	ServerID        string  `json:"serverId"`
@@ -44,7 +49,11 @@ type Emitter struct {
 	maxBuffer  int
 
 	// Tracking
+	collector        *stats.Collector
+	diskQuota        *files.DiskQuota
 	lastNetworkStats map[string]uint64 // containerID -> bytes sent
+	lastSamples      map[string]Sample // serverID -> latest sample
+	lastSamplesLock  sync.RWMutex
 
 	// Control
 	ctx    context.Context
@@ -52,7 +61,7 @@ type Emitter struct {
 }
 
 // NewEmitter creates a new metrics emitter
-func NewEmitter(dockerClient *client.Client, apiClient *mtls.APIClient, nodeID string, logger *zap.Logger) *Emitter {
+func NewEmitter(dockerClient *client.Client, apiClient *mtls.APIClient, nodeID string, diskQuota *files.DiskQuota, logger *zap.Logger) *Emitter {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &Emitter{
@@ -62,7 +71,10 @@ func NewEmitter(dockerClient *client.Client, apiClient *mtls.APIClient, nodeID s
 		nodeID:           nodeID,
 		buffer:           make([]Sample, 0),
 		maxBuffer:        1000, // Keep up to 1000 samples if API is down
+		collector:        stats.NewCollector(dockerClient),
+		diskQuota:        diskQuota,
 		lastNetworkStats: make(map[string]uint64),
+		lastSamples:      make(map[string]Sample),
 		ctx:              ctx,
 		cancel:           cancel,
 	}
@@ -70,9 +82,9 @@ func NewEmitter(dockerClient *client.Client, apiClient *mtls.APIClient, nodeID s
 
 // Start begins the metrics collection loop
 func (e *Emitter) Start() {
-	e.logger.Info("Starting metrics emitter", zap.Duration("interval", 30*time.Second))
+	e.logger.Info("Starting metrics emitter", zap.Duration("interval", Interval))
 
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(Interval)
 	defer ticker.Stop()
 
 	// Collect immediately on start
@@ -134,6 +146,10 @@ func (e *Emitter) collectAndSend() {
 		}
 
 		samples = append(samples, *sample)
+
+		e.lastSamplesLock.Lock()
+		e.lastSamples[serverID] = *sample
+		e.lastSamplesLock.Unlock()
 	}
 
 	if len(samples) == 0 {
@@ -155,91 +171,58 @@ func (e *Emitter) collectAndSend() {
 	}
 }
 
-// collectContainerStats collects stats for a single container
-func (e *Emitter) collectContainerStats(serverID, containerID string) (*Sample, error) {
-	// Get stats with one-shot read (no stream)
-	stats, err := e.dockerClient.ContainerStats(e.ctx, containerID, false)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get container stats: %w", err)
-	}
-	defer stats.Body.Close()
+// LastSample returns the most recent sample collected for a server, which
+// for a server that has stopped is from before it stopped
+func (e *Emitter) LastSample(serverID string) (Sample, bool) {
+	e.lastSamplesLock.RLock()
+	defer e.lastSamplesLock.RUnlock()
 
-	// Decode stats
-	var containerStats types.StatsJSON
-	if err := json.NewDecoder(stats.Body).Decode(&containerStats); err != nil {
-		return nil, fmt.Errorf("failed to decode stats: %w", err)
-	}
+	sample, exists := e.lastSamples[serverID]
+	return sample, exists
+}
 
-	// Calculate CPU usage percentage
-	cpuPercent := calculateCPUPercent(&containerStats)
+// RemoveServer forgets the last sample collected for a deleted server
+func (e *Emitter) RemoveServer(serverID string) {
+	e.lastSamplesLock.Lock()
+	defer e.lastSamplesLock.Unlock()
 
-	// Memory usage in MB
-	memUsageMB := int64(containerStats.MemoryStats.Usage / 1024 / 1024)
+	delete(e.lastSamples, serverID)
+}
 
-	// Disk usage (approximation from container stats)
-	diskUsageMB := int64(0)
-	if containerStats.StorageStats.WriteSizeBytes > 0 {
-		diskUsageMB = int64(containerStats.StorageStats.WriteSizeBytes / 1024 / 1024)
+// collectContainerStats collects stats for a single container
+func (e *Emitter) collectContainerStats(serverID, containerID string) (*Sample, error) {
+	snapshot, err := e.collector.Collect(e.ctx, containerID)
+	if err != nil {
+		return nil, err
 	}
 
 	// Network egress (bytes sent since last sample)
 	var netEgressBytes int64
-	for _, netStats := range containerStats.Networks {
-		currentTx := netStats.TxBytes
-		lastTx, ok := e.lastNetworkStats[containerID]
-		if ok {
-			netEgressBytes += int64(currentTx - lastTx)
-		}
-		e.lastNetworkStats[containerID] = currentTx
+	lastTx, ok := e.lastNetworkStats[containerID]
+	if ok && snapshot.NetworkTx >= lastTx {
+		netEgressBytes = int64(snapshot.NetworkTx - lastTx)
 	}
+	e.lastNetworkStats[containerID] = snapshot.NetworkTx
 
-	// Container uptime (parse from started time)
-	inspect, err := e.dockerClient.ContainerInspect(e.ctx, containerID)
-	if err == nil && inspect.State.StartedAt != "" {
-		startTime, err := time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
-		if err == nil {
-			uptime := int64(time.Since(startTime).Seconds())
-			sample := &Sample{
-				ServerID:        serverID,
-				Timestamp:       time.Now().UTC().Format(time.RFC3339),
-				CPUUsagePercent: cpuPercent,
-				MemUsageMB:      memUsageMB,
-				DiskUsageMB:     diskUsageMB,
-				NetEgressBytes:  netEgressBytes,
-				Uptime:          uptime,
-			}
-			return sample, nil
-		}
+	// Docker reports no writes for bind-mounted data, so use the walked usage
+	diskUsage := snapshot.DiskUsage
+	if usage, ok := e.diskQuota.Usage(serverID); ok {
+		diskUsage = uint64(usage)
 	}
 
-	// Fallback without uptime
 	sample := &Sample{
 		ServerID:        serverID,
 		Timestamp:       time.Now().UTC().Format(time.RFC3339),
-		CPUUsagePercent: cpuPercent,
-		MemUsageMB:      memUsageMB,
-		DiskUsageMB:     diskUsageMB,
+		CPUUsagePercent: snapshot.CPUUsage,
+		MemUsageMB:      int64(snapshot.MemoryUsage / 1024 / 1024),
+		DiskUsageMB:     int64(diskUsage / 1024 / 1024),
 		NetEgressBytes:  netEgressBytes,
-		Uptime:          0,
+		Uptime:          snapshot.Uptime,
 	}
 
 	return sample, nil
 }
 
-// calculateCPUPercent calculates CPU usage percentage
-func calculateCPUPercent(stats *types.StatsJSON) float64 {
-	// Calculate CPU usage percentage based on Docker stats
-	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage - stats.PreCPUStats.CPUUsage.TotalUsage)
-	systemDelta := float64(stats.CPUStats.SystemUsage - stats.PreCPUStats.SystemUsage)
-
-	if systemDelta > 0.0 && cpuDelta > 0.0 {
-		cpuPercent := (cpuDelta / systemDelta) * float64(stats.CPUStats.OnlineCPUs) * 100.0
-		return cpuPercent
-	}
-
-	return 0.0
-}
-
 // sendToAPI sends metrics samples to the API
 func (e *Emitter) sendToAPI(samples []Sample) error {
 	payload := BatchMetricsPayload{
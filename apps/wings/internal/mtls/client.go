@@ -1,6 +1,7 @@
 package mtls
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -174,7 +175,7 @@ func (c *APIClient) Get(path string) (*http.Response, error) {
 // Post performs a POST request to the API
 func (c *APIClient) Post(path string, body []byte) (*http.Response, error) {
 	url := c.baseURL + path
-	req, err := http.NewRequest("POST", url, nil)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
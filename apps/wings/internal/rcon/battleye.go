@@ -0,0 +1,367 @@
+package rcon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// BattlEye RCon packet types
+const (
+	bePacketLogin   byte = 0x00
+	bePacketCommand byte = 0x01
+	bePacketMessage byte = 0x02
+)
+
+const (
+	// beHeaderSize is the "BE" magic, the CRC32 and the 0xFF before the type
+	beHeaderSize = 7
+
+	// beMaxPacketSize is the largest UDP payload
+	beMaxPacketSize = 65507
+
+	// beKeepAliveInterval is how often an idle connection sends an empty
+	// command; the server drops clients it has not heard from in 45 seconds
+	beKeepAliveInterval = 30 * time.Second
+
+	// beStaleAfter is how long without hearing from the server before the
+	// connection is considered lost
+	beStaleAfter = 45 * time.Second
+)
+
+// bePending collects the response to one command, which the server may split
+// over several packets arriving in any order
+type bePending struct {
+	parts    [][]byte
+	received int
+	done     chan string
+}
+
+// BattlEyeClient is a client for BattlEye RCon, the UDP protocol DayZ and
+// Arma servers expose. Commands are matched to responses by a one-byte
+// sequence number, messages the server pushes are acknowledged as required,
+// and an idle connection is kept alive with empty commands.
+type BattlEyeClient struct {
+	host     string
+	port     int
+	password string
+	logger   *zap.Logger
+	timeout  time.Duration
+
+	conn         net.Conn
+	done         chan struct{}       // Closed when conn is closed
+	login        chan bool           // Receives the login verdict
+	pending      map[byte]*bePending // Sequence number -> command awaiting its response
+	seq          byte
+	lastSent     time.Time
+	lastReceived time.Time
+	lastMessage  int // Sequence number of the last server message, -1 for none
+	mu           sync.Mutex
+}
+
+// NewBattlEyeClient creates a new BattlEye RCon client
+func NewBattlEyeClient(host string, port int, password string, logger *zap.Logger) *BattlEyeClient {
+	return &BattlEyeClient{
+		host:     host,
+		port:     port,
+		password: password,
+		logger:   logger,
+		timeout:  10 * time.Second,
+	}
+}
+
+// Connect opens the UDP socket and logs in
+func (c *BattlEyeClient) Connect() error {
+	addr := net.JoinHostPort(c.host, strconv.Itoa(c.port))
+	conn, err := net.DialTimeout("udp", addr, c.timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+
+	c.mu.Lock()
+	c.closeLocked()
+	c.conn = conn
+	c.done = make(chan struct{})
+	c.login = make(chan bool, 1)
+	c.pending = make(map[byte]*bePending)
+	c.lastMessage = -1
+	c.lastReceived = time.Now()
+	done, login := c.done, c.login
+	c.mu.Unlock()
+
+	go c.readLoop(conn, done)
+
+	if err := c.send(conn, bePacketLogin, []byte(c.password)); err != nil {
+		c.Close()
+		return fmt.Errorf("failed to send login packet: %w", err)
+	}
+
+	// UDP gives no sign of a server that is not there, only silence
+	select {
+	case ok := <-login:
+		if !ok {
+			c.Close()
+			return ErrAuthFailed
+		}
+	case <-time.After(c.timeout):
+		c.Close()
+		return fmt.Errorf("no response to login from %s", addr)
+	}
+
+	c.logger.Info("Connected to BattlEye RCon server", zap.String("addr", addr))
+
+	go c.keepAlive(done)
+	return nil
+}
+
+// Execute runs a command and returns the response, reassembled from every
+// packet the server split it into
+func (c *BattlEyeClient) Execute(command string) (string, error) {
+	if len(command) > beMaxPacketSize-beHeaderSize-2 {
+		return "", fmt.Errorf("%w: %d bytes", ErrCommandTooLong, len(command))
+	}
+
+	c.mu.Lock()
+	if c.conn == nil {
+		c.mu.Unlock()
+		return "", ErrNotConnected
+	}
+
+	// Sequence numbers wrap at 256; a command that long unanswered has timed out
+	seq := c.seq
+	c.seq++
+	pending := &bePending{done: make(chan string, 1)}
+	c.pending[seq] = pending
+	conn, done := c.conn, c.done
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		if c.pending[seq] == pending {
+			delete(c.pending, seq)
+		}
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(conn, bePacketCommand, append([]byte{seq}, command...)); err != nil {
+		c.Close()
+		return "", fmt.Errorf("failed to send command: %w", err)
+	}
+
+	select {
+	case response := <-pending.done:
+		return response, nil
+	case <-done:
+		return "", ErrNotConnected
+	case <-time.After(c.timeout):
+		return "", fmt.Errorf("no response to command after %s", c.timeout)
+	}
+}
+
+// Ping sends an empty command, which the server acknowledges without running anything
+func (c *BattlEyeClient) Ping() error {
+	_, err := c.Execute("")
+	return err
+}
+
+// Connected reports whether the socket is open and the server has been heard from recently
+func (c *BattlEyeClient) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn != nil && time.Since(c.lastReceived) < beStaleAfter
+}
+
+// Close closes the connection
+func (c *BattlEyeClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closeLocked()
+}
+
+// closeLocked closes the connection; the caller must hold c.mu
+func (c *BattlEyeClient) closeLocked() error {
+	if c.conn == nil {
+		return nil
+	}
+
+	err := c.conn.Close()
+	close(c.done)
+	c.conn = nil
+	return err
+}
+
+// send writes one packet
+func (c *BattlEyeClient) send(conn net.Conn, packetType byte, payload []byte) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(encodeBEPacket(packetType, payload)); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.lastSent = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// readLoop dispatches every packet the server sends until the connection closes
+func (c *BattlEyeClient) readLoop(conn net.Conn, done chan struct{}) {
+	buf := make([]byte, beMaxPacketSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			select {
+			case <-done:
+			default:
+				c.logger.Warn("BattlEye RCon connection failed", zap.Error(err))
+				c.mu.Lock()
+				if c.conn == conn {
+					c.closeLocked()
+				}
+				c.mu.Unlock()
+			}
+			return
+		}
+
+		packetType, payload, err := decodeBEPacket(buf[:n])
+		if err != nil {
+			c.logger.Debug("Discarding invalid BattlEye RCon packet", zap.Error(err))
+			continue
+		}
+
+		c.handle(conn, packetType, payload)
+	}
+}
+
+// handle processes one packet from the server
+func (c *BattlEyeClient) handle(conn net.Conn, packetType byte, payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastReceived = time.Now()
+
+	switch packetType {
+	case bePacketLogin:
+		if len(payload) < 1 {
+			return
+		}
+		select {
+		case c.login <- payload[0] == 0x01:
+		default:
+		}
+
+	case bePacketCommand:
+		if len(payload) < 1 {
+			return
+		}
+		pending, exists := c.pending[payload[0]]
+		if !exists {
+			return
+		}
+		body := payload[1:]
+
+		// A split response starts with 0x00, the packet count and this packet's index
+		if len(body) < 3 || body[0] != 0x00 {
+			pending.finish(body)
+			return
+		}
+		total, index := int(body[1]), int(body[2])
+		if total == 0 || index >= total {
+			return
+		}
+		if pending.parts == nil {
+			pending.parts = make([][]byte, total)
+		}
+		if index >= len(pending.parts) || pending.parts[index] != nil {
+			return
+		}
+		pending.parts[index] = append([]byte(nil), body[3:]...)
+		pending.received++
+		if pending.received == len(pending.parts) {
+			pending.finish(bytes.Join(pending.parts, nil))
+		}
+
+	case bePacketMessage:
+		if len(payload) < 1 {
+			return
+		}
+
+		// Unacknowledged messages are resent, and the client dropped if they stay so
+		seq := payload[0]
+		go c.send(conn, bePacketMessage, []byte{seq})
+
+		if int(seq) != c.lastMessage {
+			c.lastMessage = int(seq)
+			c.logger.Debug("BattlEye RCon server message", zap.String("message", string(payload[1:])))
+		}
+	}
+}
+
+// keepAlive sends an empty command whenever the connection has been idle,
+// and closes it once the server stops answering
+func (c *BattlEyeClient) keepAlive(done chan struct{}) {
+	ticker := time.NewTicker(beKeepAliveInterval / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			idle := time.Since(c.lastSent) >= beKeepAliveInterval
+			c.mu.Unlock()
+
+			if !idle {
+				continue
+			}
+			if err := c.Ping(); err != nil {
+				c.logger.Warn("BattlEye RCon keep-alive failed", zap.Error(err))
+				c.Close()
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// finish delivers a command's complete response
+func (p *bePending) finish(body []byte) {
+	select {
+	case p.done <- string(body):
+	default:
+	}
+}
+
+// encodeBEPacket frames a payload with the "BE" magic and its CRC32
+func encodeBEPacket(packetType byte, payload []byte) []byte {
+	packet := make([]byte, beHeaderSize+1+len(payload))
+	packet[0], packet[1] = 'B', 'E'
+	packet[6] = 0xFF
+	packet[7] = packetType
+	copy(packet[8:], payload)
+
+	// The checksum covers everything after itself
+	binary.LittleEndian.PutUint32(packet[2:6], crc32.ChecksumIEEE(packet[6:]))
+	return packet
+}
+
+// decodeBEPacket checks a packet's framing and checksum and returns its type and payload
+func decodeBEPacket(packet []byte) (byte, []byte, error) {
+	if len(packet) < beHeaderSize+1 || packet[0] != 'B' || packet[1] != 'E' || packet[6] != 0xFF {
+		return 0, nil, fmt.Errorf("%w: bad header", ErrInvalidPacket)
+	}
+	if binary.LittleEndian.Uint32(packet[2:6]) != crc32.ChecksumIEEE(packet[6:]) {
+		return 0, nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidPacket)
+	}
+	return packet[7], packet[8:], nil
+}
@@ -0,0 +1,153 @@
+package rcon
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fakeBattlEyeServer answers BattlEye RCon over UDP. The "split" command's
+// response comes back in three packets sent out of order, and every command
+// is preceded by a server message the client has to acknowledge.
+type fakeBattlEyeServer struct {
+	conn     net.PacketConn
+	password string
+
+	acked map[byte]bool
+	mu    sync.Mutex
+}
+
+func newFakeBattlEyeServer(t *testing.T, password string) *fakeBattlEyeServer {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	s := &fakeBattlEyeServer{conn: conn, password: password, acked: make(map[byte]bool)}
+	go s.serve()
+	return s
+}
+
+func (s *fakeBattlEyeServer) client(password string) *BattlEyeClient {
+	host, port := hostPort(s.conn.LocalAddr().String())
+	return NewBattlEyeClient(host, port, password, zap.NewNop())
+}
+
+func (s *fakeBattlEyeServer) serve() {
+	buf := make([]byte, beMaxPacketSize)
+	var messageSeq byte
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		packetType, payload, err := decodeBEPacket(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		reply := func(packetType byte, payload []byte) {
+			s.conn.WriteTo(encodeBEPacket(packetType, payload), addr)
+		}
+
+		switch packetType {
+		case bePacketLogin:
+			verdict := byte(0x00)
+			if string(payload) == s.password {
+				verdict = 0x01
+			}
+			reply(bePacketLogin, []byte{verdict})
+		case bePacketCommand:
+			reply(bePacketMessage, append([]byte{messageSeq}, "Player connected"...))
+			messageSeq++
+
+			seq, command := payload[0], string(payload[1:])
+			if command != "split" {
+				reply(bePacketCommand, append([]byte{seq}, command...))
+				continue
+			}
+			for _, index := range []byte{2, 0, 1} {
+				part := strings.Repeat(string('a'+index), 3)
+				reply(bePacketCommand, append([]byte{seq, 0x00, 3, index}, part...))
+			}
+		case bePacketMessage:
+			s.mu.Lock()
+			s.acked[payload[0]] = true
+			s.mu.Unlock()
+		}
+	}
+}
+
+func TestBattlEyeClient(t *testing.T) {
+	server := newFakeBattlEyeServer(t, "secret")
+
+	client := server.client("secret")
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer client.Close()
+
+	if response, err := client.Execute("players"); err != nil || response != "players" {
+		t.Fatalf("Execute = %q, %v, want %q", response, err, "players")
+	}
+	if response, err := client.Execute("split"); err != nil || response != "aaabbbccc" {
+		t.Fatalf("Execute split response = %q, %v, want %q", response, err, "aaabbbccc")
+	}
+	if err := client.Ping(); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if !client.Connected() {
+		t.Fatalf("client not connected")
+	}
+
+	// Acknowledgements are sent asynchronously
+	deadline := time.Now().Add(time.Second)
+	for {
+		server.mu.Lock()
+		acked := len(server.acked)
+		server.mu.Unlock()
+
+		if acked == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server messages acknowledged = %d, want 3", acked)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBattlEyeClientAuthFailure(t *testing.T) {
+	server := newFakeBattlEyeServer(t, "secret")
+
+	client := server.client("wrong")
+	if err := client.Connect(); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Connect error = %v, want %v", err, ErrAuthFailed)
+	}
+	if _, err := client.Execute("players"); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("Execute after failed login error = %v, want %v", err, ErrNotConnected)
+	}
+}
+
+func TestDecodeBEPacket(t *testing.T) {
+	packet := encodeBEPacket(bePacketCommand, []byte{7, 'h', 'i'})
+
+	packetType, payload, err := decodeBEPacket(packet)
+	if err != nil || packetType != bePacketCommand || string(payload) != "\x07hi" {
+		t.Fatalf("decodeBEPacket = %d, %q, %v", packetType, payload, err)
+	}
+
+	packet[len(packet)-1] = 'o'
+	if _, _, err := decodeBEPacket(packet); !errors.Is(err, ErrInvalidPacket) {
+		t.Fatalf("decodeBEPacket with bad checksum error = %v, want %v", err, ErrInvalidPacket)
+	}
+}
@@ -3,15 +3,20 @@ package rcon
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"go.uber.org/zap"
 )
 
-// Packet types for RCON protocol
+// Packet types for RCON protocol. A command and the server's auth response
+// share a value; which is meant depends on who sent the packet.
 const (
 	PacketTypeAuth         int32 = 3
 	PacketTypeCommand      int32 = 2
@@ -19,6 +24,66 @@ const (
 	PacketTypeAuthResponse int32 = 2
 )
 
+const (
+	// packetHeaderSize is the ID and type fields plus the body's two null terminators
+	packetHeaderSize = 10
+
+	// maxPacketSize bounds a packet read from the server. Servers send at most
+	// 4096 byte bodies; anything far past that means the stream is corrupt.
+	maxPacketSize = 64 * 1024
+
+	// maxResponseSize bounds a command's response reassembled from several packets
+	maxResponseSize = 4 * 1024 * 1024
+)
+
+// Errors returned by the client
+var (
+	ErrNotConnected   = errors.New("not connected")
+	ErrAuthFailed     = errors.New("authentication failed: invalid password")
+	ErrCommandTooLong = errors.New("command too long")
+	ErrInvalidPacket  = errors.New("invalid packet")
+	ErrInvalidCommand = errors.New("invalid command")
+)
+
+// dialect holds where games speaking the Valve protocol differ from each other
+type dialect struct {
+	name Protocol
+
+	// authPreamble is set when an empty response packet precedes the auth
+	// response, which Source servers send as a leftover of their protocol
+	authPreamble bool
+
+	// maxCommand is the longest command body the server accepts
+	maxCommand int
+
+	// fragmentSize, when set, is the body size at which the server splits a
+	// response. A shorter packet ends the response, so no sentinel is needed;
+	// otherwise an empty response packet is sent after each command and its
+	// reply marks the end.
+	fragmentSize int
+}
+
+var (
+	// sourceDialect follows the Valve developer wiki, used by Source titles, ARK and most others
+	sourceDialect = dialect{
+		name:         ProtocolSource,
+		authPreamble: true,
+		maxCommand:   4096 - packetHeaderSize,
+	}
+
+	// minecraftDialect matches the vanilla server, which splits responses into
+	// 4096 byte packets and reads at most 1446 byte commands
+	minecraftDialect = dialect{
+		name:         ProtocolMinecraft,
+		maxCommand:   1446,
+		fragmentSize: 4096,
+	}
+)
+
+// fragmentWait is how long to wait for the next packet after a full-size
+// fragment, in case the response was an exact multiple of the fragment size
+const fragmentWait = 250 * time.Millisecond
+
 // Packet represents an RCON packet
 type Packet struct {
 	Size int32
@@ -27,23 +92,33 @@ type Packet struct {
 	Body string
 }
 
-// Client is an RCON client
+// Client is a client for the Valve RCON protocol over TCP. Each request gets
+// its own packet ID so responses can be matched to it, and a response split
+// over several packets is reassembled before Execute returns.
 type Client struct {
 	host     string
 	port     int
 	password string
+	dialect  dialect
 	conn     net.Conn
+	nextID   int32
 	mu       sync.Mutex
 	logger   *zap.Logger
 	timeout  time.Duration
 }
 
-// NewClient creates a new RCON client
+// NewClient creates a new RCON client for a Source server
 func NewClient(host string, port int, password string, logger *zap.Logger) *Client {
+	return newClient(host, port, password, sourceDialect, logger)
+}
+
+// newClient creates a new RCON client speaking a dialect
+func newClient(host string, port int, password string, d dialect, logger *zap.Logger) *Client {
 	return &Client{
 		host:     host,
 		port:     port,
 		password: password,
+		dialect:  d,
 		logger:   logger,
 		timeout:  10 * time.Second,
 	}
@@ -55,7 +130,7 @@ func (c *Client) Connect() error {
 	defer c.mu.Unlock()
 
 	// Connect to RCON server
-	addr := fmt.Sprintf("%s:%d", c.host, c.port)
+	addr := net.JoinHostPort(c.host, strconv.Itoa(c.port))
 	conn, err := net.DialTimeout("tcp", addr, c.timeout)
 	if err != nil {
 		return fmt.Errorf("failed to connect: %w", err)
@@ -64,65 +139,201 @@ func (c *Client) Connect() error {
 	c.conn = conn
 	c.logger.Info("Connected to RCON server", zap.String("addr", addr))
 
-	// Authenticate
+	if err := c.authenticate(); err != nil {
+		c.closeConn()
+		return err
+	}
+
+	c.logger.Info("RCON authentication successful")
+
+	return nil
+}
+
+// authenticate sends the password and waits for the server's verdict
+func (c *Client) authenticate() error {
 	authPacket := &Packet{
-		ID:   1,
+		ID:   c.newID(),
 		Type: PacketTypeAuth,
 		Body: c.password,
 	}
 
 	if err := c.sendPacket(authPacket); err != nil {
-		c.conn.Close()
 		return fmt.Errorf("failed to send auth packet: %w", err)
 	}
 
-	// Read auth response
-	response, err := c.readPacket()
-	if err != nil {
-		c.conn.Close()
-		return fmt.Errorf("failed to read auth response: %w", err)
-	}
+	for preamble := c.dialect.authPreamble; ; preamble = false {
+		response, err := c.readPacket()
+		if err != nil {
+			return fmt.Errorf("failed to read auth response: %w", err)
+		}
+		if preamble && response.Type == PacketTypeResponse && response.Body == "" {
+			continue
+		}
+		if response.Type != PacketTypeAuthResponse {
+			return fmt.Errorf("%w: unexpected packet type %d in reply to auth", ErrInvalidPacket, response.Type)
+		}
 
-	// Check if authentication failed (ID will be -1)
-	if response.ID == -1 {
-		c.conn.Close()
-		return fmt.Errorf("authentication failed: invalid password")
+		// A failed authentication is answered with ID -1
+		if response.ID == -1 {
+			return ErrAuthFailed
+		}
+		if response.ID != authPacket.ID {
+			return fmt.Errorf("%w: auth response ID %d, expected %d", ErrInvalidPacket, response.ID, authPacket.ID)
+		}
+		return nil
 	}
-
-	c.logger.Info("RCON authentication successful")
-
-	return nil
 }
 
-// Execute sends a command and returns the response
+// Execute sends a command and returns the response, reassembled from
+// however many packets the server split it into
 func (c *Client) Execute(command string) (string, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if c.conn == nil {
-		return "", fmt.Errorf("not connected")
+		return "", ErrNotConnected
+	}
+	if len(command) > c.dialect.maxCommand {
+		return "", fmt.Errorf("%w: %d bytes, at most %d allowed", ErrCommandTooLong, len(command), c.dialect.maxCommand)
 	}
 
-	c.logger.Debug("Executing RCON command", zap.String("command", command))
+	c.logger.Debug("Executing RCON command",
+		zap.String("command", command),
+		zap.String("dialect", string(c.dialect.name)))
 
 	// Send command packet
 	cmdPacket := &Packet{
-		ID:   2,
+		ID:   c.newID(),
 		Type: PacketTypeCommand,
 		Body: command,
 	}
 
 	if err := c.sendPacket(cmdPacket); err != nil {
+		c.closeConn()
 		return "", fmt.Errorf("failed to send command: %w", err)
 	}
 
-	// Read response
-	response, err := c.readPacket()
+	var response string
+	var err error
+	if c.dialect.fragmentSize > 0 {
+		response, err = c.readFragments(cmdPacket.ID)
+	} else {
+		response, err = c.readUntilSentinel(cmdPacket.ID)
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		// The stream is out of step with the requests after a failed read
+		c.closeConn()
+		return "", err
+	}
+	return response, nil
+}
+
+// readUntilSentinel reads a response of unknown length. Servers split long
+// responses over several packets without marking the last one, so an empty
+// response packet follows the command. Servers answer requests in order, so
+// once the reply to that sentinel arrives every packet of the command's
+// response has been read.
+func (c *Client) readUntilSentinel(id int32) (string, error) {
+	sentinel := &Packet{
+		ID:   c.newID(),
+		Type: PacketTypeResponse,
+	}
+	if err := c.sendPacket(sentinel); err != nil {
+		return "", fmt.Errorf("failed to send command: %w", err)
+	}
+
+	var response strings.Builder
+	for {
+		packet, err := c.readPacket()
+		if err != nil {
+			return "", fmt.Errorf("failed to read response: %w", err)
+		}
+
+		switch packet.ID {
+		case id:
+			if err := appendResponse(&response, packet.Body); err != nil {
+				return "", err
+			}
+		case sentinel.ID:
+			return response.String(), nil
+		default:
+			// Left over from an earlier request, such as the second packet
+			// Source servers send in reply to a sentinel
+			c.logger.Debug("Discarding unmatched RCON packet",
+				zap.Int32("id", packet.ID),
+				zap.Int32("expected", id))
+		}
+	}
+}
+
+// readFragments reads a response the server splits into fixed-size packets,
+// which ends at the first packet shorter than that size
+func (c *Client) readFragments(id int32) (string, error) {
+	var response strings.Builder
+	full := false
+	for {
+		// After a full fragment, only wait a moment for a next one that may never come
+		wait := c.timeout
+		if full {
+			wait = fragmentWait
+		}
+
+		packet, err := c.readPacketWithin(wait)
+		if full && isTimeout(err) {
+			// The response was an exact multiple of the fragment size
+			return response.String(), nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read response: %w", err)
+		}
+
+		if packet.ID != id {
+			c.logger.Debug("Discarding unmatched RCON packet",
+				zap.Int32("id", packet.ID),
+				zap.Int32("expected", id))
+			continue
+		}
+		if err := appendResponse(&response, packet.Body); err != nil {
+			return "", err
+		}
+
+		full = len(packet.Body) >= c.dialect.fragmentSize
+		if !full {
+			return response.String(), nil
+		}
 	}
+}
+
+// appendResponse adds a packet's body to a response, bounding its total size
+func appendResponse(response *strings.Builder, body string) error {
+	if response.Len()+len(body) > maxResponseSize {
+		return fmt.Errorf("%w: response exceeds %d bytes", ErrInvalidPacket, maxResponseSize)
+	}
+	response.WriteString(body)
+	return nil
+}
 
-	return response.Body, nil
+// isTimeout reports whether err is a network timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Ping checks the connection is alive with an empty command, which servers
+// answer without doing anything. Packets of other types are avoided, since
+// not every server tolerates them.
+func (c *Client) Ping() error {
+	_, err := c.Execute("")
+	return err
+}
+
+// Connected reports whether the client holds an open connection. A client
+// whose connection broke during a request is no longer connected.
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn != nil
 }
 
 // Close closes the RCON connection
@@ -130,6 +341,11 @@ func (c *Client) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	return c.closeConn()
+}
+
+// closeConn closes the connection; the caller must hold c.mu
+func (c *Client) closeConn() error {
 	if c.conn != nil {
 		err := c.conn.Close()
 		c.conn = nil
@@ -139,6 +355,21 @@ func (c *Client) Close() error {
 	return nil
 }
 
+// newID returns the next request ID
+func (c *Client) newID() int32 {
+	c.nextID = nextRequestID(c.nextID)
+	return c.nextID
+}
+
+// nextRequestID returns the ID following last. IDs stay positive, since
+// servers use -1 and 0 for replies that answer no request.
+func nextRequestID(last int32) int32 {
+	if last <= 0 || last == 1<<31-1 {
+		return 1
+	}
+	return last + 1
+}
+
 // sendPacket sends an RCON packet
 func (c *Client) sendPacket(packet *Packet) error {
 	// Set connection deadline
@@ -146,152 +377,65 @@ func (c *Client) sendPacket(packet *Packet) error {
 		return err
 	}
 
-	// Calculate size (ID + Type + Body + 2 null terminators)
-	packet.Size = int32(10 + len(packet.Body))
-
-	// Build packet buffer
-	buf := new(bytes.Buffer)
+	return writePacket(c.conn, packet)
+}
 
-	// Write packet size
-	if err := binary.Write(buf, binary.LittleEndian, packet.Size); err != nil {
-		return err
-	}
+// readPacket reads an RCON packet
+func (c *Client) readPacket() (*Packet, error) {
+	return c.readPacketWithin(c.timeout)
+}
 
-	// Write packet ID
-	if err := binary.Write(buf, binary.LittleEndian, packet.ID); err != nil {
-		return err
+// readPacketWithin reads an RCON packet, waiting at most timeout for it
+func (c *Client) readPacketWithin(timeout time.Duration) (*Packet, error) {
+	// Set connection deadline
+	if err := c.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
 	}
 
-	// Write packet type
-	if err := binary.Write(buf, binary.LittleEndian, packet.Type); err != nil {
-		return err
-	}
+	return readPacket(c.conn)
+}
 
-	// Write body
-	if _, err := buf.WriteString(packet.Body); err != nil {
-		return err
-	}
+// writePacket encodes a packet and writes it in a single write
+func writePacket(w io.Writer, packet *Packet) error {
+	// Calculate size (ID + Type + Body + 2 null terminators)
+	packet.Size = int32(packetHeaderSize + len(packet.Body))
 
-	// Write null terminators
-	if err := buf.WriteByte(0); err != nil {
-		return err
-	}
-	if err := buf.WriteByte(0); err != nil {
-		return err
-	}
+	buf := bytes.NewBuffer(make([]byte, 0, 4+packet.Size))
+	binary.Write(buf, binary.LittleEndian, packet.Size)
+	binary.Write(buf, binary.LittleEndian, packet.ID)
+	binary.Write(buf, binary.LittleEndian, packet.Type)
+	buf.WriteString(packet.Body)
+	buf.Write([]byte{0, 0})
 
-	// Send packet
-	_, err := c.conn.Write(buf.Bytes())
+	_, err := w.Write(buf.Bytes())
 	return err
 }
 
-// readPacket reads an RCON packet
-func (c *Client) readPacket() (*Packet, error) {
-	// Set connection deadline
-	if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
-		return nil, err
-	}
-
+// readPacket reads one packet, using its length prefix to frame it
+func readPacket(r io.Reader) (*Packet, error) {
 	// Read packet size
 	var size int32
-	if err := binary.Read(c.conn, binary.LittleEndian, &size); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
 		return nil, err
 	}
+	if size < packetHeaderSize || size > maxPacketSize {
+		return nil, fmt.Errorf("%w: size %d", ErrInvalidPacket, size)
+	}
 
-	// Read packet data
+	// A packet may arrive over several reads
 	data := make([]byte, size)
-	if _, err := c.conn.Read(data); err != nil {
+	if _, err := io.ReadFull(r, data); err != nil {
 		return nil, err
 	}
 
-	// Parse packet
 	packet := &Packet{
 		Size: size,
+		ID:   int32(binary.LittleEndian.Uint32(data[0:4])),
+		Type: int32(binary.LittleEndian.Uint32(data[4:8])),
 	}
 
-	buf := bytes.NewReader(data)
-
-	// Read ID
-	if err := binary.Read(buf, binary.LittleEndian, &packet.ID); err != nil {
-		return nil, err
-	}
-
-	// Read Type
-	if err := binary.Read(buf, binary.LittleEndian, &packet.Type); err != nil {
-		return nil, err
-	}
-
-	// Read Body (everything except last 2 null bytes)
-	bodyLen := len(data) - 10
-	if bodyLen > 0 {
-		bodyBytes := make([]byte, bodyLen)
-		if _, err := buf.Read(bodyBytes); err != nil {
-			return nil, err
-		}
-		packet.Body = string(bodyBytes)
-	}
+	// The body ends in two null terminators, though some servers send only one
+	packet.Body = strings.TrimRight(string(data[8:]), "\x00")
 
 	return packet, nil
 }
-
-// Pool manages a pool of RCON connections
-type Pool struct {
-	clients map[string]*Client // serverID -> Client
-	mu      sync.RWMutex
-	logger  *zap.Logger
-}
-
-// NewPool creates a new RCON connection pool
-func NewPool(logger *zap.Logger) *Pool {
-	return &Pool{
-		clients: make(map[string]*Client),
-		logger:  logger,
-	}
-}
-
-// GetClient gets or creates an RCON client for a server
-func (p *Pool) GetClient(serverID, host string, port int, password string) (*Client, error) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	// Check if client exists
-	if client, exists := p.clients[serverID]; exists {
-		return client, nil
-	}
-
-	// Create new client
-	client := NewClient(host, port, password, p.logger)
-	if err := client.Connect(); err != nil {
-		return nil, err
-	}
-
-	p.clients[serverID] = client
-	p.logger.Info("Created new RCON client", zap.String("serverID", serverID))
-
-	return client, nil
-}
-
-// RemoveClient removes and closes an RCON client
-func (p *Pool) RemoveClient(serverID string) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	if client, exists := p.clients[serverID]; exists {
-		client.Close()
-		delete(p.clients, serverID)
-		p.logger.Info("Removed RCON client", zap.String("serverID", serverID))
-	}
-}
-
-// CloseAll closes all RCON connections
-func (p *Pool) CloseAll() {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	for serverID, client := range p.clients {
-		client.Close()
-		p.logger.Info("Closed RCON client", zap.String("serverID", serverID))
-	}
-
-	p.clients = make(map[string]*Client)
-}
@@ -0,0 +1,193 @@
+package rcon
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// fakeServer is an RCON server speaking a dialect. It splits long responses
+// into 4096 byte packets; as Source it answers sentinels the way Source
+// servers do, while as Minecraft it hangs up on them to prove none are sent.
+type fakeServer struct {
+	listener net.Listener
+	password string
+	dialect  dialect
+	stale    bool // Send a stray packet ahead of each response
+
+	conns []net.Conn
+	mu    sync.Mutex
+}
+
+func newFakeServer(t *testing.T, password string) *fakeServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &fakeServer{listener: listener, password: password, dialect: sourceDialect}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) client(t *testing.T, password string) *Client {
+	t.Helper()
+
+	host, port := s.addr()
+	return newClient(host, port, password, s.dialect, zap.NewNop())
+}
+
+// addr returns the host and port the server listens on
+func (s *fakeServer) addr() (string, int) {
+	return hostPort(s.listener.Addr().String())
+}
+
+// hostPort splits a fake server's listen address for the client constructors
+func hostPort(addr string) (string, int) {
+	host, port, _ := net.SplitHostPort(addr)
+	portNum, _ := strconv.Atoi(port)
+	return host, portNum
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+// shutdown stops the server and drops every connection, as a crash would
+func (s *fakeServer) shutdown() {
+	s.listener.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+
+		var replies []*Packet
+		switch packet.Type {
+		case PacketTypeAuth:
+			id := packet.ID
+			if packet.Body != s.password {
+				id = -1
+			}
+			if s.dialect.authPreamble {
+				replies = append(replies, &Packet{ID: packet.ID, Type: PacketTypeResponse})
+			}
+			replies = append(replies, &Packet{ID: id, Type: PacketTypeAuthResponse})
+		case PacketTypeCommand:
+			if s.stale {
+				replies = append(replies, &Packet{ID: packet.ID - 100, Type: PacketTypeResponse, Body: "stale"})
+			}
+			body := packet.Body
+			if strings.HasPrefix(body, "repeat ") {
+				n, _ := strconv.Atoi(strings.TrimPrefix(body, "repeat "))
+				body = strings.Repeat("x", n)
+			}
+			for len(body) > 4096 {
+				replies = append(replies, &Packet{ID: packet.ID, Type: PacketTypeResponse, Body: body[:4096]})
+				body = body[4096:]
+			}
+			replies = append(replies, &Packet{ID: packet.ID, Type: PacketTypeResponse, Body: body})
+		case PacketTypeResponse:
+			if s.dialect.fragmentSize > 0 {
+				return
+			}
+			replies = []*Packet{{ID: packet.ID, Type: PacketTypeResponse}, {ID: packet.ID, Type: PacketTypeResponse, Body: "\x00\x01\x00\x00"}}
+		}
+
+		// Written a byte at a time so the client sees short reads
+		var buf bytes.Buffer
+		for _, reply := range replies {
+			writePacket(&buf, reply)
+		}
+		for _, b := range buf.Bytes() {
+			if _, err := conn.Write([]byte{b}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestClientExecute(t *testing.T) {
+	for _, d := range []dialect{sourceDialect, minecraftDialect} {
+		t.Run(string(d.name), func(t *testing.T) {
+			server := newFakeServer(t, "secret")
+			server.dialect = d
+			server.stale = true
+
+			client := server.client(t, "secret")
+			if err := client.Connect(); err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+			defer client.Close()
+
+			for _, n := range []int{0, 10, 4096, 10000} {
+				response, err := client.Execute("repeat " + strconv.Itoa(n))
+				if err != nil {
+					t.Fatalf("Execute with %d byte response: %v", n, err)
+				}
+				if response != strings.Repeat("x", n) {
+					t.Fatalf("Execute returned %d bytes, want %d", len(response), n)
+				}
+			}
+
+			if _, err := client.Execute(strings.Repeat("x", d.maxCommand+1)); !errors.Is(err, ErrCommandTooLong) {
+				t.Fatalf("Execute with long command error = %v, want %v", err, ErrCommandTooLong)
+			}
+		})
+	}
+}
+
+func TestClientAuthFailure(t *testing.T) {
+	server := newFakeServer(t, "secret")
+
+	client := server.client(t, "wrong")
+	if err := client.Connect(); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Connect error = %v, want %v", err, ErrAuthFailed)
+	}
+	if _, err := client.Execute("list"); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("Execute after failed auth error = %v, want %v", err, ErrNotConnected)
+	}
+}
+
+func TestReadPacketSize(t *testing.T) {
+	for _, size := range []int32{-1, 9, maxPacketSize + 1} {
+		var buf bytes.Buffer
+		writePacket(&buf, &Packet{ID: 1})
+		data := buf.Bytes()
+		data[0], data[1], data[2], data[3] = byte(size), byte(size>>8), byte(size>>16), byte(size>>24)
+
+		if _, err := readPacket(bytes.NewReader(data)); !errors.Is(err, ErrInvalidPacket) {
+			t.Fatalf("readPacket with size %d error = %v, want %v", size, err, ErrInvalidPacket)
+		}
+	}
+}
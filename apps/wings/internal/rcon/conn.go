@@ -0,0 +1,74 @@
+package rcon
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// Protocol identifies the remote console protocol a game speaks
+type Protocol string
+
+const (
+	ProtocolSource    Protocol = "source"    // Valve's Source RCON over TCP
+	ProtocolMinecraft Protocol = "minecraft" // Minecraft's variant of Source RCON
+	ProtocolBattlEye  Protocol = "battleye"  // BattlEye RCon over UDP, used by DayZ and Arma
+	ProtocolTelnet    Protocol = "telnet"    // Line-based telnet console, as 7 Days to Die serves
+	ProtocolWebRCON   Protocol = "webrcon"   // JSON over WebSocket, used by Rust
+)
+
+// Validate checks the protocol is known, treating empty as ProtocolSource
+func (p Protocol) Validate() error {
+	switch p {
+	case "", ProtocolSource, ProtocolMinecraft, ProtocolBattlEye, ProtocolTelnet, ProtocolWebRCON:
+		return nil
+	}
+	return fmt.Errorf("unknown rcon protocol %q", p)
+}
+
+// Conn is a connection to a game server's remote console, whatever the
+// protocol underneath
+type Conn interface {
+	// Connect opens the connection and logs in
+	Connect() error
+	// Execute runs a command and returns the server's complete response
+	Execute(command string) (string, error)
+	// Ping checks the connection is alive, sending an empty command or the
+	// protocol's keepalive, either of which the game ignores
+	Ping() error
+	// Connected reports whether the connection is open
+	Connected() bool
+	Close() error
+}
+
+var (
+	_ Conn = (*Client)(nil)
+	_ Conn = (*BattlEyeClient)(nil)
+	_ Conn = (*TelnetClient)(nil)
+	_ Conn = (*WebRCONClient)(nil)
+)
+
+// Target is where a server's remote console is reached and how to log in
+type Target struct {
+	Protocol Protocol
+	Host     string
+	Port     int
+	Password string
+}
+
+// NewConn creates an unconnected client speaking the target's protocol
+func NewConn(target Target, logger *zap.Logger) (Conn, error) {
+	switch target.Protocol {
+	case "", ProtocolSource:
+		return newClient(target.Host, target.Port, target.Password, sourceDialect, logger), nil
+	case ProtocolMinecraft:
+		return newClient(target.Host, target.Port, target.Password, minecraftDialect, logger), nil
+	case ProtocolBattlEye:
+		return NewBattlEyeClient(target.Host, target.Port, target.Password, logger), nil
+	case ProtocolTelnet:
+		return NewTelnetClient(target.Host, target.Port, target.Password, logger), nil
+	case ProtocolWebRCON:
+		return NewWebRCONClient(target.Host, target.Port, target.Password, logger), nil
+	}
+	return nil, target.Protocol.Validate()
+}
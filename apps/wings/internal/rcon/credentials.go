@@ -0,0 +1,93 @@
+package rcon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrNoPassword is returned for a server with no stored RCON password
+var ErrNoPassword = errors.New("no rcon password stored for server")
+
+// Credentials keeps servers' RCON passwords in Wings' state directory,
+// readable only by the daemon. Container labels would show them to anyone
+// who can inspect the container and copy them into every Docker event.
+type Credentials struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// storedCredentials is the saved form of one server's credentials
+type storedCredentials struct {
+	Password string `json:"password"`
+}
+
+// NewCredentials creates a credential store under stateDir
+func NewCredentials(stateDir string) *Credentials {
+	return &Credentials{
+		dir: filepath.Join(stateDir, "rcon"),
+	}
+}
+
+// Password returns a server's RCON password
+func (c *Credentials) Password(serverID string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := os.ReadFile(c.path(serverID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrNoPassword
+		}
+		return "", fmt.Errorf("failed to read rcon password: %w", err)
+	}
+
+	var stored storedCredentials
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return "", fmt.Errorf("failed to parse rcon credentials: %w", err)
+	}
+	return stored.Password, nil
+}
+
+// SetPassword stores a server's RCON password, replacing any earlier one.
+// Pooled connections pick it up on the next command.
+func (c *Credentials) SetPassword(serverID, password string) error {
+	if password == "" {
+		return fmt.Errorf("rcon password is required")
+	}
+
+	data, err := json.Marshal(storedCredentials{Password: password})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create rcon state directory: %w", err)
+	}
+	if err := os.WriteFile(c.path(serverID), data, 0600); err != nil {
+		return fmt.Errorf("failed to save rcon password: %w", err)
+	}
+	return nil
+}
+
+// Remove forgets a server's RCON password
+func (c *Credentials) Remove(serverID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.Remove(c.path(serverID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove rcon password: %w", err)
+	}
+	return nil
+}
+
+// path returns where a server's credentials are saved
+func (c *Credentials) path(serverID string) string {
+	return filepath.Join(c.dir, serverID+".json")
+}
@@ -0,0 +1,44 @@
+package rcon
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestCredentials(t *testing.T) {
+	creds := NewCredentials(t.TempDir())
+
+	if _, err := creds.Password("srv"); !errors.Is(err, ErrNoPassword) {
+		t.Fatalf("Password before SetPassword error = %v, want %v", err, ErrNoPassword)
+	}
+
+	if err := creds.SetPassword("srv", "first"); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	if err := creds.SetPassword("srv", "rotated"); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	if password, err := creds.Password("srv"); err != nil || password != "rotated" {
+		t.Fatalf("Password = %q, %v, want %q", password, err, "rotated")
+	}
+
+	// Only the daemon may read the password
+	info, err := os.Stat(creds.path("srv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("credentials mode = %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	if err := creds.Remove("srv"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := creds.Password("srv"); !errors.Is(err, ErrNoPassword) {
+		t.Fatalf("Password after Remove error = %v, want %v", err, ErrNoPassword)
+	}
+	if err := creds.Remove("srv"); err != nil {
+		t.Fatalf("Remove of missing credentials: %v", err)
+	}
+}
@@ -0,0 +1,421 @@
+package rcon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// poolCheckInterval is how often pooled connections are health checked
+	poolCheckInterval = 30 * time.Second
+
+	// poolIdleTimeout is how long an unused connection is kept open
+	poolIdleTimeout = 10 * time.Minute
+
+	// Reconnect attempts back off exponentially between these bounds
+	reconnectBackoffBase = time.Second
+	reconnectBackoffMax  = 2 * time.Minute
+)
+
+// ErrReconnecting is returned while a server's connection is down and the
+// next reconnect attempt is still backing off
+var ErrReconnecting = errors.New("rcon connection is down, waiting to reconnect")
+
+// PoolStats describes the state of one server's pooled connection
+type PoolStats struct {
+	ServerID       string `json:"serverId"`
+	Connected      bool   `json:"connected"`
+	Reconnecting   bool   `json:"reconnecting"` // Down after a failed attempt, waiting to try again
+	Failures       int    `json:"failures"`     // Consecutive failed connection attempts
+	ConnectedSince string `json:"connectedSince,omitempty"`
+	LastUsed       string `json:"lastUsed,omitempty"`
+	LastError      string `json:"lastError,omitempty"`
+	LastErrorAt    string `json:"lastErrorAt,omitempty"`
+}
+
+// poolEntry is one server's connection along with what is needed to remake it
+type poolEntry struct {
+	serverID string
+	target   Target
+
+	// mu guards the fields and serializes connecting, so concurrent requests
+	// share one reconnect. Health checks ping without it.
+	mu          sync.Mutex
+	client      Conn // nil until the first successful connect
+	connectedAt time.Time
+	lastUsed    time.Time
+	failures    int
+	nextAttempt time.Time
+	lastError   error
+	lastErrorAt time.Time
+}
+
+// Pool manages a pool of RCON connections. Broken connections are noticed
+// by periodic health checks or by the request that hits them and are
+// reconnected with backoff; connections left unused are closed.
+type Pool struct {
+	entries map[string]*poolEntry // serverID -> entry
+	mu      sync.Mutex
+	logger  *zap.Logger
+
+	// Control
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewPool creates a new RCON connection pool
+func NewPool(logger *zap.Logger) *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Pool{
+		entries: make(map[string]*poolEntry),
+		logger:  logger,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Start runs the maintenance loop, health checking and evicting connections on an interval
+func (p *Pool) Start() {
+	ticker := time.NewTicker(poolCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.maintain()
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop stops the maintenance loop and closes all connections
+func (p *Pool) Stop() {
+	p.cancel()
+	p.CloseAll()
+}
+
+// GetClient gets a connected RCON client for a server, connecting or
+// reconnecting as needed. Changed connection details replace the old client.
+func (p *Pool) GetClient(serverID string, target Target) (Conn, error) {
+	if err := target.Protocol.Validate(); err != nil {
+		return nil, err
+	}
+	entry := p.entry(serverID, target)
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	entry.lastUsed = time.Now()
+	if entry.client != nil && entry.client.Connected() {
+		return entry.client, nil
+	}
+
+	if wait := time.Until(entry.nextAttempt); wait > 0 {
+		return nil, fmt.Errorf("%w: next attempt in %s, last error: %v", ErrReconnecting, wait.Round(time.Second), entry.lastError)
+	}
+
+	if err := p.connect(entry); err != nil {
+		return nil, err
+	}
+	return entry.client, nil
+}
+
+// Execute runs a command on a server through its pooled connection. A
+// command that breaks the connection is not retried, since the server may
+// already have run it; the next request reconnects.
+func (p *Pool) Execute(serverID string, target Target, command string) (string, error) {
+	client, err := p.GetClient(serverID, target)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := client.Execute(command)
+	if err != nil {
+		p.recordError(serverID, client, err)
+		return "", err
+	}
+	return response, nil
+}
+
+// Stats returns the state of a server's pooled connection
+func (p *Pool) Stats(serverID string) (PoolStats, bool) {
+	p.mu.Lock()
+	entry, exists := p.entries[serverID]
+	p.mu.Unlock()
+
+	if !exists {
+		return PoolStats{}, false
+	}
+	return entry.stats(), true
+}
+
+// AllStats returns the state of every pooled connection, ordered by server ID
+func (p *Pool) AllStats() []PoolStats {
+	p.mu.Lock()
+	entries := make([]*poolEntry, 0, len(p.entries))
+	for _, entry := range p.entries {
+		entries = append(entries, entry)
+	}
+	p.mu.Unlock()
+
+	stats := make([]PoolStats, 0, len(entries))
+	for _, entry := range entries {
+		stats = append(stats, entry.stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ServerID < stats[j].ServerID })
+	return stats
+}
+
+// RemoveClient removes and closes an RCON client
+func (p *Pool) RemoveClient(serverID string) {
+	p.mu.Lock()
+	entry, exists := p.entries[serverID]
+	delete(p.entries, serverID)
+	p.mu.Unlock()
+
+	if exists {
+		entry.close()
+		p.logger.Info("Removed RCON client", zap.String("serverID", serverID))
+	}
+}
+
+// CloseAll closes all RCON connections
+func (p *Pool) CloseAll() {
+	p.mu.Lock()
+	entries := p.entries
+	p.entries = make(map[string]*poolEntry)
+	p.mu.Unlock()
+
+	for serverID, entry := range entries {
+		entry.close()
+		p.logger.Info("Closed RCON client", zap.String("serverID", serverID))
+	}
+}
+
+// entry returns a server's pool entry, replacing it if its connection details changed
+func (p *Pool) entry(serverID string, target Target) *poolEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, exists := p.entries[serverID]
+	if exists && entry.target == target {
+		return entry
+	}
+	if exists {
+		// Closed outside the pool lock, since a request may be holding the entry
+		go entry.close()
+	}
+
+	entry = &poolEntry{
+		serverID: serverID,
+		target:   target,
+	}
+	p.entries[serverID] = entry
+	return entry
+}
+
+// connect opens a new connection for an entry; the caller must hold entry.mu
+func (p *Pool) connect(entry *poolEntry) error {
+	if entry.client != nil {
+		entry.client.Close()
+	}
+
+	client, err := NewConn(entry.target, p.logger)
+	if err != nil {
+		return err
+	}
+	if err := client.Connect(); err != nil {
+		entry.client = nil
+		entry.failures++
+		entry.lastError = err
+		entry.lastErrorAt = time.Now()
+		entry.nextAttempt = time.Now().Add(reconnectBackoff(entry.failures))
+
+		p.logger.Warn("Failed to connect RCON client",
+			zap.String("serverID", entry.serverID),
+			zap.Int("failures", entry.failures),
+			zap.Time("nextAttempt", entry.nextAttempt),
+			zap.Error(err))
+		return err
+	}
+
+	if entry.failures > 0 {
+		p.logger.Info("Reconnected RCON client",
+			zap.String("serverID", entry.serverID),
+			zap.Int("failures", entry.failures))
+	} else {
+		p.logger.Info("Created new RCON client",
+			zap.String("serverID", entry.serverID),
+			zap.String("protocol", string(entry.target.Protocol)))
+	}
+
+	entry.client = client
+	entry.connectedAt = time.Now()
+	entry.failures = 0
+	entry.nextAttempt = time.Time{}
+	return nil
+}
+
+// recordError notes a failed request against the entry that served it
+func (p *Pool) recordError(serverID string, client Conn, err error) {
+	p.mu.Lock()
+	entry, exists := p.entries[serverID]
+	p.mu.Unlock()
+	if !exists {
+		return
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.client != client {
+		return
+	}
+	entry.lastError = err
+	entry.lastErrorAt = time.Now()
+
+	if !client.Connected() {
+		p.logger.Warn("RCON connection broke",
+			zap.String("serverID", serverID),
+			zap.Error(err))
+	}
+}
+
+// maintain evicts idle entries, pings live connections and reconnects broken
+// ones. Entries are checked in parallel, so one unresponsive server does not
+// hold up the rest.
+func (p *Pool) maintain() {
+	p.mu.Lock()
+	entries := make([]*poolEntry, 0, len(p.entries))
+	for _, entry := range p.entries {
+		entries = append(entries, entry)
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for _, entry := range entries {
+		if p.ctx.Err() != nil {
+			return
+		}
+
+		entry.mu.Lock()
+		idle := time.Since(entry.lastUsed) > poolIdleTimeout
+		entry.mu.Unlock()
+
+		if idle {
+			p.mu.Lock()
+			if p.entries[entry.serverID] == entry {
+				delete(p.entries, entry.serverID)
+			}
+			p.mu.Unlock()
+
+			entry.close()
+			p.logger.Debug("Evicted idle RCON client", zap.String("serverID", entry.serverID))
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.check(entry)
+		}()
+	}
+}
+
+// check pings an entry's connection, reconnecting it once its backoff has passed if it is down
+func (p *Pool) check(entry *poolEntry) {
+	entry.mu.Lock()
+	client := entry.client
+	entry.mu.Unlock()
+
+	// Pinged without the entry lock, so requests and stats are not held up
+	// while a server that stopped answering times out
+	if client != nil && client.Connected() {
+		err := client.Ping()
+		if err == nil {
+			return
+		}
+
+		entry.mu.Lock()
+		if entry.client == client {
+			entry.lastError = err
+			entry.lastErrorAt = time.Now()
+		}
+		entry.mu.Unlock()
+
+		p.logger.Warn("RCON health check failed",
+			zap.String("serverID", entry.serverID),
+			zap.Error(err))
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	// A request may have reconnected while the ping ran
+	if entry.client != client && entry.client != nil && entry.client.Connected() {
+		return
+	}
+	if time.Now().Before(entry.nextAttempt) {
+		return
+	}
+	p.connect(entry)
+}
+
+// stats returns a snapshot of the entry's state
+func (e *poolEntry) stats() PoolStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stats := PoolStats{
+		ServerID:  e.serverID,
+		Connected: e.client != nil && e.client.Connected(),
+		Failures:  e.failures,
+		LastUsed:  formatTime(e.lastUsed),
+	}
+	stats.Reconnecting = !stats.Connected && e.failures > 0
+	if stats.Connected {
+		stats.ConnectedSince = formatTime(e.connectedAt)
+	}
+	if e.lastError != nil {
+		stats.LastError = e.lastError.Error()
+		stats.LastErrorAt = formatTime(e.lastErrorAt)
+	}
+	return stats
+}
+
+// close closes the entry's connection
+func (e *poolEntry) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client != nil {
+		e.client.Close()
+	}
+}
+
+// reconnectBackoff returns the delay before the next attempt after failures consecutive failures
+func reconnectBackoff(failures int) time.Duration {
+	backoff := reconnectBackoffBase
+	for i := 1; i < failures && backoff < reconnectBackoffMax; i++ {
+		backoff *= 2
+	}
+	return min(backoff, reconnectBackoffMax)
+}
+
+// formatTime formats a time for stats, or returns "" for the zero time
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,137 @@
+package rcon
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestPoolReconnects(t *testing.T) {
+	server := newFakeServer(t, "secret")
+	host, port := server.addr()
+	target := Target{Host: host, Port: port, Password: "secret"}
+
+	pool := NewPool(zap.NewNop())
+	defer pool.Stop()
+
+	if _, err := pool.Execute("srv", target, "list"); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	client, _ := pool.GetClient("srv", target)
+
+	// A connection that broke is replaced on the next request
+	client.Close()
+	if stats, _ := pool.Stats("srv"); stats.Connected || stats.Reconnecting {
+		t.Fatalf("stats after broken connection = %+v", stats)
+	}
+	if _, err := pool.Execute("srv", target, "list"); err != nil {
+		t.Fatalf("Execute after broken connection: %v", err)
+	}
+	if stats, _ := pool.Stats("srv"); !stats.Connected || stats.Failures != 0 {
+		t.Fatalf("stats after reconnect = %+v", stats)
+	}
+
+	// Once the server goes away, the health check notices and reconnecting backs off
+	server.shutdown()
+	pool.maintain()
+
+	stats, _ := pool.Stats("srv")
+	if stats.Connected || !stats.Reconnecting || stats.Failures != 1 || stats.LastError == "" {
+		t.Fatalf("stats after server shutdown = %+v", stats)
+	}
+	if _, err := pool.Execute("srv", target, "list"); !errors.Is(err, ErrReconnecting) {
+		t.Fatalf("Execute while backing off error = %v, want %v", err, ErrReconnecting)
+	}
+}
+
+// hangingConn is a connection to a server that stopped answering
+type hangingConn struct {
+	release chan struct{}
+}
+
+func (c *hangingConn) Connect() error                 { return nil }
+func (c *hangingConn) Execute(string) (string, error) { return "", nil }
+func (c *hangingConn) Connected() bool                { return true }
+func (c *hangingConn) Close() error                   { return nil }
+
+func (c *hangingConn) Ping() error {
+	<-c.release
+	return nil
+}
+
+func TestPoolHealthCheckDoesNotBlock(t *testing.T) {
+	pool := NewPool(zap.NewNop())
+	defer pool.Stop()
+
+	hanging := &hangingConn{release: make(chan struct{})}
+	for _, serverID := range []string{"hanging", "other"} {
+		pool.entries[serverID] = &poolEntry{serverID: serverID, client: hanging, lastUsed: time.Now()}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		pool.maintain()
+		close(done)
+	}()
+
+	// Stats are served while pings are outstanding
+	stats := make(chan []PoolStats)
+	go func() { stats <- pool.AllStats() }()
+	select {
+	case got := <-stats:
+		if len(got) != 2 || !got[0].Connected {
+			t.Fatalf("stats during health check = %+v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stats blocked behind a health check")
+	}
+
+	close(hanging.release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("health check did not finish")
+	}
+}
+
+func TestPoolEvictsIdle(t *testing.T) {
+	server := newFakeServer(t, "secret")
+	host, port := server.addr()
+	target := Target{Host: host, Port: port, Password: "secret"}
+
+	pool := NewPool(zap.NewNop())
+	defer pool.Stop()
+
+	client, err := pool.GetClient("srv", target)
+	if err != nil {
+		t.Fatalf("GetClient: %v", err)
+	}
+
+	pool.entries["srv"].lastUsed = time.Now().Add(-poolIdleTimeout - time.Second)
+	pool.maintain()
+
+	if _, exists := pool.Stats("srv"); exists {
+		t.Fatalf("idle client was not evicted")
+	}
+	if client.Connected() {
+		t.Fatalf("evicted client is still connected")
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	for _, tt := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{100, reconnectBackoffMax},
+	} {
+		if got := reconnectBackoff(tt.failures); got != tt.want {
+			t.Fatalf("reconnectBackoff(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
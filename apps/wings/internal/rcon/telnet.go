@@ -0,0 +1,390 @@
+package rcon
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// What the 7 Days to Die console prints around logging in
+const (
+	telnetPasswordPrompt = "enter password:"
+	telnetLogonOK        = "Logon successful"
+	telnetLogonFailed    = "Password incorrect"
+)
+
+// Telnet control bytes, see RFC 854
+const (
+	telnetIAC  byte = 255
+	telnetSB   byte = 250
+	telnetSE   byte = 240
+	telnetWILL byte = 251
+	telnetDONT byte = 254
+)
+
+const (
+	// telnetQuietPeriod is how long the console stays silent after a command's
+	// output before the response is taken to be complete. The console never
+	// marks where output ends, but prints it all at once.
+	telnetQuietPeriod = 250 * time.Millisecond
+
+	// telnetLineBuffer is how many unread lines are kept while no command is running
+	telnetLineBuffer = 256
+)
+
+// telnetLogLine matches the console's log output, which is interleaved with
+// command output, e.g. "2024-05-01T12:00:00 123.456 INF Player connected"
+var telnetLogLine = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2} \d+\.\d+ [A-Z]{3} `)
+
+// TelnetClient is a client for the line-based telnet console 7 Days to Die
+// serves. The console has no request IDs, so a command's output is found by
+// the "Executing command" line the server logs before running it, and ends
+// once the console falls quiet; log lines in between are left out.
+type TelnetClient struct {
+	host     string
+	port     int
+	password string
+	logger   *zap.Logger
+	timeout  time.Duration
+
+	conn  net.Conn
+	done  chan struct{} // Closed when conn is closed
+	lines chan string   // Lines read from the console and not yet consumed
+	mu    sync.Mutex
+
+	// execMu runs one command at a time, since output can only be told apart by order
+	execMu sync.Mutex
+}
+
+// NewTelnetClient creates a new telnet console client
+func NewTelnetClient(host string, port int, password string, logger *zap.Logger) *TelnetClient {
+	return &TelnetClient{
+		host:     host,
+		port:     port,
+		password: password,
+		logger:   logger,
+		timeout:  10 * time.Second,
+	}
+}
+
+// Connect opens the connection and answers the password prompt
+func (c *TelnetClient) Connect() error {
+	addr := net.JoinHostPort(c.host, strconv.Itoa(c.port))
+	conn, err := net.DialTimeout("tcp", addr, c.timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+
+	r := &telnetReader{r: bufio.NewReader(conn)}
+	if err := c.login(conn, r); err != nil {
+		conn.Close()
+		return err
+	}
+
+	c.mu.Lock()
+	c.closeLocked()
+	c.conn = conn
+	c.done = make(chan struct{})
+	c.lines = make(chan string, telnetLineBuffer)
+	done, lines := c.done, c.lines
+	c.mu.Unlock()
+
+	go c.readLoop(conn, r, lines, done)
+
+	c.logger.Info("Connected to telnet console", zap.String("addr", addr))
+	return nil
+}
+
+// login waits for the password prompt, sends the password and reads the verdict
+func (c *TelnetClient) login(conn net.Conn, r *telnetReader) error {
+	if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+
+	if _, err := r.readUntil(telnetPasswordPrompt); err != nil {
+		return fmt.Errorf("no password prompt: %w", err)
+	}
+	if _, err := conn.Write([]byte(c.password + "\r\n")); err != nil {
+		return fmt.Errorf("failed to send password: %w", err)
+	}
+
+	// A wrong password is answered with another prompt, after the failure message
+	verdict, err := r.readUntil(telnetLogonOK, telnetLogonFailed)
+	if err != nil {
+		return fmt.Errorf("failed to read login response: %w", err)
+	}
+	if verdict == telnetLogonFailed {
+		return ErrAuthFailed
+	}
+
+	return conn.SetDeadline(time.Time{})
+}
+
+// Execute runs a command and returns the lines it printed
+func (c *TelnetClient) Execute(command string) (string, error) {
+	// A line break would send the rest as a second command
+	if strings.ContainsAny(command, "\r\n") {
+		return "", fmt.Errorf("%w: line breaks are not allowed", ErrInvalidCommand)
+	}
+
+	c.execMu.Lock()
+	defer c.execMu.Unlock()
+
+	c.mu.Lock()
+	conn, lines, done := c.conn, c.lines, c.done
+	c.mu.Unlock()
+	if conn == nil {
+		return "", ErrNotConnected
+	}
+
+	// Whatever was printed before the command is not part of its response
+	for drained := false; !drained; {
+		select {
+		case <-lines:
+		default:
+			drained = true
+		}
+	}
+
+	if err := c.send(conn, command); err != nil {
+		c.Close()
+		return "", fmt.Errorf("failed to send command: %w", err)
+	}
+
+	return c.readResponse(command, lines, done)
+}
+
+// readResponse collects a command's output from the lines the console prints
+func (c *TelnetClient) readResponse(command string, lines chan string, done chan struct{}) (string, error) {
+	executing := "Executing command '" + command + "'"
+	name, _, _ := strings.Cut(command, " ")
+	unknown := "*** ERROR: unknown command '" + name + "'"
+
+	deadline := time.NewTimer(c.timeout)
+	defer deadline.Stop()
+
+	// Armed once the command has started printing
+	quiet := time.NewTimer(telnetQuietPeriod)
+	quiet.Stop()
+	defer quiet.Stop()
+
+	var response []string
+	size, started := 0, false
+	for {
+		select {
+		case line := <-lines:
+			if !started {
+				switch {
+				case strings.Contains(line, executing):
+					started = true
+					quiet.Reset(telnetQuietPeriod)
+				case strings.HasPrefix(line, unknown):
+					// Unknown commands are rejected without being logged
+					return line, nil
+				}
+				continue
+			}
+			if telnetLogLine.MatchString(line) {
+				continue
+			}
+
+			size += len(line) + 1
+			if size > maxResponseSize {
+				return "", fmt.Errorf("%w: response exceeds %d bytes", ErrInvalidPacket, maxResponseSize)
+			}
+			response = append(response, line)
+			quiet.Reset(telnetQuietPeriod)
+
+		case <-quiet.C:
+			return strings.Join(response, "\n"), nil
+
+		case <-deadline.C:
+			if started {
+				// Output that never stops is cut off rather than lost
+				return strings.Join(response, "\n"), nil
+			}
+			return "", fmt.Errorf("no response to command after %s", c.timeout)
+
+		case <-done:
+			return "", ErrNotConnected
+		}
+	}
+}
+
+// Ping sends an empty line, which the console ignores. A connection the
+// server has closed is noticed by the read loop.
+func (c *TelnetClient) Ping() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	if err := c.send(conn, ""); err != nil {
+		c.Close()
+		return err
+	}
+	return nil
+}
+
+// Connected reports whether the connection is open
+func (c *TelnetClient) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn != nil
+}
+
+// Close closes the connection
+func (c *TelnetClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closeLocked()
+}
+
+// closeLocked closes the connection; the caller must hold c.mu
+func (c *TelnetClient) closeLocked() error {
+	if c.conn == nil {
+		return nil
+	}
+
+	err := c.conn.Close()
+	close(c.done)
+	c.conn = nil
+	return err
+}
+
+// send writes one line
+func (c *TelnetClient) send(conn net.Conn, line string) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+
+	_, err := conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+// readLoop reads lines until the connection closes. When nobody consumes
+// them the oldest are dropped, so a busy log never stalls the connection.
+func (c *TelnetClient) readLoop(conn net.Conn, r *telnetReader, lines chan string, done chan struct{}) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			select {
+			case <-done:
+			default:
+				c.logger.Warn("Telnet console connection failed", zap.Error(err))
+				c.mu.Lock()
+				if c.conn == conn {
+					c.closeLocked()
+				}
+				c.mu.Unlock()
+			}
+			return
+		}
+
+		select {
+		case lines <- line:
+		default:
+			// This loop is the only sender, so taking one out makes room
+			select {
+			case <-lines:
+			default:
+			}
+			lines <- line
+		}
+	}
+}
+
+// telnetReader reads text from a telnet connection, skipping the protocol's
+// option negotiation, which the 7 Days to Die console does not use
+type telnetReader struct {
+	r *bufio.Reader
+}
+
+// readByte returns the next byte of text
+func (t *telnetReader) readByte() (byte, error) {
+	for {
+		b, err := t.r.ReadByte()
+		if err != nil || b != telnetIAC {
+			return b, err
+		}
+
+		command, err := t.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case command == telnetIAC:
+			// An escaped 255 data byte
+			return command, nil
+		case command >= telnetWILL && command <= telnetDONT:
+			// WILL, WONT, DO and DONT are followed by the option
+			if _, err := t.r.ReadByte(); err != nil {
+				return 0, err
+			}
+		case command == telnetSB:
+			// Subnegotiation runs until IAC SE
+			for prev := byte(0); ; {
+				b, err := t.r.ReadByte()
+				if err != nil {
+					return 0, err
+				}
+				if prev == telnetIAC && b == telnetSE {
+					break
+				}
+				prev = b
+			}
+		}
+	}
+}
+
+// readLine reads one line without its line ending. Overlong lines are split.
+func (t *telnetReader) readLine() (string, error) {
+	var line []byte
+	for len(line) < maxPacketSize {
+		b, err := t.readByte()
+		if err != nil {
+			return "", err
+		}
+		if b == '\n' {
+			break
+		}
+		line = append(line, b)
+	}
+	return strings.TrimRight(string(line), "\r"), nil
+}
+
+// readUntil reads until the text matches one of markers, returning the
+// marker found. Prompts are matched without waiting for a line ending,
+// which they are not always given.
+func (t *telnetReader) readUntil(markers ...string) (string, error) {
+	var line []byte
+	for {
+		b, err := t.readByte()
+		if err != nil {
+			return "", err
+		}
+		if b == '\n' || len(line) >= maxPacketSize {
+			line = line[:0]
+			continue
+		}
+		line = append(line, b)
+
+		for _, marker := range markers {
+			if bytes.HasSuffix(line, []byte(marker)) {
+				return marker, nil
+			}
+		}
+	}
+}
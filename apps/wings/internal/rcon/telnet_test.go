@@ -0,0 +1,131 @@
+package rcon
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// fakeTelnetServer imitates the 7 Days to Die console. It negotiates an
+// option the client has to skip, prompts without a line ending, and logs
+// around every command's output as the real console does.
+type fakeTelnetServer struct {
+	listener net.Listener
+	password string
+}
+
+func newFakeTelnetServer(t *testing.T, password string) *fakeTelnetServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &fakeTelnetServer{listener: listener, password: password}
+	go s.serve()
+	return s
+}
+
+func (s *fakeTelnetServer) client(password string) *TelnetClient {
+	host, port := hostPort(s.listener.Addr().String())
+	return NewTelnetClient(host, port, password, zap.NewNop())
+}
+
+func (s *fakeTelnetServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeTelnetServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	conn.Write([]byte{telnetIAC, telnetWILL, 1})
+	conn.Write([]byte("*** Connected with 7DTD server.\r\nPlease enter password:"))
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if strings.TrimSpace(line) == s.password {
+			break
+		}
+		conn.Write([]byte("Password incorrect, please enter password:"))
+	}
+	conn.Write([]byte("Logon successful.\r\n\r\n"))
+
+	log := "2024-05-01T12:00:00 100.000 INF "
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimSpace(line)
+		if command == "" {
+			continue
+		}
+
+		var out strings.Builder
+		out.WriteString(log + "Player connected\r\n")
+		if command == "unknown" {
+			out.WriteString("*** ERROR: unknown command 'unknown'\r\n")
+		} else {
+			out.WriteString(log + "Executing command '" + command + "' by Telnet from 127.0.0.1:1234\r\n")
+			out.WriteString("Total of 2 in the game\r\n")
+			out.WriteString(log + "Time: 12:00\r\n")
+			out.WriteString(command + " done\r\n")
+		}
+		conn.Write([]byte(out.String()))
+	}
+}
+
+func TestTelnetClient(t *testing.T) {
+	server := newFakeTelnetServer(t, "secret")
+
+	client := server.client("secret")
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer client.Close()
+
+	for _, command := range []string{"lp", "version"} {
+		want := "Total of 2 in the game\n" + command + " done"
+		if response, err := client.Execute(command); err != nil || response != want {
+			t.Fatalf("Execute(%q) = %q, %v, want %q", command, response, err, want)
+		}
+	}
+
+	want := "*** ERROR: unknown command 'unknown'"
+	if response, err := client.Execute("unknown"); err != nil || response != want {
+		t.Fatalf("Execute unknown command = %q, %v, want %q", response, err, want)
+	}
+	if _, err := client.Execute("say hi\nshutdown"); !errors.Is(err, ErrInvalidCommand) {
+		t.Fatalf("Execute with line break error = %v, want %v", err, ErrInvalidCommand)
+	}
+	if err := client.Ping(); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+}
+
+func TestTelnetClientAuthFailure(t *testing.T) {
+	server := newFakeTelnetServer(t, "secret")
+
+	client := server.client("wrong")
+	if err := client.Connect(); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Connect error = %v, want %v", err, ErrAuthFailed)
+	}
+	if _, err := client.Execute("lp"); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("Execute after failed login error = %v, want %v", err, ErrNotConnected)
+	}
+}
@@ -0,0 +1,239 @@
+package rcon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"go.uber.org/zap"
+)
+
+// webRCONName is the name requests are sent under, shown in the server's log
+const webRCONName = "WebRcon"
+
+// webRCONMessage is the JSON object sent each way. Requests carry Name; the
+// server's replies carry Type, and messages it broadcasts, such as log
+// output and chat, have an Identifier of 0 or -1 rather than a request's.
+type webRCONMessage struct {
+	Identifier int32  `json:"Identifier"`
+	Message    string `json:"Message"`
+	Name       string `json:"Name,omitempty"`
+	Type       string `json:"Type,omitempty"`
+}
+
+// WebRCONClient is a client for WebRCON, the JSON over WebSocket console
+// Rust serves. Each command carries an identifier the reply echoes, so
+// several commands may be in flight at once and are matched as they arrive.
+type WebRCONClient struct {
+	host     string
+	port     int
+	password string
+	logger   *zap.Logger
+	timeout  time.Duration
+
+	conn    *websocket.Conn
+	done    chan struct{}         // Closed when conn is closed
+	pending map[int32]chan string // Identifier -> command awaiting its reply
+	nextID  int32
+	mu      sync.Mutex
+
+	// writeMu serializes writes, since a WebSocket allows one writer at a time
+	writeMu sync.Mutex
+}
+
+// NewWebRCONClient creates a new WebRCON client
+func NewWebRCONClient(host string, port int, password string, logger *zap.Logger) *WebRCONClient {
+	return &WebRCONClient{
+		host:     host,
+		port:     port,
+		password: password,
+		logger:   logger,
+		timeout:  10 * time.Second,
+	}
+}
+
+// Connect opens the WebSocket. The password is the request path, so a
+// wrong one fails the handshake.
+func (c *WebRCONClient) Connect() error {
+	u := url.URL{
+		Scheme:  "ws",
+		Host:    net.JoinHostPort(c.host, strconv.Itoa(c.port)),
+		Path:    "/" + c.password,
+		RawPath: "/" + url.PathEscape(c.password),
+	}
+
+	dialer := websocket.Dialer{HandshakeTimeout: c.timeout}
+	conn, resp, err := dialer.Dial(u.String(), nil)
+	if err != nil {
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			return fmt.Errorf("%w: handshake refused with status %d", ErrAuthFailed, resp.StatusCode)
+		}
+		// Rust drops the connection on a wrong password rather than answering it
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w: connection closed during handshake", ErrAuthFailed)
+		}
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	conn.SetReadLimit(maxResponseSize)
+
+	c.mu.Lock()
+	c.closeLocked()
+	c.conn = conn
+	c.done = make(chan struct{})
+	c.pending = make(map[int32]chan string)
+	done := c.done
+	c.mu.Unlock()
+
+	go c.readLoop(conn, done)
+
+	c.logger.Info("Connected to WebRCON server", zap.String("addr", u.Host))
+	return nil
+}
+
+// Execute runs a command and returns the server's reply to it
+func (c *WebRCONClient) Execute(command string) (string, error) {
+	c.mu.Lock()
+	if c.conn == nil {
+		c.mu.Unlock()
+		return "", ErrNotConnected
+	}
+
+	c.nextID = nextRequestID(c.nextID)
+	id := c.nextID
+	reply := make(chan string, 1)
+	c.pending[id] = reply
+	conn, done := c.conn, c.done
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	data, err := json.Marshal(webRCONMessage{
+		Identifier: id,
+		Message:    command,
+		Name:       webRCONName,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	c.writeMu.Lock()
+	conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	err = conn.WriteMessage(websocket.TextMessage, data)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.Close()
+		return "", fmt.Errorf("failed to send command: %w", err)
+	}
+
+	select {
+	case response := <-reply:
+		return response, nil
+	case <-done:
+		return "", ErrNotConnected
+	case <-time.After(c.timeout):
+		return "", fmt.Errorf("no response to command after %s", c.timeout)
+	}
+}
+
+// Ping sends a WebSocket ping, which checks the connection without
+// running a command
+func (c *WebRCONClient) Ping() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	c.writeMu.Lock()
+	err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.timeout))
+	c.writeMu.Unlock()
+	if err != nil {
+		c.Close()
+		return err
+	}
+	return nil
+}
+
+// Connected reports whether the connection is open
+func (c *WebRCONClient) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn != nil
+}
+
+// Close closes the connection
+func (c *WebRCONClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closeLocked()
+}
+
+// closeLocked closes the connection; the caller must hold c.mu
+func (c *WebRCONClient) closeLocked() error {
+	if c.conn == nil {
+		return nil
+	}
+
+	err := c.conn.Close()
+	close(c.done)
+	c.conn = nil
+	return err
+}
+
+// readLoop hands each reply to the command awaiting it until the connection closes
+func (c *WebRCONClient) readLoop(conn *websocket.Conn, done chan struct{}) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-done:
+			default:
+				c.logger.Warn("WebRCON connection failed", zap.Error(err))
+				c.mu.Lock()
+				if c.conn == conn {
+					c.closeLocked()
+				}
+				c.mu.Unlock()
+			}
+			return
+		}
+
+		var message webRCONMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			c.logger.Debug("Discarding invalid WebRCON message", zap.Error(err))
+			continue
+		}
+
+		c.mu.Lock()
+		reply, exists := c.pending[message.Identifier]
+		c.mu.Unlock()
+
+		if !exists {
+			// Broadcast output, or the reply to a command that timed out
+			c.logger.Debug("WebRCON server message",
+				zap.Int32("identifier", message.Identifier),
+				zap.String("type", message.Type),
+				zap.String("message", message.Message))
+			continue
+		}
+
+		select {
+		case reply <- message.Message:
+		default:
+		}
+	}
+}
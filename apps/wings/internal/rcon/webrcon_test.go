@@ -0,0 +1,113 @@
+package rcon
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"go.uber.org/zap"
+)
+
+// newFakeWebRCONServer serves WebRCON the way Rust does: the password is
+// the path, and each reply is preceded by a broadcast with identifier 0.
+// The "slow" command is answered after the ones sent behind it.
+func newFakeWebRCONServer(t *testing.T, password string) *httptest.Server {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var mu sync.Mutex
+		reply := func(message webRCONMessage) {
+			mu.Lock()
+			defer mu.Unlock()
+			conn.WriteJSON(webRCONMessage{Identifier: 0, Message: "log line", Type: "Generic"})
+			conn.WriteJSON(message)
+		}
+
+		for {
+			var request webRCONMessage
+			if err := conn.ReadJSON(&request); err != nil {
+				return
+			}
+
+			response := webRCONMessage{Identifier: request.Identifier, Message: request.Message + " done", Type: "Generic"}
+			if request.Message == "slow" {
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					reply(response)
+				}()
+				continue
+			}
+			reply(response)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func webRCONClient(server *httptest.Server, password string) *WebRCONClient {
+	host, port := hostPort(server.Listener.Addr().String())
+	return NewWebRCONClient(host, port, password, zap.NewNop())
+}
+
+func TestWebRCONClient(t *testing.T) {
+	server := newFakeWebRCONServer(t, "p@ss/word")
+
+	client := webRCONClient(server, "p@ss/word")
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer client.Close()
+
+	// Replies arriving out of order still reach the command they answer
+	commands := []string{"slow", "status", "playerlist"}
+	responses := make([]string, len(commands))
+	errs := make([]error, len(commands))
+
+	var wg sync.WaitGroup
+	for i, command := range commands {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i], errs[i] = client.Execute(command)
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+
+	for i, command := range commands {
+		if want := command + " done"; errs[i] != nil || responses[i] != want {
+			t.Fatalf("Execute(%q) = %q, %v, want %q", command, responses[i], errs[i], want)
+		}
+	}
+
+	if err := client.Ping(); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+}
+
+func TestWebRCONClientAuthFailure(t *testing.T) {
+	server := newFakeWebRCONServer(t, "secret")
+
+	client := webRCONClient(server, "wrong")
+	if err := client.Connect(); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Connect error = %v, want %v", err, ErrAuthFailed)
+	}
+	if _, err := client.Execute("status"); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("Execute after failed login error = %v, want %v", err, ErrNotConnected)
+	}
+}
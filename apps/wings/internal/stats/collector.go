@@ -0,0 +1,142 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// Snapshot is a decoded resource usage sample for a container.
+// All sizes are in bytes, CPU is a percentage of one core and uptime is in seconds.
+type Snapshot struct {
+	CPUUsage    float64 `json:"cpuUsage"`
+	MemoryUsage uint64  `json:"memoryUsage"`
+	MemoryLimit uint64  `json:"memoryLimit"`
+	DiskUsage   uint64  `json:"diskUsage"`
+	NetworkRx   uint64  `json:"networkRx"`
+	NetworkTx   uint64  `json:"networkTx"`
+	BlockRead   uint64  `json:"blockRead"`
+	BlockWrite  uint64  `json:"blockWrite"`
+	PIDs        uint64  `json:"pids"`
+	Uptime      int64   `json:"uptime"`
+}
+
+// Collector reads and decodes container resource statistics
+type Collector struct {
+	dockerClient *client.Client
+}
+
+// NewCollector creates a new stats collector
+func NewCollector(dockerClient *client.Client) *Collector {
+	return &Collector{
+		dockerClient: dockerClient,
+	}
+}
+
+// Collect takes a single stats sample for a container
+func (c *Collector) Collect(ctx context.Context, containerID string) (*Snapshot, error) {
+	// Non-streaming stats still wait for a second reading so PreCPUStats is populated
+	stats, err := c.dockerClient.ContainerStats(ctx, containerID, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container stats: %w", err)
+	}
+	defer stats.Body.Close()
+
+	var raw types.StatsJSON
+	if err := json.NewDecoder(stats.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to decode stats: %w", err)
+	}
+
+	snapshot := Decode(&raw)
+
+	uptime, err := c.Uptime(ctx, containerID)
+	if err == nil {
+		snapshot.Uptime = uptime
+	}
+
+	return &snapshot, nil
+}
+
+// Uptime returns how long a container has been running in seconds, or zero if it is stopped
+func (c *Collector) Uptime(ctx context.Context, containerID string) (int64, error) {
+	inspect, err := c.dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	if inspect.State == nil || !inspect.State.Running || inspect.State.StartedAt == "" {
+		return 0, nil
+	}
+
+	startTime, err := time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse start time: %w", err)
+	}
+
+	return int64(time.Since(startTime).Seconds()), nil
+}
+
+// Decode converts a raw Docker stats payload into a Snapshot. Uptime is not
+// part of the stats payload and is left at zero.
+func Decode(raw *types.StatsJSON) Snapshot {
+	snapshot := Snapshot{
+		CPUUsage:    CalculateCPUPercent(raw),
+		MemoryUsage: memoryUsage(&raw.MemoryStats),
+		MemoryLimit: raw.MemoryStats.Limit,
+		DiskUsage:   raw.StorageStats.WriteSizeBytes,
+		PIDs:        raw.PidsStats.Current,
+	}
+
+	for _, network := range raw.Networks {
+		snapshot.NetworkRx += network.RxBytes
+		snapshot.NetworkTx += network.TxBytes
+	}
+
+	for _, entry := range raw.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			snapshot.BlockRead += entry.Value
+		case "write":
+			snapshot.BlockWrite += entry.Value
+		}
+	}
+
+	return snapshot
+}
+
+// CalculateCPUPercent calculates CPU usage percentage
+func CalculateCPUPercent(stats *types.StatsJSON) float64 {
+	// Calculate CPU usage percentage based on Docker stats
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+
+	onlineCPUs := float64(stats.CPUStats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+
+	if systemDelta > 0.0 && cpuDelta > 0.0 {
+		return (cpuDelta / systemDelta) * onlineCPUs * 100.0
+	}
+
+	return 0.0
+}
+
+// memoryUsage returns memory in use excluding reclaimable page cache, matching `docker stats`
+func memoryUsage(mem *types.MemoryStats) uint64 {
+	// cgroup v1 reports total_inactive_file, cgroup v2 reports inactive_file
+	cache, ok := mem.Stats["total_inactive_file"]
+	if !ok {
+		cache = mem.Stats["inactive_file"]
+	}
+
+	if cache < mem.Usage {
+		return mem.Usage - cache
+	}
+	return mem.Usage
+}
@@ -0,0 +1,127 @@
+package stats
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+// loadStats reads a Docker stats payload from testdata
+func loadStats(t *testing.T, name string) *types.StatsJSON {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw types.StatsJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatalf("failed to parse %s: %v", name, err)
+	}
+	return &raw
+}
+
+func TestDecode(t *testing.T) {
+	for _, tt := range []struct {
+		fixture string
+		want    Snapshot
+	}{
+		{
+			// Without online_cpus the per-CPU usage gives the core count, and
+			// total_inactive_file wins over the container's own inactive_file
+			fixture: "stats_cgroup_v1.json",
+			want: Snapshot{
+				CPUUsage:    40,
+				MemoryUsage: 524288000 - 104857600,
+				MemoryLimit: 1073741824,
+				NetworkRx:   1300,
+				NetworkTx:   2400,
+				BlockRead:   1048576 + 4096,
+				BlockWrite:  2097152 + 8192,
+				PIDs:        42,
+			},
+		},
+		{
+			fixture: "stats_cgroup_v2.json",
+			want: Snapshot{
+				CPUUsage:    30,
+				MemoryUsage: 268435456 - 67108864,
+				MemoryLimit: 536870912,
+				NetworkRx:   5000,
+				NetworkTx:   7000,
+				BlockRead:   65536,
+				BlockWrite:  131072,
+				PIDs:        17,
+			},
+		},
+	} {
+		t.Run(tt.fixture, func(t *testing.T) {
+			got := Decode(loadStats(t, tt.fixture))
+
+			if math.Abs(got.CPUUsage-tt.want.CPUUsage) > 1e-9 {
+				t.Fatalf("CPUUsage = %v, want %v", got.CPUUsage, tt.want.CPUUsage)
+			}
+			got.CPUUsage = tt.want.CPUUsage
+			if got != tt.want {
+				t.Fatalf("Decode = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateCPUPercent(t *testing.T) {
+	for _, tt := range []struct {
+		name              string
+		total, preTotal   uint64
+		system, preSystem uint64
+		onlineCPUs        uint32
+		percpu            []uint64
+		want              float64
+	}{
+		{name: "online CPUs", total: 2e9, preTotal: 1e9, system: 2e10, preSystem: 1e10, onlineCPUs: 4, want: 40},
+		{name: "per-CPU fallback", total: 2e9, preTotal: 1e9, system: 2e10, preSystem: 1e10, percpu: []uint64{1, 1}, want: 20},
+		{name: "idle", total: 1e9, preTotal: 1e9, system: 2e10, preSystem: 1e10, onlineCPUs: 4, want: 0},
+		{name: "no system time passed", total: 2e9, preTotal: 1e9, system: 1e10, preSystem: 1e10, onlineCPUs: 4, want: 0},
+		{name: "counter reset", total: 1e9, preTotal: 2e9, system: 2e10, preSystem: 1e10, onlineCPUs: 4, want: 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw types.StatsJSON
+			raw.CPUStats.CPUUsage.TotalUsage = tt.total
+			raw.CPUStats.CPUUsage.PercpuUsage = tt.percpu
+			raw.CPUStats.SystemUsage = tt.system
+			raw.CPUStats.OnlineCPUs = tt.onlineCPUs
+			raw.PreCPUStats.CPUUsage.TotalUsage = tt.preTotal
+			raw.PreCPUStats.SystemUsage = tt.preSystem
+
+			if got := CalculateCPUPercent(&raw); math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("CalculateCPUPercent = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		usage uint64
+		stats map[string]uint64
+		want  uint64
+	}{
+		{name: "cgroup v1", usage: 1000, stats: map[string]uint64{"total_inactive_file": 300, "inactive_file": 100}, want: 700},
+		{name: "cgroup v2", usage: 1000, stats: map[string]uint64{"inactive_file": 100}, want: 900},
+		{name: "no page cache reported", usage: 1000, stats: nil, want: 1000},
+		{name: "cache larger than usage", usage: 1000, stats: map[string]uint64{"inactive_file": 2000}, want: 1000},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := types.MemoryStats{Usage: tt.usage, Stats: tt.stats}
+			if got := memoryUsage(&mem); got != tt.want {
+				t.Fatalf("memoryUsage = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
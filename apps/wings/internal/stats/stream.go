@@ -0,0 +1,294 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/gofiber/contrib/websocket"
+	"go.uber.org/zap"
+)
+
+// StreamMessage is a stats sample sent to WebSocket subscribers
+type StreamMessage struct {
+	Type      string   `json:"type"`      // "stats"
+	Timestamp string   `json:"timestamp"` // ISO 8601 timestamp
+	Data      Snapshot `json:"data"`
+}
+
+// dockerAPI is the part of the Docker client stats streams use
+type dockerAPI interface {
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
+	ContainerStats(ctx context.Context, containerID string, stream bool) (types.ContainerStats, error)
+}
+
+// Stream shares one streaming Docker stats request between WebSocket subscribers
+type Stream struct {
+	serverID     string
+	containerID  string
+	dockerClient dockerAPI
+	logger       *zap.Logger
+	onEnd        func(*Stream)
+
+	// WebSocket connections
+	clients     map[*websocket.Conn]bool
+	clientsLock sync.RWMutex
+
+	// Stream control
+	ctx       context.Context
+	cancel    context.CancelFunc
+	startedAt time.Time
+}
+
+// NewStream creates a new stats stream
+func NewStream(serverID, containerID string, dockerClient *client.Client, logger *zap.Logger) *Stream {
+	return newStream(serverID, containerID, dockerClient, logger)
+}
+
+func newStream(serverID, containerID string, dockerClient dockerAPI, logger *zap.Logger) *Stream {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Stream{
+		serverID:     serverID,
+		containerID:  containerID,
+		dockerClient: dockerClient,
+		logger:       logger,
+		clients:      make(map[*websocket.Conn]bool),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// AddClient adds a WebSocket client to the stream
+func (s *Stream) AddClient(conn *websocket.Conn) {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+
+	s.clients[conn] = true
+	s.logger.Debug("Client added to stats stream",
+		zap.String("serverID", s.serverID),
+		zap.Int("totalClients", len(s.clients)))
+}
+
+// RemoveClient removes a WebSocket client and returns the number still subscribed
+func (s *Stream) RemoveClient(conn *websocket.Conn) int {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+
+	delete(s.clients, conn)
+	s.logger.Debug("Client removed from stats stream",
+		zap.String("serverID", s.serverID),
+		zap.Int("totalClients", len(s.clients)))
+
+	return len(s.clients)
+}
+
+// Start opens the streaming Docker stats request
+func (s *Stream) Start() error {
+	s.logger.Info("Starting stats stream", zap.String("serverID", s.serverID))
+
+	// Uptime is derived from the start time rather than inspecting every sample
+	inspect, err := s.dockerClient.ContainerInspect(s.ctx, s.containerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if inspect.State != nil && inspect.State.Running {
+		if startedAt, err := time.Parse(time.RFC3339Nano, inspect.State.StartedAt); err == nil {
+			s.startedAt = startedAt
+		}
+	}
+
+	stats, err := s.dockerClient.ContainerStats(s.ctx, s.containerID, true)
+	if err != nil {
+		return fmt.Errorf("failed to open stats stream: %w", err)
+	}
+
+	go s.streamStats(stats.Body)
+
+	return nil
+}
+
+// Stop closes the Docker stats request and disconnects all clients
+func (s *Stream) Stop() {
+	s.logger.Info("Stopping stats stream", zap.String("serverID", s.serverID))
+	s.cancel()
+
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+
+	for conn := range s.clients {
+		conn.Close()
+	}
+	s.clients = make(map[*websocket.Conn]bool)
+}
+
+// streamStats decodes stats from Docker and broadcasts them to clients
+func (s *Stream) streamStats(body io.ReadCloser) {
+	defer body.Close()
+
+	decoder := json.NewDecoder(body)
+	for {
+		var raw types.StatsJSON
+		if err := decoder.Decode(&raw); err != nil {
+			if err != io.EOF && s.ctx.Err() == nil {
+				s.logger.Error("Error reading stats stream", zap.String("serverID", s.serverID), zap.Error(err))
+			}
+			break
+		}
+
+		snapshot := Decode(&raw)
+		if !s.startedAt.IsZero() {
+			snapshot.Uptime = int64(time.Since(s.startedAt).Seconds())
+		}
+
+		s.broadcast(StreamMessage{
+			Type:      "stats",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Data:      snapshot,
+		})
+	}
+
+	s.logger.Info("Stats stream ended", zap.String("serverID", s.serverID))
+
+	// The container stopped or the stream was cancelled; subscribers reconnect
+	// once the server is running again
+	if s.onEnd != nil {
+		s.onEnd(s)
+	}
+}
+
+// broadcast sends a stats message to all connected clients
+func (s *Stream) broadcast(message StreamMessage) {
+	s.clientsLock.RLock()
+	defer s.clientsLock.RUnlock()
+
+	if len(s.clients) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		s.logger.Error("Failed to marshal stats message", zap.Error(err))
+		return
+	}
+
+	for conn := range s.clients {
+		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			s.logger.Debug("Failed to send stats to client", zap.Error(err))
+			// The client's read loop unsubscribes it once the connection closes
+			conn.Close()
+		}
+	}
+}
+
+// Manager multiplexes stats subscribers onto one Docker stream per container
+type Manager struct {
+	streams     map[string]*Stream // serverID -> Stream
+	streamsLock sync.Mutex
+
+	dockerClient dockerAPI
+	logger       *zap.Logger
+}
+
+// NewManager creates a new stats stream manager
+func NewManager(dockerClient *client.Client, logger *zap.Logger) *Manager {
+	return &Manager{
+		streams:      make(map[string]*Stream),
+		dockerClient: dockerClient,
+		logger:       logger,
+	}
+}
+
+// Subscribe attaches a client to the server's stats stream, starting it if needed
+func (m *Manager) Subscribe(serverID, containerID string, conn *websocket.Conn) error {
+	m.streamsLock.Lock()
+	if stream, exists := m.streams[serverID]; exists {
+		stream.AddClient(conn)
+		m.streamsLock.Unlock()
+		return nil
+	}
+	m.streamsLock.Unlock()
+
+	// Starting talks to Docker, so it happens outside the lock to not hold
+	// up subscribers of every other server
+	stream := newStream(serverID, containerID, m.dockerClient, m.logger)
+	stream.onEnd = m.remove
+	if err := stream.Start(); err != nil {
+		stream.cancel()
+		return err
+	}
+
+	m.streamsLock.Lock()
+	defer m.streamsLock.Unlock()
+
+	// Another subscriber may have started one meanwhile
+	if current, exists := m.streams[serverID]; exists {
+		stream.Stop()
+		current.AddClient(conn)
+		return nil
+	}
+	// The Docker request may already have ended, before it could be removed
+	if stream.ctx.Err() != nil {
+		return fmt.Errorf("stats stream for %s ended", serverID)
+	}
+
+	m.streams[serverID] = stream
+	stream.AddClient(conn)
+	return nil
+}
+
+// Unsubscribe detaches a client, stopping the Docker stream when it was the last one
+func (m *Manager) Unsubscribe(serverID string, conn *websocket.Conn) {
+	m.streamsLock.Lock()
+	defer m.streamsLock.Unlock()
+
+	stream, exists := m.streams[serverID]
+	if !exists {
+		return
+	}
+
+	if stream.RemoveClient(conn) == 0 {
+		stream.Stop()
+		delete(m.streams, serverID)
+	}
+}
+
+// RemoveStream stops a server's stats stream and disconnects its
+// subscribers, used when the server is deleted
+func (m *Manager) RemoveStream(serverID string) {
+	m.streamsLock.Lock()
+	stream, exists := m.streams[serverID]
+	delete(m.streams, serverID)
+	m.streamsLock.Unlock()
+
+	if exists {
+		stream.Stop()
+	}
+}
+
+// StopAll stops every stats stream
+func (m *Manager) StopAll() {
+	m.streamsLock.Lock()
+	defer m.streamsLock.Unlock()
+
+	for serverID, stream := range m.streams {
+		stream.Stop()
+		delete(m.streams, serverID)
+	}
+}
+
+// remove drops a stream whose Docker stats request has ended
+func (m *Manager) remove(stream *Stream) {
+	m.streamsLock.Lock()
+	if current, exists := m.streams[stream.serverID]; exists && current == stream {
+		delete(m.streams, stream.serverID)
+	}
+	m.streamsLock.Unlock()
+
+	stream.Stop()
+}
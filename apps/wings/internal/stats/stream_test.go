@@ -0,0 +1,181 @@
+package stats
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/gofiber/contrib/websocket"
+	"go.uber.org/zap"
+)
+
+// fakeDocker is a stats source whose streams stay open until their request is
+// cancelled. Inspecting a container listed in block reports it on waiting,
+// then waits for its channel to close.
+type fakeDocker struct {
+	block   map[string]chan struct{}
+	waiting chan string
+
+	mu      sync.Mutex
+	streams map[string][]context.Context
+}
+
+func (d *fakeDocker) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	if wait, ok := d.block[containerID]; ok {
+		d.waiting <- containerID
+		<-wait
+	}
+	return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+		State: &types.ContainerState{Running: true, StartedAt: time.Now().Format(time.RFC3339Nano)},
+	}}, nil
+}
+
+func (d *fakeDocker) ContainerStats(ctx context.Context, containerID string, stream bool) (types.ContainerStats, error) {
+	d.mu.Lock()
+	if d.streams == nil {
+		d.streams = make(map[string][]context.Context)
+	}
+	d.streams[containerID] = append(d.streams[containerID], ctx)
+	d.mu.Unlock()
+
+	reader, writer := io.Pipe()
+	go func() {
+		<-ctx.Done()
+		writer.CloseWithError(ctx.Err())
+	}()
+	return types.ContainerStats{Body: reader}, nil
+}
+
+// opened returns the requests made for a container's stats so far
+func (d *fakeDocker) opened(containerID string) []context.Context {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]context.Context(nil), d.streams[containerID]...)
+}
+
+func newTestManager(dockerClient dockerAPI) *Manager {
+	return &Manager{
+		streams:      make(map[string]*Stream),
+		dockerClient: dockerClient,
+		logger:       zap.NewNop(),
+	}
+}
+
+// Subscribers are never written to, since the fake streams send no samples
+func newTestConn() *websocket.Conn {
+	return &websocket.Conn{}
+}
+
+func TestManagerSharesStream(t *testing.T) {
+	docker := &fakeDocker{}
+	m := newTestManager(docker)
+	first, second := newTestConn(), newTestConn()
+
+	for _, conn := range []*websocket.Conn{first, second} {
+		if err := m.Subscribe("srv", "ctr", conn); err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+	}
+	requests := docker.opened("ctr")
+	if len(requests) != 1 {
+		t.Fatalf("%d stats requests for two subscribers, want 1", len(requests))
+	}
+
+	// The stream outlives all but its last subscriber
+	m.Unsubscribe("srv", first)
+	if requests[0].Err() != nil {
+		t.Fatalf("stats request cancelled with a subscriber left")
+	}
+	m.Unsubscribe("srv", second)
+	if requests[0].Err() == nil {
+		t.Fatalf("stats request still open after the last subscriber left")
+	}
+
+	// The next subscriber opens a new one
+	third := newTestConn()
+	if err := m.Subscribe("srv", "ctr", third); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if requests := docker.opened("ctr"); len(requests) != 2 || requests[1].Err() != nil {
+		t.Fatalf("resubscribing did not open a new stats request")
+	}
+	m.Unsubscribe("srv", third)
+}
+
+func TestManagerStartsOutsideLock(t *testing.T) {
+	docker := &fakeDocker{block: map[string]chan struct{}{"slow": make(chan struct{})}, waiting: make(chan string)}
+	m := newTestManager(docker)
+	slow, fast := newTestConn(), newTestConn()
+
+	started := make(chan error)
+	go func() { started <- m.Subscribe("slow-srv", "slow", slow) }()
+	<-docker.waiting
+
+	// A server whose container is slow to answer holds up nobody else
+	subscribed := make(chan error)
+	go func() { subscribed <- m.Subscribe("fast-srv", "fast", fast) }()
+	select {
+	case err := <-subscribed:
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Subscribe blocked behind another server's stream starting")
+	}
+
+	close(docker.block["slow"])
+	if err := <-started; err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	m.Unsubscribe("slow-srv", slow)
+	m.Unsubscribe("fast-srv", fast)
+}
+
+func TestManagerConcurrentStart(t *testing.T) {
+	docker := &fakeDocker{block: map[string]chan struct{}{"ctr": make(chan struct{})}, waiting: make(chan string)}
+	m := newTestManager(docker)
+	conns := []*websocket.Conn{newTestConn(), newTestConn()}
+
+	// Both subscribers start a stream before either is registered
+	errs := make(chan error)
+	for _, conn := range conns {
+		go func() { errs <- m.Subscribe("srv", "ctr", conn) }()
+	}
+	for range conns {
+		<-docker.waiting
+	}
+	close(docker.block["ctr"])
+	for range conns {
+		if err := <-errs; err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+	}
+
+	// Only one is kept; the other's request is cancelled
+	requests := docker.opened("ctr")
+	if len(requests) != 2 {
+		t.Fatalf("%d stats requests, want one per subscriber", len(requests))
+	}
+	var open int
+	for _, request := range requests {
+		if request.Err() == nil {
+			open++
+		}
+	}
+	if open != 1 {
+		t.Fatalf("%d stats requests open, want 1", open)
+	}
+
+	for _, conn := range conns {
+		m.Unsubscribe("srv", conn)
+	}
+	for _, request := range docker.opened("ctr") {
+		if request.Err() == nil {
+			t.Fatalf("stats request still open after the last subscriber left")
+		}
+	}
+}